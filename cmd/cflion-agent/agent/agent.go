@@ -0,0 +1,183 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cflion/cflion/pkg/client"
+	"github.com/cflion/cflion/pkg/common"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Config defines where the config files of an app are rendered and how the process is reloaded.
+// Raw files are rendered only when Dir is set.
+type Config struct {
+	App       string            `mapstructure:"name"`
+	Dir       string            `mapstructure:"dir"`
+	FileMode  os.FileMode       `mapstructure:"fileMode"`
+	Templates []*TemplateConfig `mapstructure:"templates"`
+	Reload    *ReloadConfig     `mapstructure:"reload"`
+}
+
+// Agent renders the config files of an app published in etcd to a directory and the templates,
+// then reloads the process after any file changes.
+type Agent struct {
+	cfg       *Config
+	clientCfg *client.Config
+	cli       *client.Client
+	templates []*configTemplate
+
+	mu       sync.Mutex
+	rendered map[string]struct{}
+}
+
+// New creates an agent, the templates are parsed in advance.
+func New(cfg *Config, clientCfg *client.Config) (*Agent, error) {
+	if cfg.FileMode == 0 {
+		cfg.FileMode = 0644
+	}
+	templates := make([]*configTemplate, 0, len(cfg.Templates))
+	for _, tc := range cfg.Templates {
+		ct, err := newConfigTemplate(tc)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, ct)
+	}
+	return &Agent{cfg: cfg, clientCfg: clientCfg, templates: templates, rendered: make(map[string]struct{})}, nil
+}
+
+// Start loads the config of the app, renders the files and keeps them updated.
+func (agent *Agent) Start() error {
+	cli, err := client.New(agent.clientCfg)
+	if err != nil {
+		return err
+	}
+	agent.cli = cli
+	cli.OnChange(func(event *client.ChangeEvent) {
+		log.Infof("App [name=%s] config changed at [revision=%d]: %s", agent.cfg.App, event.Revision, event.Changes)
+		agent.sync(event)
+	})
+	agent.sync(nil)
+	return nil
+}
+
+// Stop stops watching the config.
+func (agent *Agent) Stop() error {
+	if agent.cli == nil {
+		return nil
+	}
+	return agent.cli.Close()
+}
+
+// sync renders the files and the templates affected by the event, and reloads the process
+// if any of them changed. A nil event renders all the templates.
+func (agent *Agent) sync(event *client.ChangeEvent) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	changed := false
+	if len(agent.cfg.Dir) > 0 {
+		changed = agent.render(agent.cli.ConfigFiles())
+	}
+	if agent.renderTemplates(agent.cli, event) {
+		changed = true
+	}
+	if changed && agent.cfg.Reload != nil {
+		if err := agent.cfg.Reload.Do(); err != nil {
+			log.Errorf("Reload app [name=%s] error: %s", agent.cfg.App, err)
+		}
+	}
+}
+
+// render writes every config file to the directory, the files removed from the app are deleted.
+func (agent *Agent) render(cfs []*api.ConfigFile) bool {
+	changed := false
+	current := make(map[string]struct{}, len(cfs))
+	for _, cf := range cfs {
+		filename, err := agent.path(cf.Name)
+		if err != nil {
+			log.Errorf("Render config file [%s] of app [name=%s] error: %s", cf.Name, agent.cfg.App, err)
+			continue
+		}
+		current[filename] = struct{}{}
+		written, err := writeIfChanged(filename, []byte(cf.ConfigFmt()+"\n"), agent.cfg.FileMode)
+		if err != nil {
+			log.Errorf("Write config file [%s] error: %s", filename, err)
+			continue
+		}
+		if written {
+			log.Infof("Render config file [%s] of app [name=%s]", filename, agent.cfg.App)
+			changed = true
+		}
+	}
+	for filename := range agent.rendered {
+		if _, ok := current[filename]; ok {
+			continue
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Remove config file [%s] error: %s", filename, err)
+			continue
+		}
+		log.Infof("Remove config file [%s] of app [name=%s]", filename, agent.cfg.App)
+		changed = true
+	}
+	agent.rendered = current
+	return changed
+}
+
+// renderTemplates renders the templates referring to the changes.
+func (agent *Agent) renderTemplates(src source, event *client.ChangeEvent) bool {
+	changed := false
+	for _, ct := range agent.templates {
+		if !ct.affected(event) {
+			continue
+		}
+		written, err := ct.render(src)
+		if err != nil {
+			log.Errorf("Render template of app [name=%s] error: %s", agent.cfg.App, err)
+			continue
+		}
+		if written {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// path returns the path of the config file in the directory, the name must not escape the directory.
+func (agent *Agent) path(name string) (string, error) {
+	if len(name) == 0 || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name [%s]", name)
+	}
+	return filepath.Join(agent.cfg.Dir, name), nil
+}
+
+// writeIfChanged writes data to the file atomically unless the file already has the same content.
+func writeIfChanged(filename string, data []byte, perm os.FileMode) (bool, error) {
+	old, err := ioutil.ReadFile(filename)
+	if err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if err = common.WriteFileAtomic(filename, data, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,55 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"github.com/cflion/cflion/pkg/manager/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAgent_render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	agent, err := New(&Config{App: "demo", Dir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs := api.ParseConfigFmt("[db]\n# db host\nhost=127.0.0.1\n\n[redis]\naddr=127.0.0.1:6379\n\n[../evil]\nk=v\n")
+	if !agent.render(cfs) {
+		t.Fatal("expect files rendered")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "db"))
+	if err != nil || string(b) != "# db host\nhost=127.0.0.1\n" {
+		t.Errorf("unexpected db file %q: %v", b, err)
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(dir), "evil")); !os.IsNotExist(err) {
+		t.Errorf("file must not escape the directory")
+	}
+	if agent.render(cfs) {
+		t.Errorf("expect nothing changed")
+	}
+	if !agent.render(cfs[:1]) {
+		t.Errorf("expect redis removed")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "redis")); !os.IsNotExist(err) {
+		t.Errorf("expect redis removed, got %v", err)
+	}
+}
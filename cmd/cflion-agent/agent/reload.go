@@ -0,0 +1,98 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"github.com/cflion/cflion/pkg/log"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultReloadTimeout = 30 * time.Second
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// ReloadConfig defines how to reload the process after its config files change,
+// either running Command or sending Signal to the process whose pid is in PidFile.
+type ReloadConfig struct {
+	Command string        `mapstructure:"command"`
+	Signal  string        `mapstructure:"signal"`
+	PidFile string        `mapstructure:"pidFile"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Do reloads the process.
+func (reload *ReloadConfig) Do() error {
+	if len(reload.Command) > 0 {
+		return reload.run()
+	}
+	if len(reload.Signal) > 0 {
+		return reload.signal()
+	}
+	return nil
+}
+
+func (reload *ReloadConfig) run() error {
+	timeout := reload.Timeout
+	if timeout <= 0 {
+		timeout = defaultReloadTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", reload.Command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("run [%s] error: %s, output: %s", reload.Command, err, out)
+	}
+	log.Infof("Run reload command [%s], output: %s", reload.Command, out)
+	return nil
+}
+
+func (reload *ReloadConfig) signal() error {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(reload.Signal), "SIG")]
+	if !ok {
+		return fmt.Errorf("unsupported signal [%s]", reload.Signal)
+	}
+	b, err := ioutil.ReadFile(reload.PidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file [%s]: %s", reload.PidFile, err)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err = process.Signal(sig); err != nil {
+		return err
+	}
+	log.Infof("Send signal [%s] to process [pid=%d]", sig, pid)
+	return nil
+}
@@ -0,0 +1,127 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cflion/cflion/pkg/client"
+	"github.com/cflion/cflion/pkg/log"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// TemplateConfig defines a text/template file rendered with the config of an app.
+//
+// The template refers to the config by the functions:
+//
+// {{ cfg "db" "host" }} the value of host in db, empty if it doesn't exist
+//
+// {{ cfgOr "db" "port" "3306" }} the value of port in db, or 3306 if it doesn't exist
+//
+// {{ range $k, $v := file "db" }}{{ $k }}={{ $v }}{{ end }} all the key/value pairs in db
+type TemplateConfig struct {
+	Src      string      `mapstructure:"src"`
+	Dest     string      `mapstructure:"dest"`
+	FileMode os.FileMode `mapstructure:"fileMode"`
+}
+
+// source provides the config referred by the templates.
+type source interface {
+	Get(file, key string) (string, bool)
+	File(file string) map[string]string
+}
+
+// dependency is an item referred by a template, an empty key refers to the whole file.
+type dependency struct {
+	file string
+	key  string
+}
+
+type configTemplate struct {
+	cfg      *TemplateConfig
+	tmpl     *template.Template
+	deps     map[dependency]struct{}
+	rendered bool
+}
+
+func newConfigTemplate(cfg *TemplateConfig) (*configTemplate, error) {
+	if cfg.FileMode == 0 {
+		cfg.FileMode = 0644
+	}
+	tmpl, err := template.New(filepath.Base(cfg.Src)).Funcs(templateFuncs(nil, nil)).ParseFiles(cfg.Src)
+	if err != nil {
+		return nil, fmt.Errorf("parse template [%s] error: %s", cfg.Src, err)
+	}
+	return &configTemplate{cfg: cfg, tmpl: tmpl}, nil
+}
+
+// templateFuncs returns the functions reading the config from src and recording the dependencies.
+func templateFuncs(src source, deps map[dependency]struct{}) template.FuncMap {
+	return template.FuncMap{
+		"cfg": func(file, key string) string {
+			deps[dependency{file: file, key: key}] = struct{}{}
+			value, _ := src.Get(file, key)
+			return value
+		},
+		"cfgOr": func(file, key, def string) string {
+			deps[dependency{file: file, key: key}] = struct{}{}
+			if value, ok := src.Get(file, key); ok {
+				return value
+			}
+			return def
+		},
+		"file": func(file string) map[string]string {
+			deps[dependency{file: file}] = struct{}{}
+			return src.File(file)
+		},
+	}
+}
+
+// affected reports whether the template refers to any of the changes.
+func (ct *configTemplate) affected(event *client.ChangeEvent) bool {
+	if !ct.rendered || event == nil {
+		return true
+	}
+	for _, change := range event.Changes {
+		if _, ok := ct.deps[dependency{file: change.File}]; ok {
+			return true
+		}
+		if _, ok := ct.deps[dependency{file: change.File, key: change.Key}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// render executes the template and writes the destination if the output differs from it.
+func (ct *configTemplate) render(src source) (bool, error) {
+	deps := make(map[dependency]struct{}, len(ct.deps))
+	var buf bytes.Buffer
+	if err := ct.tmpl.Funcs(templateFuncs(src, deps)).Execute(&buf, nil); err != nil {
+		return false, fmt.Errorf("execute template [%s] error: %s", ct.cfg.Src, err)
+	}
+	written, err := writeIfChanged(ct.cfg.Dest, buf.Bytes(), ct.cfg.FileMode)
+	if err != nil {
+		return false, err
+	}
+	ct.deps = deps
+	ct.rendered = true
+	if written {
+		log.Infof("Render template [%s] to [%s]", ct.cfg.Src, ct.cfg.Dest)
+	}
+	return written, nil
+}
@@ -0,0 +1,72 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"github.com/cflion/cflion/pkg/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeSource map[string]map[string]string
+
+func (src fakeSource) Get(file, key string) (string, bool) {
+	value, ok := src[file][key]
+	return value, ok
+}
+
+func (src fakeSource) File(file string) map[string]string {
+	return src[file]
+}
+
+func TestConfigTemplate_render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmplPath := filepath.Join(dir, "app.conf.tmpl")
+	destPath := filepath.Join(dir, "app.conf")
+	tmpl := `host={{ cfg "db" "host" }} port={{ cfgOr "db" "port" "3306" }}{{ range $k, $v := file "redis" }} {{ $k }}={{ $v }}{{ end }}`
+	if err = ioutil.WriteFile(tmplPath, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := newConfigTemplate(&TemplateConfig{Src: tmplPath, Dest: destPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := fakeSource{"db": {"host": "127.0.0.1", "user": "root"}, "redis": {"addr": "127.0.0.1:6379"}}
+	if written, err := ct.render(src); err != nil || !written {
+		t.Fatalf("expect template rendered, got %t %v", written, err)
+	}
+	b, _ := ioutil.ReadFile(destPath)
+	if string(b) != "host=127.0.0.1 port=3306 addr=127.0.0.1:6379" {
+		t.Errorf("unexpected output %q", b)
+	}
+	if written, _ := ct.render(src); written {
+		t.Errorf("expect identical output skipped")
+	}
+	unrelated := &client.ChangeEvent{Changes: []*client.Change{{File: "db", Key: "user"}}}
+	if ct.affected(unrelated) {
+		t.Errorf("expect template not affected by db.user")
+	}
+	for _, change := range []*client.Change{{File: "db", Key: "port"}, {File: "redis", Key: "timeout"}} {
+		if !ct.affected(&client.ChangeEvent{Changes: []*client.Change{change}}) {
+			t.Errorf("expect template affected by %s", change)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"github.com/cflion/cflion/cmd/cflion-agent/agent"
+	"github.com/cflion/cflion/pkg/client"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// init setting
+func init() {
+	confPath := flag.String("conf", "conf/app.yml", "path of app.yml")
+	flag.Parse()
+	// set default config
+	viper.SetDefault("manager.endpoint", "http://127.0.0.1:8080")
+	viper.SetDefault("logging.level", "INFO")
+	viper.SetDefault("etcd.dialTimeout", 5)
+	viper.SetDefault("etcd.requestTimeout", 3)
+	viper.SetConfigFile(*confPath)
+	viper.AddConfigPath(".")
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.Errorf("Fatal error config file: %s", err)
+		os.Exit(1)
+	}
+	// init global logger
+	log.SetLevel(viper.GetString("logging.level"))
+	filePath := viper.GetString("logging.file")
+	if len(filePath) > 0 {
+		f, _ := os.Create(filePath)
+		log.SetOutput(f)
+	}
+}
+
+func main() {
+	var cfgs []*agent.Config
+	if err := viper.UnmarshalKey("apps", &cfgs); err != nil {
+		log.Errorf("Fatal error config apps: %s", err)
+		os.Exit(1)
+	}
+	if len(cfgs) == 0 {
+		log.Error("Fatal error config apps: no app to watch")
+		os.Exit(1)
+	}
+	agents := make([]*agent.Agent, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		clientCfg := &client.Config{
+			ManagerEndpoint: viper.GetString("manager.endpoint"),
+			Secret:          viper.GetString("manager.secret"),
+			App:             cfg.App,
+			Endpoints:       viper.GetStringSlice("etcd.endpoints"),
+			DialTimeout:     time.Duration(viper.GetInt("etcd.dialTimeout")) * time.Second,
+			RequestTimeout:  time.Duration(viper.GetInt("etcd.requestTimeout")) * time.Second,
+			CacheDir:        viper.GetString("cache.dir"),
+			InstanceId:      viper.GetString("instance.id"),
+			IP:              viper.GetString("instance.ip"),
+		}
+		if len(clientCfg.Endpoints) > 0 {
+			clientCfg.Key = (&api.App{Name: cfg.App}).Key()
+		}
+		a, err := agent.New(cfg, clientCfg)
+		if err != nil {
+			log.Errorf("Fatal error when create agent of app [name=%s]: %s", cfg.App, err)
+			os.Exit(1)
+		}
+		if err = a.Start(); err != nil {
+			log.Errorf("Fatal error when start agent of app [name=%s]: %s", cfg.App, err)
+			os.Exit(1)
+		}
+		agents = append(agents, a)
+	}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Info("Shutdown agent ...")
+	for _, a := range agents {
+		a.Stop()
+	}
+	log.Info("agent exited")
+}
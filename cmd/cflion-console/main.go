@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/cflion/cflion/cmd/cflion-console/server"
+	"github.com/cflion/cflion/cmd/cflion-console/server/repository/memory"
 	"github.com/cflion/cflion/cmd/cflion-console/server/repository/mysql"
 	"github.com/cflion/cflion/pkg/console/api"
 	"github.com/cflion/cflion/pkg/database"
@@ -40,9 +41,11 @@ func init() {
 	viper.SetDefault("server.writeTimeout", 3)
 	viper.SetDefault("server.quitTimeout", 5)
 	viper.SetDefault("logging.level", "INFO")
+	viper.SetDefault("db.driver", "mysql")
 	viper.SetDefault("db.maxIdle", 20)
 	viper.SetDefault("db.maxOpen", 100)
 	viper.SetDefault("etcd.requestTimeout", 3)
+	viper.SetDefault("auth.admin.name", "admin")
 	viper.SetConfigFile(*confPath)
 	viper.AddConfigPath(".")
 	err := viper.ReadInConfig()
@@ -60,22 +63,19 @@ func init() {
 }
 
 func main() {
-	dbCfg := &database.DBConfig{
-		Username: viper.GetString("db.username"),
-		Password: viper.GetString("db.password"),
-		Host:     viper.GetString("db.host"),
-		Port:     viper.GetInt("db.port"),
-		Database: viper.GetString("db.database"),
-		MaxIdle:  viper.GetInt("db.maxIdle"),
-		MaxOpen:  viper.GetInt("db.maxOpen"),
-	}
-	db, err := database.ConnectDatabase(dbCfg)
+	repo, err := newRepository()
 	if err != nil {
 		log.Errorf("Fatal error when connect to db: %s", err)
 		os.Exit(1)
 	}
-	var repo server.Repository = &mysql.RepositoryImpl{DB: db}
 	var service api.Service = &server.ServiceImpl{Repo: repo}
+	// the admin of the config can always log in, e.g. to create the other users after a fresh install
+	if name, token := viper.GetString("auth.admin.name"), viper.GetString("auth.admin.token"); len(token) > 0 {
+		if err = service.Bootstrap(name, token); err != nil {
+			log.Errorf("Fatal error when bootstrap admin [name=%s]: %s", name, err)
+			os.Exit(1)
+		}
+	}
 
 	srvCfg := &restful.ServerConfig{
 		ListenAddr:      fmt.Sprintf("%s:%d", viper.GetString("server.host"), viper.GetInt("server.port")),
@@ -86,21 +86,77 @@ func main() {
 		LoggingFilePath: viper.GetString("logging.file"),
 	}
 	srv := restful.NewServer(srvCfg, func(router *gin.Engine) {
-		v1 := router.Group("/v1")
+		v1 := router.Group("/v1", server.Authenticate(service))
 		{
+			admin := server.RequireAdmin()
+			self := server.RequireSelfOrAdmin("user_id")
+			appParam := server.AppIdParam("app_id")
+			viewer := func(appId server.AppIdFunc) gin.HandlerFunc {
+				return server.RequireRole(service, api.RoleViewer, appId)
+			}
+			editor := func(appId server.AppIdFunc) gin.HandlerFunc {
+				return server.RequireRole(service, api.RoleEditor, appId)
+			}
+			publisher := func(appId server.AppIdFunc) gin.HandlerFunc {
+				return server.RequireRole(service, api.RolePublisher, appId)
+			}
+
 			v1.GET("/apps", server.ListApps(service))
-			v1.POST("/apps", server.CreateApp(service))
-			v1.PUT("/apps", server.PublishApp(service))
-			v1.GET("/apps/:app_id", server.ViewApp(service))
-			v1.PUT("/apps/:app_id", server.UpdateApp(service))
+			v1.POST("/apps", admin, server.CreateApp(service))
+			v1.PUT("/apps", publisher(server.AppIdBody("app_id")), server.PublishApp(service))
+			v1.GET("/apps/:app_id", viewer(appParam), server.ViewApp(service))
+			v1.PUT("/apps/:app_id", editor(appParam), server.UpdateApp(service))
+			v1.DELETE("/apps/:app_id", publisher(appParam), server.DeleteApp(service))
+			v1.DELETE("/apps/:app_id/config-files/:file_id", editor(appParam), server.DeleteAppAssociation(service))
+			v1.GET("/apps/:app_id/diff", viewer(appParam), server.DiffApp(service))
+			v1.PUT("/apps/:app_id/gray", publisher(appParam), server.PromoteGrayRelease(service))
+			v1.DELETE("/apps/:app_id/gray", publisher(appParam), server.AbandonGrayRelease(service))
+			v1.GET("/apps/:app_id/roles", viewer(appParam), server.ListAppRoles(service))
+			v1.PUT("/apps/:app_id/roles/:user_id", admin, server.UpdateAppRole(service))
 
 			v1.GET("/config-files", server.ListConfigFiles(service))
-			v1.POST("/config-files", server.CreateConfigFile(service))
-			v1.GET("/config-files/:file_id", server.ViewConfigFile(service))
-			v1.PUT("/config-files/:file_id", server.UpdateConfigFile(service))
+			v1.POST("/config-files", editor(server.AppIdBody("namespace_id")), server.CreateConfigFile(service))
+			v1.GET("/config-files/:file_id", viewer(server.AppIdConfigFile(service, "file_id", server.AppIdQuery("namespace_id"))), server.ViewConfigFile(service))
+			v1.PUT("/config-files/:file_id", editor(server.AppIdConfigFile(service, "file_id", server.AppIdBody("namespace_id"))), server.UpdateConfigFile(service))
+			v1.DELETE("/config-files/:file_id", editor(server.AppIdConfigFile(service, "file_id", server.AppIdQuery("namespace_id"))), server.DeleteConfigFile(service))
+
+			v1.GET("/publish-requests", viewer(server.AppIdQuery("app_id")), server.ListPublishRequests(service))
+			v1.POST("/publish-requests", editor(server.AppIdBody("app_id")), server.CreatePublishRequest(service))
+			v1.PUT("/publish-requests/:request_id/approve", publisher(server.AppIdPublishRequest(service, "request_id")), server.ApprovePublishRequest(service))
+			v1.PUT("/publish-requests/:request_id/reject", publisher(server.AppIdPublishRequest(service, "request_id")), server.RejectPublishRequest(service))
+
+			v1.GET("/users", admin, server.ListUsers(service))
+			v1.POST("/users", admin, server.CreateUser(service))
+			v1.POST("/users/:user_id/tokens", self, server.CreateToken(service))
+			v1.DELETE("/users/:user_id/tokens/:token_id", self, server.DeleteToken(service))
 		}
 	})
 	srv.Start()
 	<-srv.Stop()
 	log.Info("server exited")
 }
+
+// newRepository creates the repository of the db.driver, which is mysql by default.
+func newRepository() (server.Repository, error) {
+	switch driver := viper.GetString("db.driver"); driver {
+	case "mysql":
+		dbCfg := &database.DBConfig{
+			Username: viper.GetString("db.username"),
+			Password: viper.GetString("db.password"),
+			Host:     viper.GetString("db.host"),
+			Port:     viper.GetInt("db.port"),
+			Database: viper.GetString("db.database"),
+			MaxIdle:  viper.GetInt("db.maxIdle"),
+			MaxOpen:  viper.GetInt("db.maxOpen"),
+		}
+		db, err := database.ConnectDatabase(dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.RepositoryImpl{DB: db}, nil
+	case "memory":
+		return memory.NewRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown db driver [%s]", driver)
+	}
+}
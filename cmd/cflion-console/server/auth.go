@@ -0,0 +1,191 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/pkg/console/api"
+	"github.com/cflion/cflion/pkg/transport/restful"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// userKey is the key of the authenticated user in the gin context.
+const userKey = "cflion.user"
+
+// AppIdFunc finds the id of the app which the request operates on.
+type AppIdFunc func(ctx *gin.Context) (int64, error)
+
+// Authenticate sets the user of the bearer token in the Authorization header, the requests without
+// a valid token are rejected with 401.
+func Authenticate(service api.Service) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		auth := ctx.GetHeader("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, restful.ResponseRet{Msg: "Missing bearer token"})
+			return
+		}
+		user, err := service.Authenticate(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+		if err != nil {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, restful.ResponseRet{Msg: "Invalid bearer token"})
+			return
+		}
+		ctx.Set(userKey, user)
+	}
+}
+
+// RequireAdmin rejects the requests of the users who are not admins with 403.
+func RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if user := currentUser(ctx); user == nil || !user.Admin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, restful.ResponseRet{Msg: "Admin is required"})
+		}
+	}
+}
+
+// RequireSelfOrAdmin rejects with 403 unless the user is the one in the path param or an admin.
+func RequireSelfOrAdmin(param string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := currentUser(ctx)
+		if user == nil || (!user.Admin && ctx.Param(param) != strconv.FormatInt(user.Id, 10)) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, restful.ResponseRet{Msg: "Only the user self or an admin is allowed"})
+		}
+	}
+}
+
+// RequireRole rejects with 403 unless the user has the role on the app found by appId.
+func RequireRole(service api.Service, role api.Role, appId AppIdFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := currentUser(ctx)
+		if user == nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, restful.ResponseRet{Msg: "Missing bearer token"})
+			return
+		}
+		if user.Admin {
+			return
+		}
+		id, err := appId(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if service.GetRole(user, id) < role {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, restful.ResponseRet{Msg: fmt.Sprintf("Role %s on app [id=%d] is required", role, id)})
+		}
+	}
+}
+
+// AppIdParam finds the app id in the path param.
+func AppIdParam(name string) AppIdFunc {
+	return func(ctx *gin.Context) (int64, error) {
+		return strconv.ParseInt(ctx.Param(name), 10, 64)
+	}
+}
+
+// AppIdQuery finds the app id in the query param.
+func AppIdQuery(name string) AppIdFunc {
+	return func(ctx *gin.Context) (int64, error) {
+		return strconv.ParseInt(ctx.Query(name), 10, 64)
+	}
+}
+
+// AppIdBody finds the app id in the field of the json body, the body is kept for the handler.
+func AppIdBody(field string) AppIdFunc {
+	return func(ctx *gin.Context) (int64, error) {
+		body, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			return 0, err
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var params map[string]json.RawMessage
+		if err = json.Unmarshal(body, &params); err != nil {
+			return 0, err
+		}
+		var id int64
+		if err = json.Unmarshal(params[field], &id); err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", field, err)
+		}
+		return id, nil
+	}
+}
+
+// AppIdPublishRequest finds the app of the publish request whose id is in the path param.
+func AppIdPublishRequest(service api.Service, param string) AppIdFunc {
+	return func(ctx *gin.Context) (int64, error) {
+		id, err := strconv.ParseInt(ctx.Param(param), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		request, err := service.GetPublishRequest(id)
+		if err != nil {
+			return 0, err
+		}
+		return request.AppId, nil
+	}
+}
+
+// AppIdConfigFile finds the namespace of the config file whose id is in the path param in the manager, the
+// namespace found by namespaceId picks the env and must be the namespace of the file.
+func AppIdConfigFile(service api.Service, param string, namespaceId AppIdFunc) AppIdFunc {
+	return func(ctx *gin.Context) (int64, error) {
+		fileId, err := strconv.ParseInt(ctx.Param(param), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		supplied, err := namespaceId(ctx)
+		if err != nil {
+			return 0, err
+		}
+		app, err := service.GetAppById(supplied)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := callManager(ctx, app.Env, "GET", fmt.Sprintf("/v1/config-files/%d", fileId), nil)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("Config file [id=%d] doesn't exists in [env=%s]", fileId, app.Env)
+		}
+		var ret struct {
+			Data struct {
+				NamespaceId int64 `json:"namespace_id"`
+			} `json:"data"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+			return 0, err
+		}
+		if ret.Data.NamespaceId != supplied {
+			return 0, fmt.Errorf("Config file [id=%d] isn't in namespace [id=%d]", fileId, supplied)
+		}
+		return ret.Data.NamespaceId, nil
+	}
+}
+
+// currentUser returns the authenticated user of the request, or nil.
+func currentUser(ctx *gin.Context) *api.User {
+	if v, ok := ctx.Get(userKey); ok {
+		return v.(*api.User)
+	}
+	return nil
+}
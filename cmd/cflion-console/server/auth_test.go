@@ -0,0 +1,179 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-console/server/repository/memory"
+	"github.com/cflion/cflion/pkg/console/api"
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveAs(router *gin.Engine, token, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireRole(t *testing.T) {
+	// the fake manager checks the signature and echoes the operator
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := signature.VerifyRequest(r, "secret", time.Minute, nil); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"operator": r.Header.Get(signature.OperatorHeader)})
+	}))
+	defer manager.Close()
+	viper.Set("dev.manager.endpoint", manager.URL)
+	viper.Set("dev.manager.secret", "secret")
+	defer viper.Set("dev.manager.secret", "")
+
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	if err := service.Bootstrap("admin", "admin-token"); err != nil {
+		t.Fatal(err)
+	}
+	demoId, _ := service.CreateApp("demo", "dev")
+	otherId, _ := service.CreateApp("other", "dev")
+	bobId, _ := service.CreateUser("bob", false)
+	_, bobToken, err := service.CreateToken(bobId, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.GrantRole(bobId, demoId, api.RoleViewer)
+	service.GrantRole(bobId, otherId, api.RoleEditor)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1", Authenticate(service))
+	v1.GET("/apps/:app_id", RequireRole(service, api.RoleViewer, AppIdParam("app_id")), ViewApp(service))
+	v1.PUT("/apps", RequireRole(service, api.RolePublisher, AppIdBody("app_id")), PublishApp(service))
+	v1.POST("/config-files", RequireRole(service, api.RoleEditor, AppIdBody("namespace_id")), func(ctx *gin.Context) {
+		// the body read by the middleware is still bound by the handler
+		var params struct {
+			NamespaceId int64  `json:"namespace_id" binding:"required"`
+			Filename    string `json:"filename" binding:"required"`
+		}
+		if err := ctx.ShouldBindJSON(&params); err != nil {
+			ctx.JSON(http.StatusBadRequest, nil)
+			return
+		}
+		ctx.JSON(http.StatusCreated, params)
+	})
+	v1.POST("/users", RequireAdmin(), CreateUser(service))
+	v1.POST("/users/:user_id/tokens", RequireSelfOrAdmin("user_id"), CreateToken(service))
+
+	demo := fmt.Sprintf("/v1/apps/%d", demoId)
+	cases := []struct {
+		token  string
+		method string
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"", "GET", demo, nil, http.StatusUnauthorized},
+		{"unknown", "GET", demo, nil, http.StatusUnauthorized},
+		{bobToken, "GET", demo, nil, http.StatusOK},
+		{bobToken, "GET", "/v1/apps/abc", nil, http.StatusBadRequest},
+		{bobToken, "GET", fmt.Sprintf("/v1/apps/%d", otherId+1000), nil, http.StatusForbidden},
+		{bobToken, "PUT", "/v1/apps", map[string]interface{}{"app_id": demoId}, http.StatusForbidden},
+		{"admin-token", "PUT", "/v1/apps", map[string]interface{}{"app_id": demoId}, http.StatusOK},
+		{bobToken, "POST", "/v1/config-files", map[string]interface{}{"namespace_id": demoId, "filename": "db"}, http.StatusForbidden},
+		{bobToken, "POST", "/v1/config-files", map[string]interface{}{"namespace_id": otherId, "filename": "db"}, http.StatusCreated},
+		{bobToken, "POST", "/v1/users", map[string]interface{}{"name": "carol"}, http.StatusForbidden},
+		{"admin-token", "POST", "/v1/users", map[string]interface{}{"name": "carol"}, http.StatusCreated},
+		{bobToken, "POST", fmt.Sprintf("/v1/users/%d/tokens", bobId), map[string]interface{}{"name": "ci"}, http.StatusCreated},
+		{bobToken, "POST", fmt.Sprintf("/v1/users/%d/tokens", bobId+1000), map[string]interface{}{"name": "ci"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if w := serveAs(router, c.token, c.method, c.url, c.body); w.Code != c.status {
+			t.Errorf("%s %s %v as [%s] expect %d, got %d: %s", c.method, c.url, c.body, c.token, c.status, w.Code, w.Body)
+		}
+	}
+
+	// the request to the manager is signed on behalf of the user
+	w := serveAs(router, bobToken, "GET", demo, nil)
+	var ret map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || ret["operator"] != "bob" {
+		t.Errorf("expect operator bob forwarded to manager, got %s: %v", w.Body, err)
+	}
+}
+
+func TestAppIdConfigFile(t *testing.T) {
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	demoId, _ := service.CreateApp("demo", "dev")
+	otherId, _ := service.CreateApp("other", "dev")
+	bobId, _ := service.CreateUser("bob", false)
+	_, bobToken, err := service.CreateToken(bobId, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.GrantRole(bobId, demoId, api.RoleViewer)
+	service.GrantRole(bobId, otherId, api.RoleEditor)
+
+	// the file 7 is in namespace demo
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/config-files/7" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": 7, "namespace_id": demoId}})
+	}))
+	defer manager.Close()
+	viper.Set("dev.manager.endpoint", manager.URL)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1", Authenticate(service))
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	v1.GET("/config-files/:file_id", RequireRole(service, api.RoleViewer, AppIdConfigFile(service, "file_id", AppIdQuery("namespace_id"))), ok)
+	v1.PUT("/config-files/:file_id", RequireRole(service, api.RoleEditor, AppIdConfigFile(service, "file_id", AppIdBody("namespace_id"))), ok)
+
+	cases := []struct {
+		method string
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"GET", fmt.Sprintf("/v1/config-files/7?namespace_id=%d", demoId), nil, http.StatusOK},
+		{"GET", fmt.Sprintf("/v1/config-files/8?namespace_id=%d", demoId), nil, http.StatusBadRequest},
+		// the role on the supplied namespace doesn't grant the file of another namespace
+		{"GET", fmt.Sprintf("/v1/config-files/7?namespace_id=%d", otherId), nil, http.StatusBadRequest},
+		{"PUT", "/v1/config-files/7", map[string]interface{}{"namespace_id": otherId, "config": "a=1"}, http.StatusBadRequest},
+		{"PUT", "/v1/config-files/7", map[string]interface{}{"namespace_id": demoId, "config": "a=1"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if w := serveAs(router, bobToken, c.method, c.url, c.body); w.Code != c.status {
+			t.Errorf("%s %s %v expect %d, got %d: %s", c.method, c.url, c.body, c.status, w.Code, w.Body)
+		}
+	}
+}
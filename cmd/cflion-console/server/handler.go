@@ -19,15 +19,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/cflion/cflion/pkg/console/api"
+	"github.com/cflion/cflion/pkg/log"
 	"github.com/cflion/cflion/pkg/transport/restful"
+	"github.com/cflion/cflion/pkg/transport/signature"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/spf13/viper"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 )
 
+// defaultPublishExpire is the hours before a publish request expires if <env>.publish.expire isn't set.
+const defaultPublishExpire = 24
+
 func ListApps(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		data, err := service.ListApps()
@@ -59,8 +67,7 @@ func CreateApp(service api.Service) func(ctx *gin.Context) {
 			return
 		}
 		// call remote manager
-		reqBytes, _ := json.Marshal(map[string]interface{}{"name": params.Name})
-		resp, err := http.Post(managerUrl+"/v1/apps", "application/json", bytes.NewBuffer(reqBytes))
+		resp, err := callManager(ctx, params.Env, "POST", "/v1/apps", map[string]interface{}{"name": params.Name})
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -80,10 +87,16 @@ func CreateApp(service api.Service) func(ctx *gin.Context) {
 	}
 }
 
+// PublishApp forwards the publish to the manager. The env with publish.approval requires the request_id of
+// an approved publish request of the app, whose comment and gray rule are published, and it's published only once.
+// The manager publishes the config of the request only if it's unchanged since the request is created.
 func PublishApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		var params struct {
-			AppId int64 `json:"app_id" binding:"required"`
+			AppId     int64           `json:"app_id" binding:"required"`
+			Comment   string          `json:"comment"`
+			Gray      json.RawMessage `json:"gray"`
+			RequestId int64           `json:"request_id"`
 		}
 		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
@@ -94,25 +107,75 @@ func PublishApp(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		// call remote manager
-		reqBytes, _ := json.Marshal(map[string]string{"name": app.Name})
-		client := http.Client{}
-		req, err := http.NewRequest("PUT", getManagerEndpoint(app.Env)+"/v1/apps", bytes.NewBuffer(reqBytes))
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+		if params.RequestId <= 0 && viper.GetBool(app.Env+".publish.approval") {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Publishing in [env=%s] requires the request_id of an approved publish request", app.Env)})
 			return
 		}
-		req.Header.Add("Content-Type", "application/json")
-		resp, err := client.Do(req)
+		body := map[string]interface{}{"name": app.Name, "comment": params.Comment}
+		if len(params.Gray) > 0 {
+			body["gray"] = params.Gray
+		}
+		if params.RequestId > 0 {
+			request := publishRequestOf(ctx, service, app, params.RequestId)
+			if request == nil {
+				return
+			}
+			body = map[string]interface{}{"name": app.Name, "comment": request.Comment, "checksum": request.Checksum}
+			if len(request.Gray) > 0 {
+				body["gray"] = json.RawMessage(request.Gray)
+			}
+			if !markPublishRequestPublished(ctx, service, request) {
+				return
+			}
+		}
+		// call remote manager
+		resp, err := callManager(ctx, app.Env, "PUT", "/v1/apps", body)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if params.RequestId > 0 && (err != nil || resp.StatusCode != http.StatusOK) {
+			revertPublishRequest(service, params.RequestId)
+		}
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		resp.Body.Close()
 		ctx.Status(resp.StatusCode)
 	}
 }
 
+// publishRequestOf returns the publish request of the app, it responds the error and returns nil if the
+// request doesn't exist or is of another app.
+func publishRequestOf(ctx *gin.Context, service api.Service, app *api.App, requestId int64) *api.PublishRequest {
+	request, err := service.GetPublishRequest(requestId)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+		return nil
+	}
+	if request.AppId != app.Id {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Publish request [id=%d] isn't of app [id=%d]", request.Id, app.Id)})
+		return nil
+	}
+	return request
+}
+
+// markPublishRequestPublished marks the approved request published before calling the manager, so that it can't
+// be published twice concurrently. It responds the error and returns false if the request isn't approved.
+func markPublishRequestPublished(ctx *gin.Context, service api.Service, request *api.PublishRequest) bool {
+	if err := service.UpdatePublishRequestStatus(request.Id, api.PublishApproved, api.PublishPublished, ""); err != nil {
+		respondStatusError(ctx, err)
+		return false
+	}
+	return true
+}
+
+// revertPublishRequest marks the request approved again after the manager failed, so that it can be published again.
+func revertPublishRequest(service api.Service, requestId int64) {
+	if err := service.UpdatePublishRequestStatus(requestId, api.PublishPublished, api.PublishApproved, ""); err != nil {
+		log.Errorf("Revert publish request [id=%d] to approved error: %s", requestId, err)
+	}
+}
+
 func ViewApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
@@ -126,7 +189,7 @@ func ViewApp(service api.Service) func(ctx *gin.Context) {
 			return
 		}
 		// call remote manager
-		resp, err := http.Get(getManagerEndpoint(app.Env) + "/v1/apps/" + app.Name)
+		resp, err := callManager(ctx, app.Env, "GET", "/v1/apps/"+app.Name, nil)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -151,6 +214,172 @@ func ViewApp(service api.Service) func(ctx *gin.Context) {
 	}
 }
 
+func DiffApp(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(appId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// call remote manager
+		resp, err := callManager(ctx, app.Env, "GET", "/v1/apps/"+app.Name+"/diff", nil)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var result interface{}
+		json.Unmarshal(respBytes, &result)
+		ctx.JSON(resp.StatusCode, result)
+	}
+}
+
+// PromoteGrayRelease forwards the promotion of the gray release to the manager, which publishes it to all the
+// instances. The env with publish.approval requires the request_id of an approved publish request of the app
+// without gray rule, and the manager promotes only if the gray release is the config of the request.
+func PromoteGrayRelease(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var params struct {
+			RequestId int64 `json:"request_id"`
+		}
+		if err = ctx.ShouldBindWith(&params, binding.JSON); err != nil && err != io.EOF {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(appId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if params.RequestId <= 0 && viper.GetBool(app.Env+".publish.approval") {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Promoting a gray release in [env=%s] requires the request_id of an approved publish request", app.Env)})
+			return
+		}
+		var body interface{}
+		if params.RequestId > 0 {
+			request := publishRequestOf(ctx, service, app, params.RequestId)
+			if request == nil {
+				return
+			}
+			if len(request.Gray) > 0 {
+				ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Publish request [id=%d] is a gray release, which can't promote", request.Id)})
+				return
+			}
+			body = map[string]interface{}{"checksum": request.Checksum}
+			if !markPublishRequestPublished(ctx, service, request) {
+				return
+			}
+		}
+		// call remote manager
+		status, result, err := forwardBody(ctx, app.Env, "PUT", "/v1/apps/"+app.Name+"/gray", body)
+		if params.RequestId > 0 && (err != nil || status != http.StatusOK) {
+			revertPublishRequest(service, params.RequestId)
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(status, result)
+	}
+}
+
+// AbandonGrayRelease forwards abandoning the gray release of the app to the manager.
+func AbandonGrayRelease(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(appId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// call remote manager
+		status, result, err := forward(ctx, app.Env, "DELETE", "/v1/apps/"+app.Name+"/gray")
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(status, result)
+	}
+}
+
+func DeleteApp(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(appId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// call remote manager
+		status, result, err := forward(ctx, app.Env, "DELETE", fmt.Sprintf("/v1/apps/%s?force=%s", app.Name, url.QueryEscape(ctx.Query("force"))))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// the app deleted in manager before is deleted locally as well
+		if status != http.StatusOK && status != http.StatusUnprocessableEntity {
+			ctx.JSON(status, result)
+			return
+		}
+		// delete local
+		if err = service.DeleteApp(appId); err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("App [name=%s] [env=%s] deletes successfully", app.Name, app.Env)})
+	}
+}
+
+func DeleteAppAssociation(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(appId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// call remote manager
+		status, result, err := forward(ctx, app.Env, "DELETE", fmt.Sprintf("/v1/apps/%s/config-files/%d", app.Name, fileId))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(status, result)
+	}
+}
+
 func UpdateApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
@@ -171,21 +400,24 @@ func UpdateApp(service api.Service) func(ctx *gin.Context) {
 			return
 		}
 		// call remote manager
-		reqBytes, _ := json.Marshal(params)
-		client := http.Client{}
-		req, err := http.NewRequest("PUT", getManagerEndpoint(app.Env)+"/v1/apps/"+app.Name, bytes.NewBuffer(reqBytes))
+		resp, err := callManager(ctx, app.Env, "PUT", "/v1/apps/"+app.Name, params)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		req.Header.Add("Content-Type", "application/json")
-		resp, err := client.Do(req)
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		resp.Body.Close()
-		ctx.Status(resp.StatusCode)
+		if len(respBytes) == 0 {
+			ctx.Status(resp.StatusCode)
+			return
+		}
+		var result interface{}
+		json.Unmarshal(respBytes, &result)
+		ctx.JSON(resp.StatusCode, result)
 	}
 }
 
@@ -193,12 +425,11 @@ func ListConfigFiles(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		// call remote manager
 		env := ctx.Query("env")
-		managerUrl := getManagerEndpoint(env)
-		if len(managerUrl) <= 0 {
+		if len(getManagerEndpoint(env)) <= 0 {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Can not support [env=%s]", env)})
 			return
 		}
-		resp, err := http.Get(managerUrl + "/v1/config-files")
+		resp, err := callManager(ctx, env, "GET", "/v1/config-files", nil)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -221,6 +452,8 @@ func CreateConfigFile(service api.Service) func(ctx *gin.Context) {
 			NamespaceId int64  `json:"namespace_id" binding:"required"`
 			Config      string `json:"config" binding:"required"`
 			Filename    string `json:"filename" binding:"required"`
+			Format      string `json:"format,omitempty"`
+			Public      bool   `json:"public,omitempty"`
 		}
 		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
@@ -232,18 +465,20 @@ func CreateConfigFile(service api.Service) func(ctx *gin.Context) {
 			return
 		}
 		// call remote manager
-		reqBytes, err := json.Marshal(params)
+		resp, err := callManager(ctx, app.Env, "POST", "/v1/config-files", params)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		resp, err := http.Post(getManagerEndpoint(app.Env)+"/v1/config-files", "application/json", bytes.NewBuffer(reqBytes))
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		resp.Body.Close()
-		ctx.Status(resp.StatusCode)
+		var result interface{}
+		json.Unmarshal(respBytes, &result)
+		ctx.JSON(resp.StatusCode, result)
 	}
 }
 
@@ -264,9 +499,8 @@ func ViewConfigFile(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		managerUrl := getManagerEndpoint(app.Env)
 		// call remote manager
-		resp, err := http.Get(fmt.Sprintf("%s/v1/config-files/%d", managerUrl, fileId))
+		resp, err := callManager(ctx, app.Env, "GET", fmt.Sprintf("/v1/config-files/%d", fileId), nil)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -279,6 +513,7 @@ func ViewConfigFile(service api.Service) func(ctx *gin.Context) {
 		}
 		var result interface{}
 		json.Unmarshal(respBytes, &result)
+		ctx.Header("ETag", resp.Header.Get("ETag"))
 		ctx.JSON(resp.StatusCode, result)
 	}
 }
@@ -303,24 +538,393 @@ func UpdateConfigFile(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		managerUrl := getManagerEndpoint(app.Env)
 		// call remote manager
-		reqBytes, _ := json.Marshal(params)
-		client := http.Client{}
-		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/v1/config-files/%d", managerUrl, fileId), bytes.NewBuffer(reqBytes))
+		req, err := newManagerRequest(ctx, app.Env, "PUT", fmt.Sprintf("/v1/config-files/%d", fileId), params)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("If-Match", ctx.GetHeader("If-Match"))
+		client := http.Client{}
 		resp, err := client.Do(req)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		resp.Body.Close()
-		ctx.Status(resp.StatusCode)
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var result interface{}
+		json.Unmarshal(respBytes, &result)
+		ctx.Header("ETag", resp.Header.Get("ETag"))
+		ctx.JSON(resp.StatusCode, result)
+	}
+}
+
+func DeleteConfigFile(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		namespaceId, err := strconv.ParseInt(ctx.Query("namespace_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(namespaceId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		// call remote manager
+		status, result, err := forward(ctx, app.Env, "DELETE", fmt.Sprintf("/v1/config-files/%d?force=%s", fileId, url.QueryEscape(ctx.Query("force"))))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(status, result)
+	}
+}
+
+func ListUsers(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		data, err := service.ListUsers()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func CreateUser(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var params struct {
+			Name  string `json:"name" binding:"required"`
+			Admin bool   `json:"admin"`
+		}
+		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if service.ExistsUserByName(params.Name) {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("User [name=%s] already exists", params.Name)})
+			return
+		}
+		id, err := service.CreateUser(params.Name, params.Admin)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusCreated, restful.ResponseRet{Data: map[string]interface{}{"id": id}})
+	}
+}
+
+// CreateToken returns the new token of the user, which can't be viewed again.
+func CreateToken(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		userId, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var params struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err = ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if _, err = service.GetUserById(userId); err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		id, token, err := service.CreateToken(userId, params.Name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusCreated, restful.ResponseRet{Data: map[string]interface{}{"id": id, "token": token}})
+	}
+}
+
+func DeleteToken(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		userId, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		tokenId, err := strconv.ParseInt(ctx.Param("token_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if err = service.DeleteToken(userId, tokenId); err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Token [id=%d] deletes successfully", tokenId)})
+	}
+}
+
+func ListAppRoles(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		data, err := service.ListRoles(appId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+// UpdateAppRole grants the role on the app to the user, the role none revokes it.
+func UpdateAppRole(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Param("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		userId, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var params struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err = ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		role, ok := api.ParseRole(params.Role)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Unknown role [%s]", params.Role)})
+			return
+		}
+		if _, err = service.GetAppById(appId); err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if _, err = service.GetUserById(userId); err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if err = service.GrantRole(userId, appId, role); err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("User [id=%d] is %s of app [id=%d]", userId, role, appId)})
+	}
+}
+
+// CreatePublishRequest requests to publish the current config of the app, the request expires after
+// <env>.publish.expire hours.
+func CreatePublishRequest(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var params struct {
+			AppId   int64           `json:"app_id" binding:"required"`
+			Comment string          `json:"comment"`
+			Gray    json.RawMessage `json:"gray"`
+		}
+		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		app, err := service.GetAppById(params.AppId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		checksum, err := appChecksum(ctx, app)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		hours := viper.GetInt(app.Env + ".publish.expire")
+		if hours <= 0 {
+			hours = defaultPublishExpire
+		}
+		id, err := service.CreatePublishRequest(app.Id, params.Comment, string(params.Gray), checksum, currentUser(ctx).Name, time.Duration(hours)*time.Hour)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusCreated, restful.ResponseRet{Data: map[string]interface{}{"id": id}})
+	}
+}
+
+func ListPublishRequests(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		appId, err := strconv.ParseInt(ctx.Query("app_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		data, err := service.ListPublishRequests(appId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func ApprovePublishRequest(service api.Service) func(ctx *gin.Context) {
+	return reviewPublishRequest(service, api.PublishApproved)
+}
+
+func RejectPublishRequest(service api.Service) func(ctx *gin.Context) {
+	return reviewPublishRequest(service, api.PublishRejected)
+}
+
+// reviewPublishRequest changes the pending request to the status, the requester can't review the own request.
+// The request can't be approved if the config of the app has changed since it's created.
+func reviewPublishRequest(service api.Service, status byte) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		requestId, err := strconv.ParseInt(ctx.Param("request_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		request, err := service.GetPublishRequest(requestId)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		reviewer := currentUser(ctx).Name
+		if reviewer == request.Requester {
+			ctx.JSON(http.StatusForbidden, restful.ResponseRet{Msg: "The publish request must be reviewed by another user"})
+			return
+		}
+		if status == api.PublishApproved {
+			app, err := service.GetAppById(request.AppId)
+			if err != nil {
+				ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			checksum, err := appChecksum(ctx, app)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			if checksum != request.Checksum {
+				ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: fmt.Sprintf("Config of app [id=%d] has changed since publish request [id=%d] is created", app.Id, request.Id)})
+				return
+			}
+		}
+		if err = service.UpdatePublishRequestStatus(requestId, api.PublishPending, status, reviewer); err != nil {
+			respondStatusError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Publish request [id=%d] is %s", requestId, api.PublishStatusName(status))})
+	}
+}
+
+// respondStatusError responds 409 if the publish request isn't in the required status, otherwise 500.
+func respondStatusError(ctx *gin.Context, err error) {
+	if _, ok := err.(*api.StatusError); ok {
+		ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+}
+
+// appChecksum returns the checksum of the current config of the app from its diff in the manager.
+func appChecksum(ctx *gin.Context, app *api.App) (string, error) {
+	resp, err := callManager(ctx, app.Env, "GET", "/v1/apps/"+app.Name+"/diff", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		Msg  string `json:"msg"`
+		Data struct {
+			Checksum string `json:"checksum"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || len(ret.Data.Checksum) == 0 {
+		return "", fmt.Errorf("Diff app [name=%s] in [env=%s] error: %d %s", app.Name, app.Env, resp.StatusCode, ret.Msg)
+	}
+	return ret.Data.Checksum, nil
+}
+
+// forward sends the request without body to the manager, returns the status and the decoded response.
+func forward(ctx *gin.Context, env, method, path string) (int, interface{}, error) {
+	return forwardBody(ctx, env, method, path, nil)
+}
+
+// forwardBody calls the manager with the body encoded as json and returns the status and the decoded response.
+func forwardBody(ctx *gin.Context, env, method, path string, body interface{}) (int, interface{}, error) {
+	resp, err := callManager(ctx, env, method, path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	var result interface{}
+	json.Unmarshal(respBytes, &result)
+	return resp.StatusCode, result, nil
+}
+
+// newManagerRequest creates a request to the manager of the env on behalf of the user of ctx, the body is
+// encoded as json. The request is signed when the secret of the manager is configured.
+func newManagerRequest(ctx *gin.Context, env, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reqBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewBuffer(reqBytes)
+	}
+	req, err := http.NewRequest(method, getManagerEndpoint(env)+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	if user := currentUser(ctx); user != nil {
+		req.Header.Set(signature.OperatorHeader, user.Name)
+	}
+	if id := ctx.GetHeader(restful.RequestIdHeader); len(id) > 0 {
+		req.Header.Set(restful.RequestIdHeader, id)
+	}
+	if secret := viper.GetString(env + ".manager.secret"); len(secret) > 0 {
+		if err = signature.SignRequest(req, secret, ""); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// callManager sends the request created by newManagerRequest.
+func callManager(ctx *gin.Context, env, method, path string, body interface{}) (*http.Response, error) {
+	req, err := newManagerRequest(ctx, env, method, path, body)
+	if err != nil {
+		return nil, err
 	}
+	client := http.Client{}
+	return client.Do(req)
 }
 
 func getManagerEndpoint(env string) string {
@@ -0,0 +1,290 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-console/server/repository/memory"
+	"github.com/cflion/cflion/pkg/console/api"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRouter(service api.Service) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.GET("/apps", ListApps(service))
+	v1.POST("/apps", CreateApp(service))
+	v1.DELETE("/apps/:app_id", DeleteApp(service))
+	return router
+}
+
+func serve(router *gin.Engine, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateApp(t *testing.T) {
+	// the fake manager accepts every app except the existing one
+	var created []string
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		if r.Method != "POST" || r.URL.Path != "/v1/apps" || params.Name == "existing" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		created = append(created, params.Name)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer manager.Close()
+	viper.Set("dev.manager.endpoint", manager.URL)
+
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	router := newRouter(service)
+	cases := []struct {
+		body   interface{}
+		status int
+	}{
+		{map[string]string{"name": "demo", "env": "dev"}, http.StatusCreated},
+		{map[string]string{"name": "demo", "env": "dev"}, http.StatusBadRequest},
+		{map[string]string{"name": "demo", "env": "none"}, http.StatusBadRequest},
+		{map[string]string{"name": "existing", "env": "dev"}, http.StatusUnprocessableEntity},
+		{map[string]string{"name": "demo"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if w := serve(router, "POST", "/v1/apps", c.body); w.Code != c.status {
+			t.Errorf("POST /v1/apps %v expect %d, got %d: %s", c.body, c.status, w.Code, w.Body)
+		}
+	}
+	if len(created) != 1 || created[0] != "demo" {
+		t.Errorf("expect only demo created in manager, got %v", created)
+	}
+	if service.ExistsAppByNameAndEnv("existing", "dev") {
+		t.Error("expect app rejected by manager not created in console")
+	}
+
+	w := serve(router, "GET", "/v1/apps", nil)
+	var ret struct {
+		Data []struct {
+			Name string `json:"name"`
+			Env  string `json:"env"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	if len(ret.Data) != 1 || ret.Data[0].Name != "demo" || ret.Data[0].Env != "dev" {
+		t.Errorf("unexpected apps %+v", ret.Data)
+	}
+}
+
+func TestDeleteApp(t *testing.T) {
+	// the fake manager refuses to delete app used by other apps without force
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" || r.URL.Path != "/v1/apps/demo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("force") != "true" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer manager.Close()
+	viper.Set("dev.manager.endpoint", manager.URL)
+
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	id, _ := service.CreateApp("demo", "dev")
+	router := newRouter(service)
+	url := fmt.Sprintf("/v1/apps/%d", id)
+	if w := serve(router, "DELETE", url, nil); w.Code != http.StatusConflict {
+		t.Errorf("expect %d without force, got %d", http.StatusConflict, w.Code)
+	}
+	if !service.ExistsAppByNameAndEnv("demo", "dev") {
+		t.Error("expect app kept when manager refuses to delete it")
+	}
+	if w := serve(router, "DELETE", url+"?force=true", nil); w.Code != http.StatusOK {
+		t.Errorf("expect %d with force, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if service.ExistsAppByNameAndEnv("demo", "dev") {
+		t.Error("expect app deleted")
+	}
+}
+
+func TestPublishApproval(t *testing.T) {
+	// the fake manager diffs the config of the checksum, records the published comments with the checksums,
+	// and fails once when fail is set
+	var published []string
+	fail := false
+	checksum := "v1"
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"checksum": checksum}})
+			return
+		}
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var params struct {
+			Comment  string `json:"comment"`
+			Checksum string `json:"checksum"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		if strings.HasSuffix(r.URL.Path, "/gray") {
+			params.Comment = "promoted"
+		}
+		published = append(published, params.Comment+"@"+params.Checksum)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer manager.Close()
+	viper.Set("prod.manager.endpoint", manager.URL)
+	viper.Set("prod.publish.approval", true)
+	defer viper.Set("prod.publish.approval", false)
+
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	appId, _ := service.CreateApp("demo", "prod")
+	tokens := make(map[string]string)
+	for _, name := range []string{"bob", "alice"} {
+		userId, _ := service.CreateUser(name, false)
+		_, tokens[name], _ = service.CreateToken(userId, "laptop")
+		service.GrantRole(userId, appId, api.RolePublisher)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1", Authenticate(service))
+	v1.PUT("/apps", PublishApp(service))
+	v1.PUT("/apps/:app_id/gray", PromoteGrayRelease(service))
+	v1.POST("/publish-requests", CreatePublishRequest(service))
+	v1.PUT("/publish-requests/:request_id/approve", ApprovePublishRequest(service))
+	v1.PUT("/publish-requests/:request_id/reject", RejectPublishRequest(service))
+
+	create := func(comment string) string {
+		w := serveAs(router, tokens["bob"], "POST", "/v1/publish-requests", map[string]interface{}{"app_id": appId, "comment": comment})
+		var ret struct {
+			Data struct {
+				Id int64 `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("create publish request got %d: %s", w.Code, w.Body)
+		}
+		return fmt.Sprintf("%d", ret.Data.Id)
+	}
+	approved, rejected := create("approved"), create("rejected")
+	publish := func(requestId string) map[string]interface{} {
+		return map[string]interface{}{"app_id": appId, "comment": "ignored", "request_id": json.RawMessage(requestId)}
+	}
+
+	cases := []struct {
+		user   string
+		method string
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"bob", "PUT", "/v1/apps", map[string]interface{}{"app_id": appId}, http.StatusUnprocessableEntity},
+		{"bob", "PUT", "/v1/apps", publish(approved), http.StatusConflict},
+		{"bob", "PUT", "/v1/publish-requests/" + approved + "/approve", nil, http.StatusForbidden},
+		{"alice", "PUT", "/v1/publish-requests/" + approved + "/approve", nil, http.StatusOK},
+		{"alice", "PUT", "/v1/publish-requests/" + approved + "/reject", nil, http.StatusConflict},
+		{"alice", "PUT", "/v1/publish-requests/" + rejected + "/reject", nil, http.StatusOK},
+		{"bob", "PUT", "/v1/apps", publish(rejected), http.StatusConflict},
+		{"bob", "PUT", "/v1/apps", publish(approved), http.StatusOK},
+		{"bob", "PUT", "/v1/apps", publish(approved), http.StatusConflict},
+	}
+	for _, c := range cases {
+		if w := serveAs(router, tokens[c.user], c.method, c.url, c.body); w.Code != c.status {
+			t.Errorf("%s %s %v as %s expect %d, got %d: %s", c.method, c.url, c.body, c.user, c.status, w.Code, w.Body)
+		}
+	}
+	if len(published) != 1 || published[0] != "approved@v1" {
+		t.Errorf("expect only the approved request published with its comment and checksum, got %v", published)
+	}
+
+	// the request can't be approved after the config changes
+	stale := create("stale")
+	checksum = "v2"
+	if w := serveAs(router, tokens["alice"], "PUT", "/v1/publish-requests/"+stale+"/approve", nil); w.Code != http.StatusConflict {
+		t.Errorf("expect %d when approving the changed config, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+
+	// the request stays approved when the manager fails
+	retry := create("retry")
+	serveAs(router, tokens["alice"], "PUT", "/v1/publish-requests/"+retry+"/approve", nil)
+	fail = true
+	if w := serveAs(router, tokens["bob"], "PUT", "/v1/apps", publish(retry)); w.Code != http.StatusInternalServerError {
+		t.Errorf("expect %d when the manager fails, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if w := serveAs(router, tokens["bob"], "PUT", "/v1/apps", publish(retry)); w.Code != http.StatusOK {
+		t.Errorf("expect the approved request published after the manager failed, got %d: %s", w.Code, w.Body)
+	}
+
+	// promoting the gray release requires an approved request without gray rule as well
+	promote := create("promote")
+	gray, _ := service.CreatePublishRequest(appId, "gray", `{"ips":["10.0.0.*"]}`, "v2", "bob", time.Hour)
+	serveAs(router, tokens["alice"], "PUT", fmt.Sprintf("/v1/publish-requests/%d/approve", gray), nil)
+	promoteUrl := fmt.Sprintf("/v1/apps/%d/gray", appId)
+	published = nil
+	cases = []struct {
+		user   string
+		method string
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"bob", "PUT", promoteUrl, nil, http.StatusUnprocessableEntity},
+		{"bob", "PUT", promoteUrl, map[string]interface{}{"request_id": json.RawMessage(promote)}, http.StatusConflict},
+		{"bob", "PUT", promoteUrl, map[string]interface{}{"request_id": gray}, http.StatusUnprocessableEntity},
+		{"alice", "PUT", "/v1/publish-requests/" + promote + "/approve", nil, http.StatusOK},
+		{"bob", "PUT", promoteUrl, map[string]interface{}{"request_id": json.RawMessage(promote)}, http.StatusOK},
+		{"bob", "PUT", promoteUrl, map[string]interface{}{"request_id": json.RawMessage(promote)}, http.StatusConflict},
+	}
+	for _, c := range cases {
+		if w := serveAs(router, tokens[c.user], c.method, c.url, c.body); w.Code != c.status {
+			t.Errorf("%s %s %v as %s expect %d, got %d: %s", c.method, c.url, c.body, c.user, c.status, w.Code, w.Body)
+		}
+	}
+	if len(published) != 1 || published[0] != "promoted@v2" {
+		t.Errorf("expect the gray release promoted once with the checksum of the request, got %v", published)
+	}
+
+	// the request expires
+	expired, _ := service.CreatePublishRequest(appId, "expired", "", "v2", "bob", -time.Second)
+	if request, _ := service.GetPublishRequest(expired); request.Status != api.PublishExpired {
+		t.Errorf("expect the request expired, got %s", request)
+	}
+}
@@ -0,0 +1,331 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package memory implements the repository of cflion-console in memory, which is lost after the console exits.
+package memory
+
+import (
+	"fmt"
+	"github.com/cflion/cflion/pkg/console/api"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RepositoryImpl struct {
+	mu     sync.RWMutex
+	lastId int64
+	apps   map[int64]*api.App
+	users  map[int64]*api.User
+	tokens map[int64]*api.Token
+	roles  map[roleKey]api.Role
+	// requests are the publish requests
+	requests map[int64]*api.PublishRequest
+}
+
+type roleKey struct {
+	userId int64
+	appId  int64
+}
+
+func NewRepository() *RepositoryImpl {
+	return &RepositoryImpl{
+		apps:     make(map[int64]*api.App),
+		users:    make(map[int64]*api.User),
+		tokens:   make(map[int64]*api.Token),
+		roles:    make(map[roleKey]api.Role),
+		requests: make(map[int64]*api.PublishRequest),
+	}
+}
+
+func (repo *RepositoryImpl) QueryAppsBrief() ([]*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	apps := make([]*api.App, 0, len(repo.apps))
+	for _, app := range repo.apps {
+		a := *app
+		apps = append(apps, &a)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Id < apps[j].Id
+	})
+	return apps, nil
+}
+
+func (repo *RepositoryImpl) GetAppById(id int64) (*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	app, ok := repo.apps[id]
+	if !ok {
+		return nil, fmt.Errorf("app [id=%d] doesn't exist", id)
+	}
+	a := *app
+	return &a, nil
+}
+
+func (repo *RepositoryImpl) GetAppByName(name string) (*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, app := range repo.apps {
+		if app.Name == name {
+			a := *app
+			return &a, nil
+		}
+	}
+	return nil, fmt.Errorf("app [name=%s] doesn't exist", name)
+}
+
+func (repo *RepositoryImpl) ExistsAppByNameAndEnv(name, env string) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.exists(name, env)
+}
+
+func (repo *RepositoryImpl) InsertApp(app *api.App) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.exists(app.Name, app.Env) {
+		return -1, fmt.Errorf("app [name=%s] [env=%s] already exists", app.Name, app.Env)
+	}
+	repo.lastId++
+	repo.apps[repo.lastId] = &api.App{Id: repo.lastId, Name: app.Name, Env: app.Env}
+	return repo.lastId, nil
+}
+
+func (repo *RepositoryImpl) DeleteApp(id int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.apps, id)
+	for key := range repo.roles {
+		if key.appId == id {
+			delete(repo.roles, key)
+		}
+	}
+	for requestId, request := range repo.requests {
+		if request.AppId == id {
+			delete(repo.requests, requestId)
+		}
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) QueryUsers() ([]*api.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	users := make([]*api.User, 0, len(repo.users))
+	for _, user := range repo.users {
+		u := *user
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Id < users[j].Id
+	})
+	return users, nil
+}
+
+func (repo *RepositoryImpl) GetUserById(id int64) (*api.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	user, ok := repo.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user [id=%d] doesn't exist", id)
+	}
+	u := *user
+	return &u, nil
+}
+
+func (repo *RepositoryImpl) GetUserByName(name string) (*api.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	user := repo.userByName(name)
+	if user == nil {
+		return nil, fmt.Errorf("user [name=%s] doesn't exist", name)
+	}
+	u := *user
+	return &u, nil
+}
+
+func (repo *RepositoryImpl) ExistsUserByName(name string) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.userByName(name) != nil
+}
+
+func (repo *RepositoryImpl) InsertUser(user *api.User) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.userByName(user.Name) != nil {
+		return -1, fmt.Errorf("user [name=%s] already exists", user.Name)
+	}
+	repo.lastId++
+	repo.users[repo.lastId] = &api.User{Id: repo.lastId, Name: user.Name, Admin: user.Admin}
+	return repo.lastId, nil
+}
+
+func (repo *RepositoryImpl) InsertToken(token *api.Token) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.users[token.UserId]; !ok {
+		return -1, fmt.Errorf("user [id=%d] doesn't exist", token.UserId)
+	}
+	for _, t := range repo.tokens {
+		if t.Hash == token.Hash {
+			return -1, fmt.Errorf("token already exists")
+		}
+	}
+	repo.lastId++
+	repo.tokens[repo.lastId] = &api.Token{Id: repo.lastId, UserId: token.UserId, Name: token.Name, Hash: token.Hash}
+	return repo.lastId, nil
+}
+
+func (repo *RepositoryImpl) GetUserByTokenHash(hash string) (*api.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, token := range repo.tokens {
+		if token.Hash == hash {
+			if user, ok := repo.users[token.UserId]; ok {
+				u := *user
+				return &u, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid token")
+}
+
+func (repo *RepositoryImpl) DeleteToken(userId, tokenId int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if token, ok := repo.tokens[tokenId]; ok && token.UserId == userId {
+		delete(repo.tokens, tokenId)
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) GetRole(userId, appId int64) (api.Role, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.roles[roleKey{userId, appId}], nil
+}
+
+func (repo *RepositoryImpl) QueryRoles(appId int64) ([]*api.RoleBinding, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	bindings := make([]*api.RoleBinding, 0, 8)
+	for key, role := range repo.roles {
+		if key.appId == appId {
+			bindings = append(bindings, &api.RoleBinding{UserId: key.userId, AppId: key.appId, Role: role})
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].UserId < bindings[j].UserId
+	})
+	return bindings, nil
+}
+
+func (repo *RepositoryImpl) SaveRole(binding *api.RoleBinding) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.users[binding.UserId]; !ok {
+		return fmt.Errorf("user [id=%d] doesn't exist", binding.UserId)
+	}
+	if _, ok := repo.apps[binding.AppId]; !ok {
+		return fmt.Errorf("app [id=%d] doesn't exist", binding.AppId)
+	}
+	repo.roles[roleKey{binding.UserId, binding.AppId}] = binding.Role
+	return nil
+}
+
+func (repo *RepositoryImpl) DeleteRole(userId, appId int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.roles, roleKey{userId, appId})
+	return nil
+}
+
+func (repo *RepositoryImpl) InsertPublishRequest(request *api.PublishRequest) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.apps[request.AppId]; !ok {
+		return -1, fmt.Errorf("app [id=%d] doesn't exist", request.AppId)
+	}
+	repo.lastId++
+	r := *request
+	r.Id = repo.lastId
+	r.Status = api.PublishPending
+	r.Ctime = time.Now()
+	repo.requests[r.Id] = &r
+	return r.Id, nil
+}
+
+func (repo *RepositoryImpl) GetPublishRequest(id int64) (*api.PublishRequest, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	request, ok := repo.requests[id]
+	if !ok {
+		return nil, fmt.Errorf("publish request [id=%d] doesn't exist", id)
+	}
+	r := *request
+	return &r, nil
+}
+
+func (repo *RepositoryImpl) QueryPublishRequests(appId int64) ([]*api.PublishRequest, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	requests := make([]*api.PublishRequest, 0, 8)
+	for _, request := range repo.requests {
+		if request.AppId == appId {
+			r := *request
+			requests = append(requests, &r)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Id > requests[j].Id
+	})
+	return requests, nil
+}
+
+func (repo *RepositoryImpl) UpdatePublishRequestStatus(id int64, from, to byte, reviewer string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	request, ok := repo.requests[id]
+	if !ok {
+		return fmt.Errorf("publish request [id=%d] doesn't exist", id)
+	}
+	if request.Status != from {
+		return &api.StatusError{Id: id, Status: request.Status}
+	}
+	request.Status = to
+	if len(reviewer) > 0 {
+		request.Reviewer = reviewer
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) userByName(name string) *api.User {
+	for _, user := range repo.users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) exists(name, env string) bool {
+	for _, app := range repo.apps {
+		if app.Name == name && app.Env == env {
+			return true
+		}
+	}
+	return false
+}
@@ -16,6 +16,7 @@ package mysql
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/cflion/cflion/pkg/console/api"
 	"github.com/cflion/cflion/pkg/log"
 )
@@ -77,3 +78,234 @@ func (repo *RepositoryImpl) InsertApp(app *api.App) (int64, error) {
 	}
 	return res.LastInsertId()
 }
+
+func (repo *RepositoryImpl) DeleteApp(id int64) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("Delete app [id=%d] error: %s", id, err)
+		return err
+	}
+	for _, query := range []string{"delete from app_role where app_id = ?", "delete from publish_request where app_id = ?", "delete from app where id = ?"} {
+		if _, err = tx.Exec(query, id); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Errorf("Delete app [id=%d] error: %s", id, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (repo *RepositoryImpl) QueryUsers() ([]*api.User, error) {
+	rows, err := repo.DB.Query("select id, name, admin from user order by id")
+	if err != nil {
+		log.Errorf("Query all users error: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]*api.User, 0, 8)
+	for rows.Next() {
+		var user api.User
+		rows.Scan(&user.Id, &user.Name, &user.Admin)
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+func (repo *RepositoryImpl) GetUserById(id int64) (*api.User, error) {
+	var user api.User
+	err := repo.DB.QueryRow("select id, name, admin from user where id = ?", id).Scan(&user.Id, &user.Name, &user.Admin)
+	if err != nil {
+		log.Errorf("Get user info [id=%d] error: %s", id, err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (repo *RepositoryImpl) GetUserByName(name string) (*api.User, error) {
+	var user api.User
+	err := repo.DB.QueryRow("select id, name, admin from user where name = ?", name).Scan(&user.Id, &user.Name, &user.Admin)
+	if err != nil {
+		log.Errorf("Get user info [name=%s] error: %s", name, err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (repo *RepositoryImpl) ExistsUserByName(name string) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from user where name = ?", name).Scan(&count)
+	if err != nil {
+		log.Errorf("Count user [name=%s] error: %s", name, err)
+		return false
+	}
+	return count == 1
+}
+
+func (repo *RepositoryImpl) InsertUser(user *api.User) (int64, error) {
+	res, err := repo.DB.Exec("insert into user (name, admin, ctime, utime) values (?, ?, now(), now())", user.Name, user.Admin)
+	if err != nil {
+		log.Errorf("Create user [name=%s] error: %s", user.Name, err)
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) InsertToken(token *api.Token) (int64, error) {
+	res, err := repo.DB.Exec("insert into user_token (user_id, name, hash, ctime, utime) select id, ?, ?, now(), now() from user where id = ?", token.Name, token.Hash, token.UserId)
+	if err != nil {
+		log.Errorf("Create token [name=%s] of user [id=%d] error: %s", token.Name, token.UserId, err)
+		return -1, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return -1, fmt.Errorf("user [id=%d] doesn't exist", token.UserId)
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) GetUserByTokenHash(hash string) (*api.User, error) {
+	var user api.User
+	err := repo.DB.QueryRow("select u.id, u.name, u.admin from user_token t join user u on u.id = t.user_id where t.hash = ?", hash).Scan(&user.Id, &user.Name, &user.Admin)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err != nil {
+		log.Errorf("Get user by token error: %s", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (repo *RepositoryImpl) DeleteToken(userId, tokenId int64) error {
+	_, err := repo.DB.Exec("delete from user_token where id = ? and user_id = ?", tokenId, userId)
+	if err != nil {
+		log.Errorf("Delete token [id=%d] of user [id=%d] error: %s", tokenId, userId, err)
+		return err
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) GetRole(userId, appId int64) (api.Role, error) {
+	var role api.Role
+	err := repo.DB.QueryRow("select role from app_role where user_id = ? and app_id = ?", userId, appId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return api.RoleNone, nil
+	}
+	if err != nil {
+		log.Errorf("Get role of user [id=%d] on app [id=%d] error: %s", userId, appId, err)
+		return api.RoleNone, err
+	}
+	return role, nil
+}
+
+func (repo *RepositoryImpl) QueryRoles(appId int64) ([]*api.RoleBinding, error) {
+	rows, err := repo.DB.Query("select user_id, app_id, role from app_role where app_id = ? order by user_id", appId)
+	if err != nil {
+		log.Errorf("Query roles on app [id=%d] error: %s", appId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	bindings := make([]*api.RoleBinding, 0, 8)
+	for rows.Next() {
+		var binding api.RoleBinding
+		rows.Scan(&binding.UserId, &binding.AppId, &binding.Role)
+		bindings = append(bindings, &binding)
+	}
+	return bindings, nil
+}
+
+func (repo *RepositoryImpl) SaveRole(binding *api.RoleBinding) error {
+	res, err := repo.DB.Exec("insert into app_role (user_id, app_id, role, ctime, utime) select u.id, a.id, ?, now(), now() from user u, app a where u.id = ? and a.id = ? on duplicate key update role = values(role)", binding.Role, binding.UserId, binding.AppId)
+	if err != nil {
+		log.Errorf("Save role of user [id=%d] on app [id=%d] error: %s", binding.UserId, binding.AppId, err)
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 && !repo.existsRole(binding) {
+		return fmt.Errorf("user [id=%d] or app [id=%d] doesn't exist", binding.UserId, binding.AppId)
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) DeleteRole(userId, appId int64) error {
+	_, err := repo.DB.Exec("delete from app_role where user_id = ? and app_id = ?", userId, appId)
+	if err != nil {
+		log.Errorf("Delete role of user [id=%d] on app [id=%d] error: %s", userId, appId, err)
+		return err
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) InsertPublishRequest(request *api.PublishRequest) (int64, error) {
+	res, err := repo.DB.Exec("insert into publish_request (app_id, comment, gray, checksum, requester, reviewer, status, expire_time, ctime, utime) select id, ?, ?, ?, ?, '', ?, ?, now(), now() from app where id = ?",
+		request.Comment, request.Gray, request.Checksum, request.Requester, api.PublishPending, request.ExpireTime, request.AppId)
+	if err != nil {
+		log.Errorf("Create publish request of app [id=%d] error: %s", request.AppId, err)
+		return -1, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return -1, fmt.Errorf("app [id=%d] doesn't exist", request.AppId)
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) GetPublishRequest(id int64) (*api.PublishRequest, error) {
+	request, err := scanPublishRequest(repo.DB.QueryRow("select id, app_id, comment, gray, checksum, requester, reviewer, status, expire_time, ctime from publish_request where id = ?", id))
+	if err != nil {
+		log.Errorf("Get publish request [id=%d] error: %s", id, err)
+		return nil, err
+	}
+	return request, nil
+}
+
+func (repo *RepositoryImpl) QueryPublishRequests(appId int64) ([]*api.PublishRequest, error) {
+	rows, err := repo.DB.Query("select id, app_id, comment, gray, checksum, requester, reviewer, status, expire_time, ctime from publish_request where app_id = ? order by id desc", appId)
+	if err != nil {
+		log.Errorf("Query publish requests of app [id=%d] error: %s", appId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	requests := make([]*api.PublishRequest, 0, 8)
+	for rows.Next() {
+		request, err := scanPublishRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func (repo *RepositoryImpl) UpdatePublishRequestStatus(id int64, from, to byte, reviewer string) error {
+	res, err := repo.DB.Exec("update publish_request set status = ?, reviewer = if(? = '', reviewer, ?), utime = now() where id = ? and status = ?", to, reviewer, reviewer, id, from)
+	if err != nil {
+		log.Errorf("Update publish request [id=%d] status error: %s", id, err)
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		var status byte
+		if err = repo.DB.QueryRow("select status from publish_request where id = ?", id).Scan(&status); err != nil {
+			return fmt.Errorf("publish request [id=%d] doesn't exist", id)
+		}
+		return &api.StatusError{Id: id, Status: status}
+	}
+	return nil
+}
+
+func scanPublishRequest(row interface {
+	Scan(dest ...interface{}) error
+}) (*api.PublishRequest, error) {
+	var request api.PublishRequest
+	err := row.Scan(&request.Id, &request.AppId, &request.Comment, &request.Gray, &request.Checksum, &request.Requester, &request.Reviewer, &request.Status, &request.ExpireTime, &request.Ctime)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// existsRole reports whether the binding is saved already, an unchanged row isn't counted as affected.
+func (repo *RepositoryImpl) existsRole(binding *api.RoleBinding) bool {
+	role, err := repo.GetRole(binding.UserId, binding.AppId)
+	return err == nil && role == binding.Role
+}
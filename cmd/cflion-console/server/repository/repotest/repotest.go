@@ -0,0 +1,248 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package repotest is the contract test suite every implementation of server.Repository must pass.
+package repotest
+
+import (
+	"github.com/cflion/cflion/cmd/cflion-console/server"
+	"github.com/cflion/cflion/pkg/console/api"
+	"testing"
+	"time"
+)
+
+// TestRepository runs the suite, newRepo must return an empty repository for every test case.
+func TestRepository(t *testing.T, newRepo func() server.Repository) {
+	t.Run("App", func(t *testing.T) { testApp(t, newRepo()) })
+	t.Run("AppUniqueness", func(t *testing.T) { testAppUniqueness(t, newRepo()) })
+	t.Run("User", func(t *testing.T) { testUser(t, newRepo()) })
+	t.Run("Role", func(t *testing.T) { testRole(t, newRepo()) })
+	t.Run("PublishRequest", func(t *testing.T) { testPublishRequest(t, newRepo()) })
+}
+
+func testApp(t *testing.T, repo server.Repository) {
+	devId, err := repo.InsertApp(&api.App{Name: "demo", Env: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prodId, err := repo.InsertApp(&api.App{Name: "other", Env: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.ExistsAppByNameAndEnv("demo", "dev") || repo.ExistsAppByNameAndEnv("demo", "prod") {
+		t.Error("expect app demo exists only in env dev")
+	}
+	app, err := repo.GetAppById(devId)
+	if err != nil || app.Name != "demo" || app.Env != "dev" {
+		t.Errorf("GetAppById got %v: %v", app, err)
+	}
+	if _, err = repo.GetAppById(prodId + 1000); err == nil {
+		t.Error("expect error for unknown app")
+	}
+	app, err = repo.GetAppByName("other")
+	if err != nil || app.Id != prodId || app.Env != "prod" {
+		t.Errorf("GetAppByName got %v: %v", app, err)
+	}
+	if _, err = repo.GetAppByName("none"); err == nil {
+		t.Error("expect error for unknown app")
+	}
+	apps, err := repo.QueryAppsBrief()
+	if err != nil || len(apps) != 2 {
+		t.Fatalf("expect 2 apps, got %v: %v", apps, err)
+	}
+	if err = repo.DeleteApp(devId); err != nil {
+		t.Fatal(err)
+	}
+	if repo.ExistsAppByNameAndEnv("demo", "dev") {
+		t.Error("expect app demo deleted")
+	}
+	if apps, _ = repo.QueryAppsBrief(); len(apps) != 1 || apps[0].Id != prodId {
+		t.Errorf("expect only app [id=%d] left, got %v", prodId, apps)
+	}
+}
+
+func testAppUniqueness(t *testing.T, repo server.Repository) {
+	if _, err := repo.InsertApp(&api.App{Name: "demo", Env: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertApp(&api.App{Name: "demo", Env: "dev"}); err == nil {
+		t.Error("expect error when inserting an app with a duplicate name in the same env")
+	}
+	if _, err := repo.InsertApp(&api.App{Name: "demo", Env: "prod"}); err != nil {
+		t.Errorf("expect the same name allowed in another env: %s", err)
+	}
+}
+
+func testUser(t *testing.T, repo server.Repository) {
+	aliceId, err := repo.InsertUser(&api.User{Name: "alice", Admin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobId, err := repo.InsertUser(&api.User{Name: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertUser(&api.User{Name: "bob"}); err == nil {
+		t.Error("expect error when inserting a user with a duplicate name")
+	}
+	if !repo.ExistsUserByName("alice") || repo.ExistsUserByName("none") {
+		t.Error("expect only user alice exists")
+	}
+	user, err := repo.GetUserByName("alice")
+	if err != nil || user.Id != aliceId || !user.Admin {
+		t.Errorf("GetUserByName got %v: %v", user, err)
+	}
+	user, err = repo.GetUserById(bobId)
+	if err != nil || user.Name != "bob" || user.Admin {
+		t.Errorf("GetUserById got %v: %v", user, err)
+	}
+	if users, err := repo.QueryUsers(); err != nil || len(users) != 2 {
+		t.Errorf("expect 2 users, got %v: %v", users, err)
+	}
+
+	tokenId, err := repo.InsertToken(&api.Token{UserId: bobId, Name: "ci", Hash: api.HashToken("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertToken(&api.Token{UserId: bobId + aliceId + 1000, Name: "ci", Hash: api.HashToken("other")}); err == nil {
+		t.Error("expect error when inserting a token of an unknown user")
+	}
+	user, err = repo.GetUserByTokenHash(api.HashToken("secret"))
+	if err != nil || user.Id != bobId {
+		t.Errorf("GetUserByTokenHash got %v: %v", user, err)
+	}
+	if _, err = repo.GetUserByTokenHash(api.HashToken("unknown")); err == nil {
+		t.Error("expect error for unknown token")
+	}
+	// only the owner deletes the token
+	if err = repo.DeleteToken(aliceId, tokenId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetUserByTokenHash(api.HashToken("secret")); err != nil {
+		t.Errorf("expect the token of bob kept: %s", err)
+	}
+	if err = repo.DeleteToken(bobId, tokenId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetUserByTokenHash(api.HashToken("secret")); err == nil {
+		t.Error("expect the token deleted")
+	}
+}
+
+func testRole(t *testing.T, repo server.Repository) {
+	userId, err := repo.InsertUser(&api.User{Name: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devId, err := repo.InsertApp(&api.App{Name: "demo", Env: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prodId, err := repo.InsertApp(&api.App{Name: "demo", Env: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, err := repo.GetRole(userId, devId); err != nil || role != api.RoleNone {
+		t.Errorf("expect no role, got %s: %v", role, err)
+	}
+	if err = repo.SaveRole(&api.RoleBinding{UserId: userId, AppId: devId, Role: api.RoleViewer}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveRole(&api.RoleBinding{UserId: userId, AppId: devId, Role: api.RolePublisher}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveRole(&api.RoleBinding{UserId: userId, AppId: prodId, Role: api.RoleViewer}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveRole(&api.RoleBinding{UserId: userId, AppId: prodId + 1000, Role: api.RoleViewer}); err == nil {
+		t.Error("expect error when granting a role on an unknown app")
+	}
+	if role, _ := repo.GetRole(userId, devId); role != api.RolePublisher {
+		t.Errorf("expect publisher on dev, got %s", role)
+	}
+	bindings, err := repo.QueryRoles(prodId)
+	if err != nil || len(bindings) != 1 || bindings[0].UserId != userId || bindings[0].Role != api.RoleViewer {
+		t.Errorf("QueryRoles got %v: %v", bindings, err)
+	}
+	if err = repo.DeleteRole(userId, prodId); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := repo.GetRole(userId, prodId); role != api.RoleNone {
+		t.Errorf("expect the role on prod revoked, got %s", role)
+	}
+	// the roles are deleted with the app
+	if err = repo.DeleteApp(devId); err != nil {
+		t.Fatal(err)
+	}
+	if bindings, _ = repo.QueryRoles(devId); len(bindings) != 0 {
+		t.Errorf("expect no role on the deleted app, got %v", bindings)
+	}
+}
+
+func testPublishRequest(t *testing.T, repo server.Repository) {
+	appId, err := repo.InsertApp(&api.App{Name: "demo", Env: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	firstId, err := repo.InsertPublishRequest(&api.PublishRequest{AppId: appId, Comment: "first", Requester: "bob", ExpireTime: expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondId, err := repo.InsertPublishRequest(&api.PublishRequest{AppId: appId, Comment: "second", Gray: `{"clients":["10.0.0.1"]}`, Checksum: "abc", Requester: "bob", ExpireTime: expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertPublishRequest(&api.PublishRequest{AppId: appId + 1000, Requester: "bob", ExpireTime: expire}); err == nil {
+		t.Error("expect error when requesting to publish an unknown app")
+	}
+	request, err := repo.GetPublishRequest(secondId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.AppId != appId || request.Comment != "second" || request.Gray != `{"clients":["10.0.0.1"]}` || request.Checksum != "abc" || request.Requester != "bob" ||
+		request.Status != api.PublishPending || !request.ExpireTime.Equal(expire) {
+		t.Errorf("GetPublishRequest got %s", request)
+	}
+	requests, err := repo.QueryPublishRequests(appId)
+	if err != nil || len(requests) != 2 || requests[0].Id != secondId || requests[1].Id != firstId {
+		t.Errorf("expect the requests from the newest, got %v: %v", requests, err)
+	}
+
+	if err = repo.UpdatePublishRequestStatus(firstId, api.PublishPending, api.PublishApproved, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// the status is compared and swapped
+	err = repo.UpdatePublishRequestStatus(firstId, api.PublishPending, api.PublishRejected, "carol")
+	if statusErr, ok := err.(*api.StatusError); !ok || statusErr.Status != api.PublishApproved {
+		t.Errorf("expect StatusError of approved, got %v", err)
+	}
+	if err = repo.UpdatePublishRequestStatus(firstId, api.PublishApproved, api.PublishPublished, ""); err != nil {
+		t.Fatal(err)
+	}
+	if request, _ = repo.GetPublishRequest(firstId); request.Status != api.PublishPublished || request.Reviewer != "alice" {
+		t.Errorf("expect published and reviewed by alice, got %s", request)
+	}
+	if err = repo.UpdatePublishRequestStatus(secondId+1000, api.PublishPending, api.PublishApproved, "alice"); err == nil {
+		t.Error("expect error when updating an unknown request")
+	}
+
+	// the requests are deleted with the app
+	if err = repo.DeleteApp(appId); err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ = repo.QueryPublishRequests(appId); len(requests) != 0 {
+		t.Errorf("expect no request of the deleted app, got %v", requests)
+	}
+}
@@ -14,7 +14,14 @@
 
 package server
 
-import "github.com/cflion/cflion/pkg/console/api"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/cflion/cflion/pkg/console/api"
+	"github.com/cflion/cflion/pkg/log"
+	"time"
+)
 
 type Repository interface {
 	QueryAppsBrief() ([]*api.App, error)
@@ -22,6 +29,25 @@ type Repository interface {
 	GetAppByName(name string) (*api.App, error)
 	ExistsAppByNameAndEnv(name, env string) bool
 	InsertApp(app *api.App) (int64, error)
+	DeleteApp(id int64) error
+	QueryUsers() ([]*api.User, error)
+	GetUserById(id int64) (*api.User, error)
+	GetUserByName(name string) (*api.User, error)
+	ExistsUserByName(name string) bool
+	InsertUser(user *api.User) (int64, error)
+	InsertToken(token *api.Token) (int64, error)
+	GetUserByTokenHash(hash string) (*api.User, error)
+	DeleteToken(userId, tokenId int64) error
+	GetRole(userId, appId int64) (api.Role, error)
+	QueryRoles(appId int64) ([]*api.RoleBinding, error)
+	SaveRole(binding *api.RoleBinding) error
+	DeleteRole(userId, appId int64) error
+	InsertPublishRequest(request *api.PublishRequest) (int64, error)
+	GetPublishRequest(id int64) (*api.PublishRequest, error)
+	// QueryPublishRequests returns the requests of the app from the newest.
+	QueryPublishRequests(appId int64) ([]*api.PublishRequest, error)
+	// UpdatePublishRequestStatus fails with *api.StatusError if the status of the request isn't from.
+	UpdatePublishRequestStatus(id int64, from, to byte, reviewer string) error
 }
 
 type ServiceImpl struct {
@@ -60,3 +86,167 @@ func (service *ServiceImpl) CreateApp(name, env string) (int64, error) {
 	app := &api.App{Name: name, Env: env}
 	return service.Repo.InsertApp(app)
 }
+
+func (service *ServiceImpl) DeleteApp(id int64) error {
+	return service.Repo.DeleteApp(id)
+}
+
+func (service *ServiceImpl) Authenticate(token string) (*api.User, error) {
+	if len(token) == 0 {
+		return nil, fmt.Errorf("missing token")
+	}
+	return service.Repo.GetUserByTokenHash(api.HashToken(token))
+}
+
+func (service *ServiceImpl) ListUsers() ([]map[string]interface{}, error) {
+	users, err := service.Repo.QueryUsers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		result = append(result, map[string]interface{}{
+			"id":    user.Id,
+			"name":  user.Name,
+			"admin": user.Admin,
+		})
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) GetUserById(id int64) (*api.User, error) {
+	return service.Repo.GetUserById(id)
+}
+
+func (service *ServiceImpl) ExistsUserByName(name string) bool {
+	return service.Repo.ExistsUserByName(name)
+}
+
+func (service *ServiceImpl) CreateUser(name string, admin bool) (int64, error) {
+	return service.Repo.InsertUser(&api.User{Name: name, Admin: admin})
+}
+
+// CreateToken generates a random token for the user, the token is returned only once.
+func (service *ServiceImpl) CreateToken(userId int64, name string) (int64, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return -1, "", err
+	}
+	token := hex.EncodeToString(b)
+	id, err := service.Repo.InsertToken(&api.Token{UserId: userId, Name: name, Hash: api.HashToken(token)})
+	if err != nil {
+		return -1, "", err
+	}
+	return id, token, nil
+}
+
+func (service *ServiceImpl) DeleteToken(userId, tokenId int64) error {
+	return service.Repo.DeleteToken(userId, tokenId)
+}
+
+// Bootstrap creates the admin user with the token if they don't exist, so the first admin can log in.
+func (service *ServiceImpl) Bootstrap(name, token string) error {
+	if !service.Repo.ExistsUserByName(name) {
+		if _, err := service.Repo.InsertUser(&api.User{Name: name, Admin: true}); err != nil {
+			return err
+		}
+	}
+	user, err := service.Repo.GetUserByName(name)
+	if err != nil {
+		return err
+	}
+	if !user.Admin {
+		return fmt.Errorf("user [name=%s] isn't an admin", name)
+	}
+	if owner, err := service.Repo.GetUserByTokenHash(api.HashToken(token)); err == nil {
+		if owner.Id != user.Id {
+			return fmt.Errorf("the bootstrap token belongs to user [name=%s]", owner.Name)
+		}
+		return nil
+	}
+	_, err = service.Repo.InsertToken(&api.Token{UserId: user.Id, Name: "bootstrap", Hash: api.HashToken(token)})
+	return err
+}
+
+// GetRole returns the role of the user on the app, an admin is the publisher of every app.
+func (service *ServiceImpl) GetRole(user *api.User, appId int64) api.Role {
+	if user.Admin {
+		return api.RolePublisher
+	}
+	role, err := service.Repo.GetRole(user.Id, appId)
+	if err != nil {
+		return api.RoleNone
+	}
+	return role
+}
+
+func (service *ServiceImpl) ListRoles(appId int64) ([]map[string]interface{}, error) {
+	bindings, err := service.Repo.QueryRoles(appId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(bindings))
+	for _, binding := range bindings {
+		user, err := service.Repo.GetUserById(binding.UserId)
+		if err != nil {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"user_id":   user.Id,
+			"user_name": user.Name,
+			"role":      binding.Role.String(),
+		})
+	}
+	return result, nil
+}
+
+// GrantRole grants the role on the app to the user, RoleNone revokes the role.
+func (service *ServiceImpl) GrantRole(userId, appId int64, role api.Role) error {
+	if role == api.RoleNone {
+		return service.Repo.DeleteRole(userId, appId)
+	}
+	return service.Repo.SaveRole(&api.RoleBinding{UserId: userId, AppId: appId, Role: role})
+}
+
+func (service *ServiceImpl) CreatePublishRequest(appId int64, comment, gray, checksum, requester string, ttl time.Duration) (int64, error) {
+	request := &api.PublishRequest{AppId: appId, Comment: comment, Gray: gray, Checksum: checksum, Requester: requester, ExpireTime: time.Now().Add(ttl)}
+	return service.Repo.InsertPublishRequest(request)
+}
+
+func (service *ServiceImpl) GetPublishRequest(id int64) (*api.PublishRequest, error) {
+	request, err := service.Repo.GetPublishRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	service.expire(request)
+	return request, nil
+}
+
+func (service *ServiceImpl) ListPublishRequests(appId int64) ([]map[string]interface{}, error) {
+	requests, err := service.Repo.QueryPublishRequests(appId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(requests))
+	for _, request := range requests {
+		service.expire(request)
+		result = append(result, request.Brief())
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) UpdatePublishRequestStatus(id int64, from, to byte, reviewer string) error {
+	return service.Repo.UpdatePublishRequestStatus(id, from, to, reviewer)
+}
+
+// expire marks the request expired if it has expired, the request is expired lazily when it's read.
+func (service *ServiceImpl) expire(request *api.PublishRequest) {
+	if !request.Expired(time.Now()) {
+		return
+	}
+	if err := service.Repo.UpdatePublishRequestStatus(request.Id, request.Status, api.PublishExpired, ""); err != nil {
+		log.Errorf("Expire publish request [id=%d] error: %s", request.Id, err)
+		return
+	}
+	request.Status = api.PublishExpired
+}
@@ -18,6 +18,10 @@ import (
 	"flag"
 	"fmt"
 	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/publisher/etcd"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/publisher/file"
+	publishermemory "github.com/cflion/cflion/cmd/cflion-manager/server/publisher/memory"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/memory"
 	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/mysql"
 	"github.com/cflion/cflion/pkg/database"
 	"github.com/cflion/cflion/pkg/log"
@@ -40,9 +44,19 @@ func init() {
 	viper.SetDefault("server.writeTimeout", 3)
 	viper.SetDefault("server.quitTimeout", 5)
 	viper.SetDefault("logging.level", "INFO")
+	viper.SetDefault("db.driver", "mysql")
 	viper.SetDefault("db.maxIdle", 20)
 	viper.SetDefault("db.maxOpen", 100)
+	viper.SetDefault("etcd.dialTimeout", 5)
 	viper.SetDefault("etcd.requestTimeout", 3)
+	viper.SetDefault("publisher.driver", "etcd")
+	viper.SetDefault("publisher.file.dir", "data/publish")
+	viper.SetDefault("publisher.layout", api.LayoutBlob)
+	viper.SetDefault("auth.skew", 300)
+	viper.SetDefault("webhook.timeout", 5)
+	viper.SetDefault("webhook.retries", 3)
+	viper.SetDefault("webhook.backoff", 1)
+	viper.SetDefault("poll.maxWait", 60)
 	viper.SetConfigFile(*confPath)
 	viper.AddConfigPath(".")
 	err := viper.ReadInConfig()
@@ -60,22 +74,42 @@ func init() {
 }
 
 func main() {
-	dbCfg := &database.DBConfig{
-		Username: viper.GetString("db.username"),
-		Password: viper.GetString("db.password"),
-		Host:     viper.GetString("db.host"),
-		Port:     viper.GetInt("db.port"),
-		Database: viper.GetString("db.database"),
-		MaxIdle:  viper.GetInt("db.maxIdle"),
-		MaxOpen:  viper.GetInt("db.maxOpen"),
-	}
-	db, err := database.ConnectDatabase(dbCfg)
+	repo, err := newRepository()
 	if err != nil {
 		log.Errorf("Fatal error when connect to db: %s", err)
 		os.Exit(1)
 	}
-	var repo server.Repository = &mysql.RepositoryImpl{DB: db}
-	var service api.Service = &server.ServiceImpl{Repo: repo}
+	publisher, err := newPublisher()
+	if err != nil {
+		log.Errorf("Fatal error when create publisher: %s", err)
+		os.Exit(1)
+	}
+	defer publisher.Close()
+	layout := viper.GetString("publisher.layout")
+	if !api.ValidLayout(layout) {
+		log.Errorf("Fatal error unknown publish layout [%s]", layout)
+		os.Exit(1)
+	}
+	notifier := server.NewNotifier(repo,
+		time.Duration(viper.GetInt("webhook.timeout"))*time.Second,
+		viper.GetInt("webhook.retries"),
+		time.Duration(viper.GetInt("webhook.backoff"))*time.Second)
+	defer notifier.Close()
+	watcher, err := server.NewConfigWatcher(publisher)
+	if err != nil {
+		log.Errorf("Fatal error when watch the published config: %s", err)
+		os.Exit(1)
+	}
+	var service api.Service = &server.ServiceImpl{Repo: repo, Publisher: publisher, Layout: layout, Notifier: notifier, Watcher: watcher}
+	secret := viper.GetString("auth.secret")
+	if len(secret) == 0 {
+		log.Warn("auth.secret is empty, the manager accepts anonymous requests")
+	}
+	clientSecret := viper.GetString("auth.clientSecret")
+	if len(clientSecret) == 0 {
+		log.Warn("auth.clientSecret is empty, the manager accepts anonymous requests of the clients")
+	}
+	skew := time.Duration(viper.GetInt("auth.skew")) * time.Second
 
 	srvCfg := &restful.ServerConfig{
 		ListenAddr:      fmt.Sprintf("%s:%d", viper.GetString("server.host"), viper.GetInt("server.port")),
@@ -86,22 +120,55 @@ func main() {
 		LoggingFilePath: viper.GetString("logging.file"),
 	}
 	srv := restful.NewServer(srvCfg, func(router *gin.Engine) {
-		v1 := router.Group("/v1")
-		{
-			v1.POST("/apps", server.CreateApp(service))
-			v1.PUT("/apps", server.PublishApp(service))
-			v1.GET("/apps/:name", server.ViewApp(service))
-			v1.PUT("/apps/:name", server.UpdateApp(service))
-
-			v1.GET("/config-files", server.ListConfigFiles(service))
-			v1.POST("/config-files", server.CreateConfigFile(service))
-			v1.GET("/config-files/:file_id", server.ViewConfigFile(service))
-			v1.PUT("/config-files/:file_id", server.UpdateConfigFile(service))
-
-			v1.GET("/watchers", server.QueryWatcher(service))
-		}
+		// the clients sign with their own secret, which grants nothing else
+		server.RegisterClientRoutes(router.Group("/v1", server.Authenticate(clientSecret, skew)), service)
+		v1 := router.Group("/v1", server.Authenticate(secret, skew))
+		server.RegisterRoutes(v1, service)
 	})
+	// the long polling requests are ended before the server waits for the active requests
+	srv.OnShutdown(watcher.Close)
 	srv.Start()
 	<-srv.Stop()
 	log.Info("server exited")
 }
+
+// newRepository creates the repository of the db.driver, which is mysql by default.
+func newRepository() (server.Repository, error) {
+	switch driver := viper.GetString("db.driver"); driver {
+	case "mysql":
+		dbCfg := &database.DBConfig{
+			Username: viper.GetString("db.username"),
+			Password: viper.GetString("db.password"),
+			Host:     viper.GetString("db.host"),
+			Port:     viper.GetInt("db.port"),
+			Database: viper.GetString("db.database"),
+			MaxIdle:  viper.GetInt("db.maxIdle"),
+			MaxOpen:  viper.GetInt("db.maxOpen"),
+		}
+		db, err := database.ConnectDatabase(dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.RepositoryImpl{DB: db}, nil
+	case "memory":
+		return memory.NewRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown db driver [%s]", driver)
+	}
+}
+
+// newPublisher creates the publisher of the publisher.driver, which is etcd by default.
+func newPublisher() (server.Publisher, error) {
+	switch driver := viper.GetString("publisher.driver"); driver {
+	case "etcd":
+		return etcd.NewPublisher(viper.GetStringSlice("etcd.endpoints"),
+			time.Duration(viper.GetInt("etcd.dialTimeout"))*time.Second,
+			time.Duration(viper.GetInt("etcd.requestTimeout"))*time.Second)
+	case "file":
+		return file.NewPublisher(viper.GetString("publisher.file.dir"))
+	case "memory":
+		return publishermemory.NewPublisher(), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher driver [%s]", driver)
+	}
+}
@@ -0,0 +1,44 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/transport/restful"
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// Authenticate rejects the requests which are not signed by the secret with 401, the console signs
+// every request with the operator. A signed request is accepted only once within the skew. All the requests
+// are accepted when the secret is empty. The body larger than signature.MaxBodySize is rejected with 413.
+func Authenticate(secret string, skew time.Duration) gin.HandlerFunc {
+	nonces := signature.NewNonces()
+	return func(ctx *gin.Context) {
+		if len(secret) == 0 {
+			return
+		}
+		if err := signature.VerifyRequest(ctx.Request, secret, skew, nonces); err != nil {
+			log.Warnf("Reject request [%s %s] from [%s] error: %s", ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP(), err)
+			status := http.StatusUnauthorized
+			if err == signature.ErrBodyTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			ctx.AbortWithStatusJSON(status, restful.ResponseRet{Msg: err.Error()})
+		}
+	}
+}
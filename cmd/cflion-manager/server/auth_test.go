@@ -0,0 +1,121 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newRouter := func(secret string) *gin.Engine {
+		router := gin.New()
+		router.Group("/v1", Authenticate(secret, time.Minute)).GET("/apps/:name", func(ctx *gin.Context) {
+			ctx.String(http.StatusOK, operator(ctx))
+		})
+		return router
+	}
+	cases := []struct {
+		secret   string
+		signedBy string
+		status   int
+		operator string
+	}{
+		{"secret", "secret", http.StatusOK, "alice"},
+		{"secret", "other", http.StatusUnauthorized, ""},
+		{"secret", "", http.StatusUnauthorized, ""},
+		{"", "", http.StatusOK, "alice"},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", "/v1/apps/demo", nil)
+		req.Header.Set(OperatorHeader, "alice")
+		if len(c.signedBy) > 0 {
+			signature.SignRequest(req, c.signedBy, "")
+		}
+		w := httptest.NewRecorder()
+		newRouter(c.secret).ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("case %d: expect status %d, got %d: %s", i, c.status, w.Code, w.Body.String())
+		}
+		if c.status == http.StatusOK && w.Body.String() != c.operator {
+			t.Errorf("case %d: expect operator %s, got %s", i, c.operator, w.Body.String())
+		}
+	}
+
+	// the signed request can't be replayed
+	router := newRouter("secret")
+	req := httptest.NewRequest("GET", "/v1/apps/demo", nil)
+	signature.SignRequest(req, "secret", "alice")
+	for i, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("attempt %d: expect status %d, got %d: %s", i, status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAuthenticate_BodyTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Group("/v1", Authenticate("secret", time.Minute)).PUT("/apps/:name", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest("PUT", "/v1/apps/demo", strings.NewReader(strings.Repeat("a", signature.MaxBodySize+1)))
+	req.Header.Set("Authorization", signature.Scheme+" "+strconv.FormatInt(time.Now().Unix(), 10)+":n1:forged")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect status 413, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterClientRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, _, _ := newTestService(t)
+	router := gin.New()
+	RegisterClientRoutes(router.Group("/v1", Authenticate("client", time.Minute)), service)
+	RegisterRoutes(router.Group("/v1", Authenticate("secret", time.Minute)), service)
+	cases := []struct {
+		method     string
+		url        string
+		signedBy   string
+		authorized bool
+	}{
+		{"GET", "/v1/watchers?app=demo", "client", true},
+		{"GET", "/v1/apps/demo/config", "client", true},
+		{"GET", "/v1/watchers?app=demo", "secret", false},
+		// the secret of the clients grants nothing else
+		{"GET", "/v1/apps/demo", "client", false},
+		{"DELETE", "/v1/apps/demo", "client", false},
+		{"GET", "/v1/apps/demo", "secret", true},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		signature.SignRequest(req, c.signedBy, "alice")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if authorized := w.Code != http.StatusUnauthorized; authorized != c.authorized {
+			t.Errorf("case %d: expect authorized %t, got %d: %s", i, c.authorized, w.Code, w.Body.String())
+		}
+	}
+}
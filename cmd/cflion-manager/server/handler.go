@@ -16,15 +16,24 @@ package server
 
 import (
 	"fmt"
+	"github.com/cflion/cflion/pkg/log"
 	"github.com/cflion/cflion/pkg/manager/api"
 	"github.com/cflion/cflion/pkg/transport/restful"
+	"github.com/cflion/cflion/pkg/transport/signature"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/spf13/viper"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// OperatorHeader carries the name of the user who performs the request.
+const OperatorHeader = signature.OperatorHeader
+
 func CreateApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		var params struct {
@@ -38,7 +47,7 @@ func CreateApp(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("App [name=%s] already exists", params.Name)})
 			return
 		}
-		_, err := service.CreateApp(params.Name)
+		_, err := service.CreateApp(params.Name, requestOperator(ctx))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -50,18 +59,37 @@ func CreateApp(service api.Service) func(ctx *gin.Context) {
 func PublishApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		var params struct {
-			Name string `json:"name" binding:"required"`
+			Name    string `json:"name" binding:"required"`
+			Comment string `json:"comment"`
+			// Gray publishes to the instances matching the rule only
+			Gray *api.GrayRule `json:"gray"`
+			// Checksum of the config from DiffApp, the config is published only if it's unchanged
+			Checksum string `json:"checksum"`
 		}
 		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
+		if params.Gray != nil {
+			if err := params.Gray.Validate(); err != nil {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+		}
 		app, err := service.GetAppByName(params.Name)
 		if err != nil {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		err = service.PublishApp(app.Id)
+		if params.Gray != nil {
+			err = service.PublishAppGray(app.Id, params.Gray, params.Checksum, requestOperator(ctx), params.Comment)
+		} else {
+			err = service.PublishApp(app.Id, params.Checksum, requestOperator(ctx), params.Comment)
+		}
+		if _, ok := err.(*api.ChecksumConflictError); ok {
+			ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -87,6 +115,55 @@ func ViewApp(service api.Service) func(ctx *gin.Context) {
 	}
 }
 
+// PollAppConfig responds the published config of the app for the instance if it's newer than the revision,
+// otherwise it waits for a change up to wait, which is capped by poll.maxWait seconds, and responds 304 if
+// nothing changes. The instance is identified by the instance and ip params, ip defaults to the client ip.
+func PollAppConfig(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		if !service.ExistsAppByName(name) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("App [name=%s] doesn't exist", name)})
+			return
+		}
+		var revision int64
+		var err error
+		if value := ctx.Query("revision"); len(value) > 0 {
+			if revision, err = strconv.ParseInt(value, 10, 64); err != nil || revision < 0 {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid revision [%s]", value)})
+				return
+			}
+		}
+		var wait time.Duration
+		if value := ctx.Query("wait"); len(value) > 0 {
+			if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid wait [%s], it must be a duration like 30s", value)})
+				return
+			}
+		}
+		if maxWait := time.Duration(viper.GetInt("poll.maxWait")) * time.Second; wait > maxWait {
+			wait = maxWait
+		}
+		// only this response waits longer than server.writeTimeout
+		if err := restful.SetWriteDeadline(ctx, time.Now().Add(wait+5*time.Second)); err != nil {
+			log.Debugf("Extend write deadline of polling app [name=%s] error: %s", name, err)
+		}
+		ip := ctx.Query("ip")
+		if len(ip) == 0 {
+			ip = ctx.ClientIP()
+		}
+		config, err := service.PollAppConfig(ctx.Request.Context(), name, revision, wait, ctx.Query("instance"), ip)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if config == nil {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: config.Brief()})
+	}
+}
+
 func UpdateApp(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		name := ctx.Param("name")
@@ -102,7 +179,61 @@ func UpdateApp(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		err = service.UpdateAppAssociation(app.Id, params.ConfigFiles)
+		err = service.UpdateAppAssociation(app.Id, params.ConfigFiles, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.Status(http.StatusOK)
+	}
+}
+
+func DeleteApp(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !forced(ctx) {
+			consumers, err := service.ListAppConsumers(app.Id)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			if len(consumers) > 0 {
+				ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: fmt.Sprintf("Config files of app [name=%s] are used by apps %s, delete with force=true to remove them", name, appNames(consumers))})
+				return
+			}
+		}
+		err = service.DeleteApp(app.Id, requestOperator(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("App [name=%s] deletes successfully", name)})
+	}
+}
+
+func DeleteAppAssociation(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsAppAssociation(app.Id, fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Association of app [name=%s] and config file [id=%d] doesn't exists", name, fileId)})
+			return
+		}
+		err = service.DeleteAppAssociation(app.Id, fileId, requestOperator(ctx))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
@@ -111,6 +242,120 @@ func UpdateApp(service api.Service) func(ctx *gin.Context) {
 	}
 }
 
+func DiffApp(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		data, err := service.DiffApp(app.Id)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func ListReleases(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		data, err := service.ListReleases(app.Id)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func RollbackRelease(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		releaseId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsRelease(app.Id, releaseId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Release [id=%d] of app [name=%s] doesn't exists", releaseId, name)})
+			return
+		}
+		err = service.RollbackRelease(app.Id, releaseId, requestOperator(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("App [name=%s] rolls back to release [id=%d] successfully", name, releaseId)})
+	}
+}
+
+func PromoteGrayRelease(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsGrayRelease(app.Id) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Gray release of app [name=%s] doesn't exists", name)})
+			return
+		}
+		var params struct {
+			// Checksum of the config from DiffApp, the gray release is promoted only if it's the same config
+			Checksum string `json:"checksum"`
+		}
+		if err = ctx.ShouldBindWith(&params, binding.JSON); err != nil && err != io.EOF {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		err = service.PromoteGrayRelease(app.Id, params.Checksum, requestOperator(ctx))
+		if _, ok := err.(*api.ChecksumConflictError); ok {
+			ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Gray release of app [name=%s] promotes successfully", name)})
+	}
+}
+
+func AbandonGrayRelease(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		app, err := service.GetAppByName(name)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsGrayRelease(app.Id) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Gray release of app [name=%s] doesn't exists", name)})
+			return
+		}
+		err = service.AbandonGrayRelease(app.Id, requestOperator(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Gray release of app [name=%s] abandons successfully", name)})
+	}
+}
+
 func ListConfigFiles(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		data, err := service.ListConfigFiles()
@@ -128,18 +373,26 @@ func CreateConfigFile(service api.Service) func(ctx *gin.Context) {
 			NamespaceId int64  `json:"namespace_id" binding:"required"`
 			Config      string `json:"config" binding:"required"`
 			Filename    string `json:"filename" binding:"required"`
+			// Format is one of properties, yaml, json and toml, the default is properties
+			Format string `json:"format"`
+			// Public files can be associated with the apps of other namespaces
+			Public bool `json:"public"`
 		}
 		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
 			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
+		if !api.ValidFormat(params.Format) {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Unsupported format [%s]", params.Format)})
+			return
+		}
 		if service.ExistsConfigFileByNameAndNamespaceId(params.Filename, params.NamespaceId) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [name=%s] [namespace_id=%d] already exists", params.Filename, params.NamespaceId)})
 			return
 		}
-		_, err := service.CreateConfigFile(params.Filename, params.NamespaceId, params.Config)
+		_, err := service.CreateConfigFile(params.Filename, params.NamespaceId, params.Format, params.Public, params.Config, requestOperator(ctx))
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			fail(ctx, err)
 			return
 		}
 		ctx.JSON(http.StatusCreated, restful.ResponseRet{Msg: fmt.Sprintf("Config file [name=%s] creates successfully", params.Filename)})
@@ -162,6 +415,7 @@ func ViewConfigFile(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
+		ctx.Header("ETag", etag(data["version"]))
 		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
 	}
 }
@@ -184,15 +438,437 @@ func UpdateConfigFile(service api.Service) func(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
 			return
 		}
-		err = service.UpdateConfigFile(fileId, params.Config)
+		version, ok := bindIfMatch(ctx)
+		if !ok {
+			return
+		}
+		data, err := service.UpdateConfigFile(fileId, version, params.Config, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.Header("ETag", etag(data["version"]))
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func ListConfigFileApps(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		data, err := service.ListConfigFileApps(fileId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+// UpdateConfigFilePublic marks the file public or private, a file used by the apps of other namespaces
+// can only be made private with force=true, which keeps the existing associations.
+func UpdateConfigFilePublic(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		var params struct {
+			Public *bool `json:"public"`
+		}
+		if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if params.Public == nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: "public is required"})
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		if !*params.Public && !forced(ctx) {
+			consumers, err := service.ListConfigFileConsumers(fileId)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			if len(consumers) > 0 {
+				ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] is used by apps %s, make it private with force=true", fileId, appNames(consumers))})
+				return
+			}
+		}
+		if err = service.UpdateConfigFilePublic(fileId, *params.Public, requestOperator(ctx)); err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] updates successfully", fileId)})
+	}
+}
+
+func DeleteConfigFile(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		if !forced(ctx) {
+			consumers, err := service.ListConfigFileConsumers(fileId)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			if len(consumers) > 0 {
+				ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] is used by apps %s, delete with force=true to remove it", fileId, appNames(consumers))})
+				return
+			}
+		}
+		err = service.DeleteConfigFile(fileId, requestOperator(ctx))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
 			return
 		}
-		ctx.Status(http.StatusOK)
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] deletes successfully", fileId)})
 	}
 }
 
+func ListConfigItems(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		data, err := service.ListConfigItems(fileId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func CreateConfigItem(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		item, ok := bindConfigItem(ctx)
+		if !ok {
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		if service.ExistsConfigItemByName(fileId, item.Name) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config item [name=%s] [file_id=%d] already exists", item.Name, fileId)})
+			return
+		}
+		version, ok := bindIfMatch(ctx)
+		if !ok {
+			return
+		}
+		item.FileId = fileId
+		id, version, err := service.CreateConfigItem(item, version, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		item.Id = id
+		ctx.Header("ETag", etag(version))
+		ctx.JSON(http.StatusCreated, restful.ResponseRet{Msg: fmt.Sprintf("Config item [name=%s] creates successfully", item.Name), Data: item.Detail()})
+	}
+}
+
+func ViewConfigItem(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		_, itemId, ok := bindConfigItemId(ctx, service)
+		if !ok {
+			return
+		}
+		data, err := service.ViewConfigItem(itemId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func UpdateConfigItem(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, itemId, ok := bindConfigItemId(ctx, service)
+		if !ok {
+			return
+		}
+		item, ok := bindConfigItem(ctx)
+		if !ok {
+			return
+		}
+		old, err := service.GetConfigItem(itemId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if old.Name != item.Name && service.ExistsConfigItemByName(fileId, item.Name) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config item [name=%s] [file_id=%d] already exists", item.Name, fileId)})
+			return
+		}
+		version, ok := bindIfMatch(ctx)
+		if !ok {
+			return
+		}
+		item.Id, item.FileId = itemId, fileId
+		version, err = service.UpdateConfigItem(item, version, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.Header("ETag", etag(version))
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: item.Detail()})
+	}
+}
+
+func DeleteConfigItem(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, itemId, ok := bindConfigItemId(ctx, service)
+		if !ok {
+			return
+		}
+		version, ok := bindIfMatch(ctx)
+		if !ok {
+			return
+		}
+		version, err := service.DeleteConfigItem(fileId, itemId, version, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.Header("ETag", etag(version))
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Config item [id=%d] deletes successfully", itemId)})
+	}
+}
+
+func ListConfigFileRevisions(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if !service.ExistsConfigFileById(fileId) {
+			ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] doesn't exists", fileId)})
+			return
+		}
+		data, err := service.ListConfigFileRevisions(fileId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func ViewConfigFileRevision(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, revision, ok := bindRevision(ctx, service)
+		if !ok {
+			return
+		}
+		data, err := service.ViewConfigFileRevision(fileId, revision)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func RollbackConfigFile(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		fileId, revision, ok := bindRevision(ctx, service)
+		if !ok {
+			return
+		}
+		version, ok := bindIfMatch(ctx)
+		if !ok {
+			return
+		}
+		version, err := service.RollbackConfigFile(fileId, revision, version, requestOperator(ctx))
+		if err != nil {
+			fail(ctx, err)
+			return
+		}
+		ctx.Header("ETag", etag(version))
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] rolls back to [revision=%d] successfully", fileId, revision)})
+	}
+}
+
+// ListAuditEvents filters the audit events by the query app, actor, since and until, the times are in RFC3339,
+// e.g. 2018-06-01T00:00:00Z. At most limit events are returned, 100 by default.
+func ListAuditEvents(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		filter := &api.AuditFilter{Actor: ctx.Query("actor"), Limit: 100}
+		if name := ctx.Query("app"); len(name) > 0 {
+			app, err := service.GetAppByName(name)
+			if err != nil {
+				ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			filter.AppId = app.Id
+		}
+		var err error
+		for _, param := range []struct {
+			name string
+			t    *time.Time
+		}{{"since", &filter.Since}, {"until", &filter.Until}} {
+			if value := ctx.Query(param.name); len(value) > 0 {
+				if *param.t, err = time.Parse(time.RFC3339, value); err != nil {
+					ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid %s: %s", param.name, err)})
+					return
+				}
+			}
+		}
+		if value := ctx.Query("limit"); len(value) > 0 {
+			if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > 1000 {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid limit [%s], it must be in 1 to 1000", value)})
+				return
+			}
+		}
+		data, err := service.ListAuditEvents(filter)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+func ListWebhooks(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		data, err := service.ListWebhooks()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+// CreateWebhook subscribes the events of the app, or of all the apps if app is empty, and empty events subscribe all.
+func CreateWebhook(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var params struct {
+			Url    string   `json:"url" binding:"required"`
+			Secret string   `json:"secret"`
+			App    string   `json:"app"`
+			Events []string `json:"events"`
+		}
+		if err := ctx.ShouldBindJSON(&params); err != nil {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		if u, err := url.Parse(params.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid url [%s], it must be an absolute http or https url", params.Url)})
+			return
+		}
+		for _, event := range params.Events {
+			if !api.ValidEvent(event) {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Unknown event [%s]", event)})
+				return
+			}
+		}
+		hook := &api.Webhook{Url: params.Url, Secret: params.Secret, Events: params.Events}
+		if len(params.App) > 0 {
+			app, err := service.GetAppByName(params.App)
+			if err != nil {
+				ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+				return
+			}
+			hook.AppId = app.Id
+		}
+		id, err := service.CreateWebhook(hook, requestOperator(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusCreated, restful.ResponseRet{Data: map[string]interface{}{"id": id}})
+	}
+}
+
+func DeleteWebhook(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		id, ok := bindWebhookId(ctx, service)
+		if !ok {
+			return
+		}
+		if err := service.DeleteWebhook(id, requestOperator(ctx)); err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Msg: fmt.Sprintf("Webhook [id=%d] deletes successfully", id)})
+	}
+}
+
+// ListWebhookDeliveries returns the latest attempts to deliver the events to the webhook, 50 by default.
+func ListWebhookDeliveries(service api.Service) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		id, ok := bindWebhookId(ctx, service)
+		if !ok {
+			return
+		}
+		limit := 50
+		if value := ctx.Query("limit"); len(value) > 0 {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > 1000 {
+				ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid limit [%s], it must be in 1 to 1000", value)})
+				return
+			}
+		}
+		data, err := service.ListWebhookDeliveries(id, limit)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: data})
+	}
+}
+
+// bindWebhookId parses the webhook_id param and checks the webhook exists.
+func bindWebhookId(ctx *gin.Context, service api.Service) (int64, bool) {
+	id, err := strconv.ParseInt(ctx.Param("webhook_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return 0, false
+	}
+	if !service.ExistsWebhook(id) {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Webhook [id=%d] doesn't exist", id)})
+		return 0, false
+	}
+	return id, true
+}
+
 func QueryWatcher(service api.Service) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 		var params struct {
@@ -204,7 +880,144 @@ func QueryWatcher(service api.Service) func(ctx *gin.Context) {
 		}
 		app := &api.App{Name: params.App}
 		endpoints := viper.GetStringSlice("etcd.endpoints")
-		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: gin.H{"key": app.Key(), "endpoints": endpoints}})
+		ctx.JSON(http.StatusOK, restful.ResponseRet{Data: gin.H{
+			"key":          app.Key(),
+			"gray_key":     app.GrayKey(),
+			"manifest_key": app.ManifestKey(),
+			"endpoints":    endpoints,
+		}})
+
+	}
+}
+
+// bindRevision parses the file_id and revision params and checks the revision exists.
+func bindRevision(ctx *gin.Context, service api.Service) (int64, int64, bool) {
+	fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return 0, 0, false
+	}
+	revision, err := strconv.ParseInt(ctx.Param("revision"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return 0, 0, false
+	}
+	if !service.ExistsConfigFileRevision(fileId, revision) {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config file [id=%d] [revision=%d] doesn't exists", fileId, revision)})
+		return 0, 0, false
+	}
+	return fileId, revision, true
+}
+
+// bindConfigItemId parses the file_id and item_id params and checks the item exists in the file.
+func bindConfigItemId(ctx *gin.Context, service api.Service) (int64, int64, bool) {
+	fileId, err := strconv.ParseInt(ctx.Param("file_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return 0, 0, false
+	}
+	itemId, err := strconv.ParseInt(ctx.Param("item_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return 0, 0, false
+	}
+	if !service.ExistsConfigItem(fileId, itemId) {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: fmt.Sprintf("Config item [id=%d] [file_id=%d] doesn't exists", itemId, fileId)})
+		return 0, 0, false
+	}
+	return fileId, itemId, true
+}
+
+// bindConfigItem binds and validates the name, value, comment and type of an item in the request body.
+func bindConfigItem(ctx *gin.Context) (*api.ConfigItem, bool) {
+	var params struct {
+		Name    string `json:"name" binding:"required"`
+		Value   string `json:"value"`
+		Comment string `json:"comment"`
+		Type    string `json:"type"`
+	}
+	if err := ctx.ShouldBindWith(&params, binding.JSON); err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return nil, false
+	}
+	item := &api.ConfigItem{Name: params.Name, Value: params.Value, Comment: params.Comment, Type: params.Type}
+	if err := item.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: err.Error()})
+		return nil, false
+	}
+	return item, true
+}
+
+// bindIfMatch parses the version of a config file in the If-Match header, "*" matches any version.
+func bindIfMatch(ctx *gin.Context) (int64, bool) {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if len(ifMatch) == 0 {
+		ctx.JSON(http.StatusPreconditionRequired, restful.ResponseRet{Msg: "If-Match header with the ETag of the config file is required"})
+		return 0, false
+	}
+	if ifMatch == "*" {
+		return 0, true
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if err != nil || version <= 0 {
+		ctx.JSON(http.StatusBadRequest, restful.ResponseRet{Msg: fmt.Sprintf("Invalid If-Match header [%s]", ifMatch)})
+		return 0, false
+	}
+	return version, true
+}
+
+func etag(version interface{}) string {
+	return fmt.Sprintf(`"%v"`, version)
+}
 
+// fail responds 400 if the config can't be parsed or rendered in the format of the file,
+// with the invalid lines of a properties content in data, 409 with the current version
+// if the file has been changed, 422 if a file can't be associated, otherwise 500.
+func fail(ctx *gin.Context, err error) {
+	if _, ok := err.(*api.AssociationError); ok {
+		ctx.JSON(http.StatusUnprocessableEntity, restful.ResponseRet{Msg: err.Error()})
+		return
 	}
+	if conflict, ok := err.(*api.VersionConflictError); ok {
+		ctx.Header("ETag", etag(conflict.Version))
+		ctx.JSON(http.StatusConflict, restful.ResponseRet{Msg: err.Error(), Data: gin.H{"version": conflict.Version}})
+		return
+	}
+	formatErr, ok := err.(*api.FormatError)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, restful.ResponseRet{Msg: err.Error()})
+		return
+	}
+	ret := restful.ResponseRet{Msg: err.Error()}
+	if parseErr, ok := formatErr.Err.(*api.ParseError); ok {
+		ret.Data = parseErr.Lines
+	}
+	ctx.JSON(http.StatusBadRequest, ret)
+}
+
+// forced reports whether the request is forced by the force=true query.
+func forced(ctx *gin.Context) bool {
+	force, _ := strconv.ParseBool(ctx.Query("force"))
+	return force
+}
+
+func appNames(apps []*api.App) []string {
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+// operator returns the user who performs the request.
+func operator(ctx *gin.Context) string {
+	if name := ctx.GetHeader(OperatorHeader); len(name) > 0 {
+		return name
+	}
+	return "anonymous"
+}
+
+// requestOperator returns the operator of the request with the request id.
+func requestOperator(ctx *gin.Context) *api.Operator {
+	return &api.Operator{Name: operator(ctx), RequestId: ctx.GetHeader(restful.RequestIdHeader)}
 }
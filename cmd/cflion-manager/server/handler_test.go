@@ -0,0 +1,653 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/memory"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeService implements the methods used by the tested handlers, the others panic.
+type fakeService struct {
+	api.Service
+	apps     map[string]*api.App
+	releases map[int64]bool
+	items    map[int64]*api.ConfigItem
+	gray     bool
+	calls    []string
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{
+		apps:     map[string]*api.App{"demo": {Id: 1, Name: "demo"}},
+		releases: map[int64]bool{10: true},
+		items:    map[int64]*api.ConfigItem{20: {Id: 20, FileId: 5, Name: "host", Value: "127.0.0.1"}, 21: {Id: 21, FileId: 5, Name: "port", Value: "3306"}},
+	}
+}
+
+func (service *fakeService) ExistsAppByName(name string) bool {
+	_, ok := service.apps[name]
+	return ok
+}
+
+func (service *fakeService) CreateApp(name string, operator *api.Operator) (int64, error) {
+	service.apps[name] = &api.App{Id: int64(len(service.apps) + 1), Name: name}
+	service.calls = append(service.calls, "CreateApp "+name)
+	return service.apps[name].Id, nil
+}
+
+func (service *fakeService) GetAppByName(name string) (*api.App, error) {
+	app, ok := service.apps[name]
+	if !ok {
+		return nil, fmt.Errorf("app [name=%s] doesn't exist", name)
+	}
+	return app, nil
+}
+
+func (service *fakeService) PublishApp(id int64, checksum string, operator *api.Operator, comment string) error {
+	if checksum == "stale" {
+		return &api.ChecksumConflictError{Checksum: "current"}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("PublishApp %d %s %s", id, operator.Name, comment))
+	return nil
+}
+
+func (service *fakeService) PublishAppGray(id int64, rule *api.GrayRule, checksum string, operator *api.Operator, comment string) error {
+	if checksum == "stale" {
+		return &api.ChecksumConflictError{Checksum: "current"}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("PublishAppGray %d %v %s %s", id, rule.IPs, operator.Name, comment))
+	return nil
+}
+
+func (service *fakeService) ExistsRelease(appId int64, id int64) bool {
+	return service.releases[id]
+}
+
+func (service *fakeService) RollbackRelease(appId int64, id int64, operator *api.Operator) error {
+	service.calls = append(service.calls, fmt.Sprintf("RollbackRelease %d %d %s", appId, id, operator.Name))
+	return nil
+}
+
+func (service *fakeService) ListReleases(appId int64) ([]map[string]interface{}, error) {
+	service.calls = append(service.calls, fmt.Sprintf("ListReleases %d", appId))
+	return []map[string]interface{}{{"id": int64(10), "publisher": "alice"}}, nil
+}
+
+func (service *fakeService) ExistsGrayRelease(appId int64) bool {
+	return service.gray
+}
+
+func (service *fakeService) PromoteGrayRelease(appId int64, checksum string, operator *api.Operator) error {
+	if checksum == "stale" {
+		return &api.ChecksumConflictError{Checksum: "current"}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("PromoteGrayRelease %d %s", appId, operator.Name))
+	return nil
+}
+
+func (service *fakeService) ExistsConfigFileById(id int64) bool {
+	return id == 5
+}
+
+func (service *fakeService) ExistsConfigItem(fileId int64, id int64) bool {
+	item, ok := service.items[id]
+	return ok && item.FileId == fileId
+}
+
+func (service *fakeService) ExistsConfigItemByName(fileId int64, name string) bool {
+	for _, item := range service.items {
+		if item.FileId == fileId && item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (service *fakeService) GetConfigItem(id int64) (*api.ConfigItem, error) {
+	return service.items[id], nil
+}
+
+// CreateConfigItem, UpdateConfigItem, DeleteConfigItem and RollbackConfigFile treat file 5 as at version 3.
+func (service *fakeService) CreateConfigItem(item *api.ConfigItem, version int64, operator *api.Operator) (int64, int64, error) {
+	if version > 0 && version != 3 {
+		return -1, 3, &api.VersionConflictError{Version: 3}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("CreateConfigItem %d %s=%s %d %s", item.FileId, item.Name, item.Value, version, operator.Name))
+	return 30, 4, nil
+}
+
+func (service *fakeService) UpdateConfigItem(item *api.ConfigItem, version int64, operator *api.Operator) (int64, error) {
+	if version > 0 && version != 3 {
+		return 3, &api.VersionConflictError{Version: 3}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("UpdateConfigItem %d %d %s=%s %d %s", item.FileId, item.Id, item.Name, item.Value, version, operator.Name))
+	return 4, nil
+}
+
+func (service *fakeService) DeleteConfigItem(fileId int64, id int64, version int64, operator *api.Operator) (int64, error) {
+	if version > 0 && version != 3 {
+		return 3, &api.VersionConflictError{Version: 3}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("DeleteConfigItem %d %d %d %s", fileId, id, version, operator.Name))
+	return 4, nil
+}
+
+// ExistsConfigFileRevision treats file 5 as at revision 3.
+func (service *fakeService) ExistsConfigFileRevision(fileId int64, revision int64) bool {
+	return fileId == 5 && revision >= 1 && revision <= 3
+}
+
+func (service *fakeService) ListConfigFileRevisions(fileId int64) ([]map[string]interface{}, error) {
+	service.calls = append(service.calls, fmt.Sprintf("ListConfigFileRevisions %d", fileId))
+	return []map[string]interface{}{{"revision": int64(3)}, {"revision": int64(2)}, {"revision": int64(1)}}, nil
+}
+
+func (service *fakeService) ViewConfigFileRevision(fileId int64, revision int64) (map[string]interface{}, error) {
+	service.calls = append(service.calls, fmt.Sprintf("ViewConfigFileRevision %d %d", fileId, revision))
+	return map[string]interface{}{"revision": revision}, nil
+}
+
+func (service *fakeService) RollbackConfigFile(fileId int64, revision int64, version int64, operator *api.Operator) (int64, error) {
+	if version > 0 && version != 3 {
+		return 3, &api.VersionConflictError{Version: 3}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("RollbackConfigFile %d %d %d %s", fileId, revision, version, operator.Name))
+	return 4, nil
+}
+
+// UpdateAppAssociation treats file 9 as a private file of another namespace.
+func (service *fakeService) UpdateAppAssociation(id int64, fileIds []int64, operator *api.Operator) error {
+	for _, fileId := range fileIds {
+		if fileId == 9 {
+			return &api.AssociationError{FileId: fileId, Reason: "is private in namespace [other]"}
+		}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("UpdateAppAssociation %d %v", id, fileIds))
+	return nil
+}
+
+// UpdateConfigFile treats the file as at version 3.
+func (service *fakeService) UpdateConfigFile(id int64, version int64, content string, operator *api.Operator) (map[string]interface{}, error) {
+	if version > 0 && version != 3 {
+		return nil, &api.VersionConflictError{Version: 3}
+	}
+	service.calls = append(service.calls, fmt.Sprintf("UpdateConfigFile %d %d %s", id, version, operator.Name))
+	return map[string]interface{}{"changed": true, "version": 4}, nil
+}
+
+func (service *fakeService) ListAuditEvents(filter *api.AuditFilter) ([]map[string]interface{}, error) {
+	service.calls = append(service.calls, fmt.Sprintf("ListAuditEvents %d %s %s %s %d", filter.AppId, filter.Actor,
+		filter.Since.Format(time.RFC3339), filter.Until.Format(time.RFC3339), filter.Limit))
+	return []map[string]interface{}{}, nil
+}
+
+func (service *fakeService) PollAppConfig(ctx context.Context, name string, revision int64, wait time.Duration, instanceId string, ip string) (*api.AppConfig, error) {
+	service.calls = append(service.calls, fmt.Sprintf("PollAppConfig %s %d %s %s %s", name, revision, wait, instanceId, ip))
+	if revision >= 7 {
+		return nil, nil
+	}
+	return &api.AppConfig{App: name, Revision: 7, Content: "[db:properties]\nhost=127.0.0.1\n"}, nil
+}
+
+func newRouter(service api.Service) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1")
+	v1.POST("/apps", CreateApp(service))
+	v1.PUT("/apps", PublishApp(service))
+	v1.PUT("/apps/:name", UpdateApp(service))
+	v1.GET("/apps/:name/releases", ListReleases(service))
+	v1.POST("/apps/:name/releases/:id/rollback", RollbackRelease(service))
+	v1.PUT("/apps/:name/gray", PromoteGrayRelease(service))
+	v1.PUT("/config-files/:file_id", UpdateConfigFile(service))
+	v1.GET("/config-files/:file_id/revisions", ListConfigFileRevisions(service))
+	v1.GET("/config-files/:file_id/revisions/:revision", ViewConfigFileRevision(service))
+	v1.POST("/config-files/:file_id/revisions/:revision/rollback", RollbackConfigFile(service))
+	v1.POST("/config-files/:file_id/items", CreateConfigItem(service))
+	v1.PUT("/config-files/:file_id/items/:item_id", UpdateConfigItem(service))
+	v1.DELETE("/config-files/:file_id/items/:item_id", DeleteConfigItem(service))
+	v1.GET("/apps/:name/config", PollAppConfig(service))
+	v1.GET("/watchers", QueryWatcher(service))
+	v1.GET("/audit", ListAuditEvents(service))
+	return router
+}
+
+func serve(router *gin.Engine, method, url string, body interface{}) *httptest.ResponseRecorder {
+	return serveIfMatch(router, method, url, "", body)
+}
+
+// serveIfMatch serves the request with the If-Match header unless it's empty.
+func serveIfMatch(router *gin.Engine, method, url string, ifMatch string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(OperatorHeader, "alice")
+	if len(ifMatch) > 0 {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateApp(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		body   interface{}
+		status int
+	}{
+		{map[string]string{"name": "other"}, http.StatusCreated},
+		{map[string]string{"name": "demo"}, http.StatusUnprocessableEntity},
+		{map[string]string{}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if w := serve(router, "POST", "/v1/apps", c.body); w.Code != c.status {
+			t.Errorf("POST /v1/apps %v expect %d, got %d: %s", c.body, c.status, w.Code, w.Body)
+		}
+	}
+	if len(service.calls) != 1 || service.calls[0] != "CreateApp other" {
+		t.Errorf("expect only app other created, got %v", service.calls)
+	}
+}
+
+func TestPublishApp(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		body   interface{}
+		status int
+	}{
+		{map[string]interface{}{"name": "none"}, http.StatusUnprocessableEntity},
+		{map[string]interface{}{"name": "demo", "gray": map[string]interface{}{}}, http.StatusBadRequest},
+		{map[string]interface{}{"name": "demo", "comment": "full"}, http.StatusOK},
+		{map[string]interface{}{"name": "demo", "comment": "stale", "checksum": "stale"}, http.StatusConflict},
+		{map[string]interface{}{"name": "demo", "comment": "stale", "checksum": "stale", "gray": map[string]interface{}{"ips": []string{"10.0.1.*"}}}, http.StatusConflict},
+		{map[string]interface{}{"name": "demo", "comment": "canary", "gray": map[string]interface{}{"ips": []string{"10.0.1.*"}}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serve(router, "PUT", "/v1/apps", c.body); w.Code != c.status {
+			t.Errorf("PUT /v1/apps %v expect %d, got %d: %s", c.body, c.status, w.Code, w.Body)
+		}
+	}
+	expected := []string{"PublishApp 1 alice full", "PublishAppGray 1 [10.0.1.*] alice canary"}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestUpdateApp(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"/v1/apps/missing", map[string][]int64{"config_files": {5}}, http.StatusUnprocessableEntity},
+		{"/v1/apps/demo", map[string][]int64{"config_files": {5, 9}}, http.StatusUnprocessableEntity},
+		{"/v1/apps/demo", map[string][]int64{"config_files": {5}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serve(router, "PUT", c.url, c.body); w.Code != c.status {
+			t.Errorf("PUT %s %v expect %d, got %d: %s", c.url, c.body, c.status, w.Code, w.Body)
+		}
+	}
+	if len(service.calls) != 1 || service.calls[0] != "UpdateAppAssociation 1 [5]" {
+		t.Errorf("expect only file 5 associated, got %v", service.calls)
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	if w := serve(router, "GET", "/v1/apps/none/releases", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expect %d for unknown app, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	w := serve(router, "GET", "/v1/apps/demo/releases", nil)
+	var ret struct {
+		Data []struct {
+			Id int64 `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || w.Code != http.StatusOK || len(ret.Data) != 1 || ret.Data[0].Id != 10 {
+		t.Errorf("unexpected releases %d %s: %v", w.Code, w.Body, err)
+	}
+	if len(service.calls) != 1 || service.calls[0] != "ListReleases 1" {
+		t.Errorf("expect releases of app 1 listed, got %v", service.calls)
+	}
+}
+
+func TestRollbackRelease(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{"/v1/apps/none/releases/10/rollback", http.StatusUnprocessableEntity},
+		{"/v1/apps/demo/releases/x/rollback", http.StatusBadRequest},
+		{"/v1/apps/demo/releases/11/rollback", http.StatusUnprocessableEntity},
+		{"/v1/apps/demo/releases/10/rollback", http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serve(router, "POST", c.url, nil); w.Code != c.status {
+			t.Errorf("POST %s expect %d, got %d: %s", c.url, c.status, w.Code, w.Body)
+		}
+	}
+	if len(service.calls) != 1 || service.calls[0] != "RollbackRelease 1 10 alice" {
+		t.Errorf("expect only release 10 rolled back, got %v", service.calls)
+	}
+}
+
+func TestPromoteGrayRelease(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	if w := serve(router, "PUT", "/v1/apps/demo/gray", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expect %d without gray release, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	service.gray = true
+	if w := serve(router, "PUT", "/v1/apps/demo/gray", nil); w.Code != http.StatusOK {
+		t.Errorf("expect %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if w := serve(router, "PUT", "/v1/apps/demo/gray", map[string]string{"checksum": "stale"}); w.Code != http.StatusConflict {
+		t.Errorf("expect %d with a stale checksum, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+	if len(service.calls) != 1 || service.calls[0] != "PromoteGrayRelease 1 alice" {
+		t.Errorf("expect gray release promoted once, got %v", service.calls)
+	}
+}
+
+func TestCreateConfigItem(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"/v1/config-files/6/items", map[string]string{"name": "user", "value": "root"}, http.StatusUnprocessableEntity},
+		{"/v1/config-files/5/items", map[string]string{"name": "host", "value": "10.0.0.1"}, http.StatusUnprocessableEntity},
+		{"/v1/config-files/5/items", map[string]string{"value": "root"}, http.StatusBadRequest},
+		{"/v1/config-files/5/items", map[string]string{"name": " user", "value": "root"}, http.StatusBadRequest},
+		{"/v1/config-files/5/items", map[string]string{"name": "user", "value": "root"}, http.StatusCreated},
+	}
+	for _, c := range cases {
+		if w := serveIfMatch(router, "POST", c.url, "*", c.body); w.Code != c.status {
+			t.Errorf("POST %s %v expect %d, got %d: %s", c.url, c.body, c.status, w.Code, w.Body)
+		}
+	}
+	if len(service.calls) != 1 || service.calls[0] != "CreateConfigItem 5 user=root 0 alice" {
+		t.Errorf("expect only item user created, got %v", service.calls)
+	}
+}
+
+func TestUpdateConfigItem(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"/v1/config-files/5/items/22", map[string]string{"name": "host"}, http.StatusUnprocessableEntity},
+		{"/v1/config-files/6/items/20", map[string]string{"name": "host"}, http.StatusUnprocessableEntity},
+		{"/v1/config-files/5/items/20", map[string]string{"name": "port", "value": "10.0.0.1"}, http.StatusUnprocessableEntity},
+		{"/v1/config-files/5/items/20", map[string]string{"name": "host", "value": "10.0.0.1"}, http.StatusOK},
+		{"/v1/config-files/5/items/20", map[string]string{"name": "addr", "value": "10.0.0.2"}, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serveIfMatch(router, "PUT", c.url, "*", c.body); w.Code != c.status {
+			t.Errorf("PUT %s %v expect %d, got %d: %s", c.url, c.body, c.status, w.Code, w.Body)
+		}
+	}
+	expected := []string{"UpdateConfigItem 5 20 host=10.0.0.1 0 alice", "UpdateConfigItem 5 20 addr=10.0.0.2 0 alice"}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestConfigItem_IfMatch(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	routes := []struct {
+		method string
+		url    string
+		body   interface{}
+	}{
+		{"POST", "/v1/config-files/5/items", map[string]string{"name": "user", "value": "root"}},
+		{"PUT", "/v1/config-files/5/items/20", map[string]string{"name": "host", "value": "10.0.0.1"}},
+		{"DELETE", "/v1/config-files/5/items/20", nil},
+		{"POST", "/v1/config-files/5/revisions/1/rollback", nil},
+	}
+	cases := []struct {
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"", http.StatusPreconditionRequired, ""},
+		{`"2"`, http.StatusConflict, `"3"`},
+		{`"3"`, http.StatusOK, `"4"`},
+	}
+	for _, r := range routes {
+		for _, c := range cases {
+			// an item is created with 201
+			if c.status == http.StatusOK && r.body != nil && r.method == "POST" {
+				c.status = http.StatusCreated
+			}
+			w := serveIfMatch(router, r.method, r.url, c.ifMatch, r.body)
+			if w.Code != c.status || w.Header().Get("ETag") != c.etag {
+				t.Errorf("%s %s If-Match [%s] expect %d with ETag [%s], got %d with [%s]: %s", r.method, r.url, c.ifMatch, c.status, c.etag, w.Code, w.Header().Get("ETag"), w.Body)
+			}
+		}
+	}
+	expected := []string{"CreateConfigItem 5 user=root 3 alice", "UpdateConfigItem 5 20 host=10.0.0.1 3 alice",
+		"DeleteConfigItem 5 20 3 alice", "RollbackConfigFile 5 1 3 alice"}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestUpdateConfigFile(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"", http.StatusPreconditionRequired, ""},
+		{"abc", http.StatusBadRequest, ""},
+		{`"2"`, http.StatusConflict, `"3"`},
+		{`"3"`, http.StatusOK, `"4"`},
+		{"*", http.StatusOK, `"4"`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("PUT", "/v1/config-files/5", bytes.NewBufferString(`{"config":"host=10.0.0.1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(OperatorHeader, "alice")
+		if len(c.ifMatch) > 0 {
+			req.Header.Set("If-Match", c.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != c.status || w.Header().Get("ETag") != c.etag {
+			t.Errorf("If-Match [%s] expect %d with ETag [%s], got %d with [%s]: %s", c.ifMatch, c.status, c.etag, w.Code, w.Header().Get("ETag"), w.Body)
+		}
+	}
+	expected := []string{"UpdateConfigFile 5 3 alice", "UpdateConfigFile 5 0 alice"}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestConfigFileRevisions(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/v1/config-files/6/revisions", http.StatusUnprocessableEntity},
+		{"GET", "/v1/config-files/x/revisions", http.StatusBadRequest},
+		{"GET", "/v1/config-files/5/revisions", http.StatusOK},
+		{"GET", "/v1/config-files/5/revisions/x", http.StatusBadRequest},
+		{"GET", "/v1/config-files/5/revisions/4", http.StatusUnprocessableEntity},
+		{"GET", "/v1/config-files/6/revisions/1", http.StatusUnprocessableEntity},
+		{"GET", "/v1/config-files/5/revisions/2", http.StatusOK},
+		{"POST", "/v1/config-files/5/revisions/4/rollback", http.StatusUnprocessableEntity},
+		{"POST", "/v1/config-files/5/revisions/1/rollback", http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serveIfMatch(router, c.method, c.url, "*", nil); w.Code != c.status {
+			t.Errorf("%s %s expect %d, got %d: %s", c.method, c.url, c.status, w.Code, w.Body)
+		}
+	}
+	expected := []string{"ListConfigFileRevisions 5", "ViewConfigFileRevision 5 2", "RollbackConfigFile 5 1 0 alice"}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestQueryWatcher(t *testing.T) {
+	router := newRouter(newFakeService())
+	if w := serve(router, "GET", "/v1/watchers", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expect %d without app, got %d", http.StatusBadRequest, w.Code)
+	}
+	w := serve(router, "GET", "/v1/watchers?app=demo", nil)
+	var ret struct {
+		Data struct {
+			Key     string `json:"key"`
+			GrayKey string `json:"gray_key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret.Data.Key != "/cflion/demo" || ret.Data.GrayKey != "/cflion-gray/demo" {
+		t.Errorf("unexpected watcher %+v", ret.Data)
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{"/v1/audit?app=none", http.StatusUnprocessableEntity},
+		{"/v1/audit?since=yesterday", http.StatusBadRequest},
+		{"/v1/audit?limit=0", http.StatusBadRequest},
+		{"/v1/audit?limit=5000", http.StatusBadRequest},
+		{"/v1/audit", http.StatusOK},
+		{"/v1/audit?app=demo&actor=alice&since=2018-06-01T00:00:00Z&until=2018-06-02T00:00:00Z&limit=10", http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serve(router, "GET", c.url, nil); w.Code != c.status {
+			t.Errorf("GET %s expect %d, got %d: %s", c.url, c.status, w.Code, w.Body)
+		}
+	}
+	expected := []string{
+		"ListAuditEvents 0  0001-01-01T00:00:00Z 0001-01-01T00:00:00Z 100",
+		"ListAuditEvents 1 alice 2018-06-01T00:00:00Z 2018-06-02T00:00:00Z 10",
+	}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+}
+
+func TestPollAppConfig(t *testing.T) {
+	viper.Set("poll.maxWait", 60)
+	service := newFakeService()
+	router := newRouter(service)
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{"/v1/apps/none/config", http.StatusUnprocessableEntity},
+		{"/v1/apps/demo/config?revision=abc", http.StatusBadRequest},
+		{"/v1/apps/demo/config?wait=forever", http.StatusBadRequest},
+		{"/v1/apps/demo/config?wait=-1s", http.StatusBadRequest},
+		{"/v1/apps/demo/config", http.StatusOK},
+		{"/v1/apps/demo/config?revision=7&wait=30s&instance=i-1&ip=10.0.0.1", http.StatusNotModified},
+		{"/v1/apps/demo/config?revision=3&wait=1h", http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serve(router, "GET", c.url, nil); w.Code != c.status {
+			t.Errorf("GET %s expect %d, got %d: %s", c.url, c.status, w.Code, w.Body)
+		}
+	}
+	// the ip defaults to the client ip, and the wait is capped by poll.maxWait
+	expected := []string{
+		"PollAppConfig demo 0 0s  192.0.2.1",
+		"PollAppConfig demo 7 30s i-1 10.0.0.1",
+		"PollAppConfig demo 3 1m0s  192.0.2.1",
+	}
+	if fmt.Sprint(service.calls) != fmt.Sprint(expected) {
+		t.Errorf("expect %v, got %v", expected, service.calls)
+	}
+	w := serve(router, "GET", "/v1/apps/demo/config", nil)
+	var ret struct {
+		Data struct {
+			Revision int64  `json:"revision"`
+			Content  string `json:"content"`
+			Gray     bool   `json:"gray"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || ret.Data.Revision != 7 || ret.Data.Content != "[db:properties]\nhost=127.0.0.1\n" {
+		t.Errorf("unexpected config %s: %v", w.Body, err)
+	}
+}
+
+func TestListWebhooks(t *testing.T) {
+	service := &ServiceImpl{Repo: memory.NewRepository()}
+	if _, err := service.CreateWebhook(&api.Webhook{Url: "http://127.0.0.1/hook", Secret: "s3cr3t", Events: []string{api.EventAppPublished}}, &api.Operator{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router.Group("/v1"), service)
+	w := serve(router, "GET", "/v1/webhooks", nil)
+	var ret struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || w.Code != http.StatusOK || len(ret.Data) != 1 || ret.Data[0]["url"] != "http://127.0.0.1/hook" {
+		t.Fatalf("unexpected webhooks %d: %s", w.Code, w.Body)
+	}
+	// the secret isn't responded in any field
+	if strings.Contains(w.Body.String(), "s3cr3t") {
+		t.Errorf("expect the secret absent, got %s", w.Body)
+	}
+	if b, _ := json.Marshal(&api.Webhook{Secret: "s3cr3t"}); strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("expect the secret not encoded, got %s", b)
+	}
+}
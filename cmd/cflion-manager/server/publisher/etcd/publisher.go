@@ -0,0 +1,145 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package etcd publishes the config of apps to etcd.
+package etcd
+
+import (
+	"context"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/coreos/etcd/clientv3"
+	"time"
+)
+
+type PublisherImpl struct {
+	Client         *clientv3.Client
+	RequestTimeout time.Duration
+}
+
+// NewPublisher connects to the etcd endpoints.
+func NewPublisher(endpoints []string, dialTimeout time.Duration, requestTimeout time.Duration) (*PublisherImpl, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		log.Errorf("Connect to etcd [%s] error: %s", endpoints, err)
+		return nil, err
+	}
+	return &PublisherImpl{Client: cli, RequestTimeout: requestTimeout}, nil
+}
+
+func (publisher *PublisherImpl) Put(key string, value string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.RequestTimeout)
+	resp, err := publisher.Client.Put(ctx, key, value)
+	cancel()
+	if err != nil {
+		log.Errorf("Put [key=%s] [value=%s] into etcd error: %s", key, value, err)
+		return -1, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (publisher *PublisherImpl) Get(key string) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.RequestTimeout)
+	resp, err := publisher.Client.Get(ctx, key)
+	cancel()
+	if err != nil {
+		log.Errorf("Get [key=%s] from etcd error: %s", key, err)
+		return "", -1, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", resp.Header.Revision, nil
+	}
+	return string(resp.Kvs[0].Value), resp.Header.Revision, nil
+}
+
+func (publisher *PublisherImpl) Delete(key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.RequestTimeout)
+	resp, err := publisher.Client.Delete(ctx, key)
+	cancel()
+	if err != nil {
+		log.Errorf("Delete [key=%s] from etcd error: %s", key, err)
+		return -1, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (publisher *PublisherImpl) Txn(ops []*server.PublishOp) (int64, error) {
+	etcdOps := make([]clientv3.Op, 0, len(ops))
+	for _, op := range ops {
+		if op.Delete {
+			etcdOps = append(etcdOps, clientv3.OpDelete(op.Key))
+		} else {
+			etcdOps = append(etcdOps, clientv3.OpPut(op.Key, op.Value))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.RequestTimeout)
+	resp, err := publisher.Client.Txn(ctx).Then(etcdOps...).Commit()
+	cancel()
+	if err != nil {
+		log.Errorf("Txn of %d ops into etcd error: %s", len(ops), err)
+		return -1, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (publisher *PublisherImpl) Watch(ctx context.Context, key string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, key, revision)
+}
+
+func (publisher *PublisherImpl) WatchPrefix(ctx context.Context, prefix string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, prefix, revision, clientv3.WithPrefix())
+}
+
+func (publisher *PublisherImpl) watch(ctx context.Context, key string, revision int64, opts ...clientv3.OpOption) <-chan *server.PublishEvent {
+	ch := make(chan *server.PublishEvent)
+	go func() {
+		defer close(ch)
+		opts = append(opts, clientv3.WithRev(revision+1))
+		wch := publisher.Client.Watch(clientv3.WithRequireLeader(ctx), key, opts...)
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				log.Warnf("Watch [key=%s] error: %s", key, err)
+				send(ctx, ch, &server.PublishEvent{Key: key, Err: err})
+				return
+			}
+			for _, ev := range resp.Events {
+				event := &server.PublishEvent{Key: string(ev.Kv.Key), Value: string(ev.Kv.Value), Revision: ev.Kv.ModRevision}
+				if ev.Type == clientv3.EventTypeDelete {
+					event.Value, event.Deleted = "", true
+				}
+				if !send(ctx, ch, event) {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+func (publisher *PublisherImpl) Close() error {
+	return publisher.Client.Close()
+}
+
+// send sends the event unless the context is done.
+func send(ctx context.Context, ch chan<- *server.PublishEvent, event *server.PublishEvent) bool {
+	select {
+	case ch <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,292 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package file publishes the config of apps to a local directory, the value of a key is saved
+// in the file at the key path under the directory, e.g. <dir>/cflion/demo for /cflion/demo.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"github.com/cflion/cflion/pkg/common"
+	"github.com/cflion/cflion/pkg/log"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexFile records the revisions of the keys under the directory.
+const indexFile = ".revisions"
+
+type PublisherImpl struct {
+	Dir string
+
+	mu    sync.Mutex
+	index *index
+	// changed is closed and replaced after every change to wake up the watchers
+	changed chan struct{}
+}
+
+type index struct {
+	Revision int64            `json:"revision"`
+	Keys     map[string]int64 `json:"keys"`
+}
+
+// NewPublisher loads the revisions saved in the directory, the directory is created if it doesn't exist.
+func NewPublisher(dir string) (*PublisherImpl, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Create publish dir [%s] error: %s", dir, err)
+		return nil, err
+	}
+	idx := &index{Keys: make(map[string]int64)}
+	b, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Read [%s] error: %s", filepath.Join(dir, indexFile), err)
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(b, idx); err != nil {
+			log.Errorf("Unmarshal [%s] error: %s", filepath.Join(dir, indexFile), err)
+			return nil, err
+		}
+		if idx.Keys == nil {
+			idx.Keys = make(map[string]int64)
+		}
+	}
+	return &PublisherImpl{Dir: dir, index: idx, changed: make(chan struct{})}, nil
+}
+
+func (publisher *PublisherImpl) Put(key string, value string) (int64, error) {
+	filename, err := publisher.path(key)
+	if err != nil {
+		return -1, err
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if err = publisher.write(key, filename, value); err != nil {
+		return -1, err
+	}
+	return publisher.commit(key)
+}
+
+func (publisher *PublisherImpl) Get(key string) (string, int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	value, _, err := publisher.read(key)
+	if err != nil {
+		return "", -1, err
+	}
+	return value, publisher.index.Revision, nil
+}
+
+func (publisher *PublisherImpl) Delete(key string) (int64, error) {
+	filename, err := publisher.path(key)
+	if err != nil {
+		return -1, err
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	removed, err := publisher.remove(key, filename)
+	if err != nil {
+		return -1, err
+	}
+	if !removed {
+		return publisher.index.Revision, nil
+	}
+	return publisher.commit(key)
+}
+
+// Txn applies the deletes before the puts, so a key can be replaced by the keys under it and vice versa.
+// The ops are atomic to the watchers and the revisions, but not to a crash in the middle.
+func (publisher *PublisherImpl) Txn(ops []*server.PublishOp) (int64, error) {
+	filenames := make([]string, 0, len(ops))
+	for _, op := range ops {
+		filename, err := publisher.path(op.Key)
+		if err != nil {
+			return -1, err
+		}
+		filenames = append(filenames, filename)
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	keys := make([]string, 0, len(ops))
+	for i, op := range ops {
+		if !op.Delete {
+			continue
+		}
+		removed, err := publisher.remove(op.Key, filenames[i])
+		if err != nil {
+			return -1, err
+		}
+		if removed {
+			keys = append(keys, op.Key)
+		}
+	}
+	for i, op := range ops {
+		if op.Delete {
+			continue
+		}
+		if err := publisher.write(op.Key, filenames[i], op.Value); err != nil {
+			return -1, err
+		}
+		keys = append(keys, op.Key)
+	}
+	if len(keys) == 0 {
+		return publisher.index.Revision, nil
+	}
+	return publisher.commit(keys...)
+}
+
+// Watch sends the latest value of the key whenever it changes after the revision,
+// the intermediate changes between two events are not sent.
+func (publisher *PublisherImpl) Watch(ctx context.Context, key string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, func(k string) bool { return k == key }, revision)
+}
+
+// WatchPrefix sends the latest values of the keys with the prefix in the same way as Watch.
+func (publisher *PublisherImpl) WatchPrefix(ctx context.Context, prefix string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, func(k string) bool { return strings.HasPrefix(k, prefix) }, revision)
+}
+
+func (publisher *PublisherImpl) watch(ctx context.Context, match func(key string) bool, revision int64) <-chan *server.PublishEvent {
+	ch := make(chan *server.PublishEvent)
+	go func() {
+		defer close(ch)
+		for {
+			publisher.mu.Lock()
+			events := make([]*server.PublishEvent, 0, 1)
+			for key, modRevision := range publisher.index.Keys {
+				if modRevision > revision && match(key) {
+					value, exists, err := publisher.read(key)
+					events = append(events, &server.PublishEvent{Key: key, Value: value, Revision: modRevision, Deleted: !exists, Err: err})
+				}
+			}
+			changed := publisher.changed
+			publisher.mu.Unlock()
+			sort.Slice(events, func(i, j int) bool {
+				return events[i].Revision < events[j].Revision
+			})
+			for _, event := range events {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				}
+				if event.Err != nil {
+					return
+				}
+				revision = event.Revision
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (publisher *PublisherImpl) Close() error {
+	return nil
+}
+
+// write saves the value of the key, it must be called with the lock held.
+func (publisher *PublisherImpl) write(key string, filename string, value string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Errorf("Create dir of [key=%s] error: %s", key, err)
+		return err
+	}
+	if err := common.WriteFileAtomic(filename, []byte(value), 0644); err != nil {
+		log.Errorf("Write [key=%s] to [%s] error: %s", key, filename, err)
+		return err
+	}
+	return nil
+}
+
+// remove removes the file of the key and the empty dirs above it, it returns false if the key doesn't exist.
+// It must be called with the lock held.
+func (publisher *PublisherImpl) remove(key string, filename string) (bool, error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return false, nil
+	}
+	if err == nil {
+		err = os.Remove(filename)
+	}
+	if err != nil {
+		log.Errorf("Remove [key=%s] at [%s] error: %s", key, filename, err)
+		return false, err
+	}
+	for dir := filepath.Dir(filename); dir != filepath.Clean(publisher.Dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return true, nil
+}
+
+// commit increases the revision of the keys and saves the index, it must be called with the lock held.
+func (publisher *PublisherImpl) commit(keys ...string) (int64, error) {
+	publisher.index.Revision++
+	for _, key := range keys {
+		publisher.index.Keys[key] = publisher.index.Revision
+	}
+	b, err := json.Marshal(publisher.index)
+	if err != nil {
+		return -1, err
+	}
+	if err = common.WriteFileAtomic(filepath.Join(publisher.Dir, indexFile), b, 0644); err != nil {
+		log.Errorf("Write [%s] error: %s", filepath.Join(publisher.Dir, indexFile), err)
+		return -1, err
+	}
+	close(publisher.changed)
+	publisher.changed = make(chan struct{})
+	return publisher.index.Revision, nil
+}
+
+// read returns the value of the key and whether the key exists, it must be called with the lock held.
+func (publisher *PublisherImpl) read(key string) (string, bool, error) {
+	filename, err := publisher.path(key)
+	if err != nil {
+		return "", false, err
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if info, statErr := os.Stat(filename); err != nil && statErr == nil && info.IsDir() {
+		// the dir of the keys under the key
+		return "", false, nil
+	}
+	if err != nil {
+		log.Errorf("Read [key=%s] from [%s] error: %s", key, filename, err)
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// path returns the file of the key, the key must be a clean absolute path.
+func (publisher *PublisherImpl) path(key string) (string, error) {
+	if !strings.HasPrefix(key, "/") || path.Clean(key) != key || key == "/" || path.Base(key) == indexFile {
+		return "", fmt.Errorf("invalid key [%s]", key)
+	}
+	return filepath.Join(publisher.Dir, filepath.FromSlash(key)), nil
+}
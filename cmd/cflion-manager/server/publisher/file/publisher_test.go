@@ -0,0 +1,121 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package file
+
+import (
+	"context"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublisherImpl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publisher, err := NewPublisher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = publisher.Put("/cflion/../etc/passwd", "x"); err == nil {
+		t.Error("expect error for an unclean key")
+	}
+	rev, err := publisher.Put("/cflion/demo", "[db]\nhost=127.0.0.1")
+	if err != nil || rev != 1 {
+		t.Fatalf("expect revision 1, got %d: %v", rev, err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "cflion", "demo"))
+	if string(b) != "[db]\nhost=127.0.0.1" {
+		t.Errorf("unexpected file content %q", b)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := publisher.Watch(ctx, "/cflion/demo", 0)
+	select {
+	case event := <-ch:
+		if event.Value != "[db]\nhost=127.0.0.1" || event.Revision != 1 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the change after revision 0")
+	}
+	publisher.Delete("/cflion/demo")
+	select {
+	case event := <-ch:
+		if !event.Deleted || event.Revision != 2 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect an event after delete")
+	}
+	// the revisions are kept after restart
+	publisher, err = NewPublisher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev, _ = publisher.Put("/cflion/demo", "x"); rev != 3 {
+		t.Errorf("expect revision 3, got %d", rev)
+	}
+}
+
+func TestPublisherImpl_Txn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publisher, err := NewPublisher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = publisher.Put("/cflion/demo", "[db]\nhost=127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	// the key is replaced by the keys under it
+	rev, err := publisher.Txn([]*server.PublishOp{
+		{Key: "/cflion/demo", Delete: true},
+		{Key: "/cflion/demo/db", Value: "host=127.0.0.1"},
+		{Key: "/cflion-manifest/demo", Value: "{}"},
+	})
+	if err != nil || rev != 2 {
+		t.Fatalf("expect revision 2, got %d: %v", rev, err)
+	}
+	if value, _, err := publisher.Get("/cflion/demo/db"); err != nil || value != "host=127.0.0.1" {
+		t.Errorf("unexpected value %q: %v", value, err)
+	}
+	if value, _, err := publisher.Get("/cflion/demo"); err != nil || value != "" {
+		t.Errorf("expect the dir of keys not to be a value, got %q: %v", value, err)
+	}
+	// and back, the empty dir is removed
+	rev, err = publisher.Txn([]*server.PublishOp{
+		{Key: "/cflion/demo", Value: "[db]\nhost=127.0.0.2"},
+		{Key: "/cflion/demo/db", Delete: true},
+		{Key: "/cflion-manifest/demo", Delete: true},
+	})
+	if err != nil || rev != 3 {
+		t.Fatalf("expect revision 3, got %d: %v", rev, err)
+	}
+	if value, _, err := publisher.Get("/cflion/demo"); err != nil || value != "[db]\nhost=127.0.0.2" {
+		t.Errorf("unexpected value %q: %v", value, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "cflion-manifest")); !os.IsNotExist(err) {
+		t.Errorf("expect the empty dir to be removed: %v", err)
+	}
+}
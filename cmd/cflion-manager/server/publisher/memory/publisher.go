@@ -0,0 +1,145 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package memory keeps the published config of apps in memory, which is lost after the manager exits.
+package memory
+
+import (
+	"context"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type PublisherImpl struct {
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]*kv
+	// changed is closed and replaced after every change to wake up the watchers
+	changed chan struct{}
+}
+
+// kv is the latest state of a key.
+type kv struct {
+	value    string
+	revision int64
+	deleted  bool
+}
+
+func NewPublisher() *PublisherImpl {
+	return &PublisherImpl{kvs: make(map[string]*kv), changed: make(chan struct{})}
+}
+
+func (publisher *PublisherImpl) Put(key string, value string) (int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	publisher.revision++
+	publisher.kvs[key] = &kv{value: value, revision: publisher.revision}
+	publisher.notify()
+	return publisher.revision, nil
+}
+
+func (publisher *PublisherImpl) Get(key string) (string, int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if kv, ok := publisher.kvs[key]; ok {
+		return kv.value, publisher.revision, nil
+	}
+	return "", publisher.revision, nil
+}
+
+func (publisher *PublisherImpl) Delete(key string) (int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if kv, ok := publisher.kvs[key]; !ok || kv.deleted {
+		return publisher.revision, nil
+	}
+	publisher.revision++
+	publisher.kvs[key] = &kv{revision: publisher.revision, deleted: true}
+	publisher.notify()
+	return publisher.revision, nil
+}
+
+func (publisher *PublisherImpl) Txn(ops []*server.PublishOp) (int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	publisher.revision++
+	for _, op := range ops {
+		if op.Delete {
+			if old, ok := publisher.kvs[op.Key]; ok && !old.deleted {
+				publisher.kvs[op.Key] = &kv{revision: publisher.revision, deleted: true}
+			}
+		} else {
+			publisher.kvs[op.Key] = &kv{value: op.Value, revision: publisher.revision}
+		}
+	}
+	publisher.notify()
+	return publisher.revision, nil
+}
+
+// Watch sends the latest state of the key whenever it changes after the revision,
+// the intermediate changes between two events are not sent.
+func (publisher *PublisherImpl) Watch(ctx context.Context, key string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, func(k string) bool { return k == key }, revision)
+}
+
+// WatchPrefix sends the latest states of the keys with the prefix in the same way as Watch.
+func (publisher *PublisherImpl) WatchPrefix(ctx context.Context, prefix string, revision int64) <-chan *server.PublishEvent {
+	return publisher.watch(ctx, func(k string) bool { return strings.HasPrefix(k, prefix) }, revision)
+}
+
+func (publisher *PublisherImpl) watch(ctx context.Context, match func(key string) bool, revision int64) <-chan *server.PublishEvent {
+	ch := make(chan *server.PublishEvent)
+	go func() {
+		defer close(ch)
+		for {
+			publisher.mu.Lock()
+			events := make([]*server.PublishEvent, 0, 1)
+			for key, kv := range publisher.kvs {
+				if kv.revision > revision && match(key) {
+					events = append(events, &server.PublishEvent{Key: key, Value: kv.value, Revision: kv.revision, Deleted: kv.deleted})
+				}
+			}
+			changed := publisher.changed
+			publisher.mu.Unlock()
+			sort.Slice(events, func(i, j int) bool {
+				return events[i].Revision < events[j].Revision
+			})
+			for _, event := range events {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				}
+				revision = event.Revision
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (publisher *PublisherImpl) Close() error {
+	return nil
+}
+
+func (publisher *PublisherImpl) notify() {
+	close(publisher.changed)
+	publisher.changed = make(chan struct{})
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"testing"
+	"time"
+)
+
+func TestPublisherImpl_Watch(t *testing.T) {
+	publisher := NewPublisher()
+	rev, _ := publisher.Put("/cflion/demo", "[db]\nhost=127.0.0.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := publisher.Watch(ctx, "/cflion/demo", rev)
+	publisher.Put("/cflion/other", "ignored")
+	publisher.Put("/cflion/demo", "[db]\nhost=10.0.0.1")
+	select {
+	case event := <-ch:
+		if event.Value != "[db]\nhost=10.0.0.1" || event.Revision != 3 || event.Deleted {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect an event after put")
+	}
+	publisher.Delete("/cflion/demo")
+	select {
+	case event := <-ch:
+		if !event.Deleted || event.Revision != 4 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect an event after delete")
+	}
+	if value, rev, _ := publisher.Get("/cflion/demo"); value != "" || rev != 4 {
+		t.Errorf("expect empty value at revision 4, got %s at %d", value, rev)
+	}
+}
+
+func TestPublisherImpl_Txn(t *testing.T) {
+	publisher := NewPublisher()
+	publisher.Put("/cflion/demo", "[db]\nhost=127.0.0.1")
+	rev, err := publisher.Txn([]*server.PublishOp{
+		{Key: "/cflion/demo", Delete: true},
+		{Key: "/cflion/demo/db", Value: "host=127.0.0.1"},
+		{Key: "/cflion/missing", Delete: true},
+	})
+	if err != nil || rev != 2 {
+		t.Fatalf("expect revision 2, got %d: %v", rev, err)
+	}
+	if value, _, _ := publisher.Get("/cflion/demo"); value != "" {
+		t.Errorf("expect the key to be deleted, got %q", value)
+	}
+	if value, _, _ := publisher.Get("/cflion/demo/db"); value != "host=127.0.0.1" {
+		t.Errorf("unexpected value %q", value)
+	}
+}
+
+func TestPublisherImpl_WatchPrefix(t *testing.T) {
+	publisher := NewPublisher()
+	rev, _ := publisher.Put("/cflion/demo", "[db]\nhost=127.0.0.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := publisher.WatchPrefix(ctx, "/cflion", rev)
+	publisher.Put("/other/demo", "ignored")
+	publisher.Txn([]*server.PublishOp{
+		{Key: "/cflion/demo", Delete: true},
+		{Key: "/cflion-manifest/demo", Value: "{}"},
+	})
+	publisher.Put("/cflion-gray/demo", "{}")
+	keys := make(map[string]int64)
+	for len(keys) < 3 {
+		select {
+		case event := <-ch:
+			keys[event.Key] = event.Revision
+		case <-time.After(time.Second):
+			t.Fatalf("expect events of 3 keys, got %v", keys)
+		}
+	}
+	if keys["/cflion/demo"] != 3 || keys["/cflion-manifest/demo"] != 3 || keys["/cflion-gray/demo"] != 4 {
+		t.Errorf("unexpected revisions %v", keys)
+	}
+}
@@ -0,0 +1,737 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package memory implements the repository of cflion-manager in memory, which is lost after the manager exits.
+package memory
+
+import (
+	"fmt"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RepositoryImpl struct {
+	mu          sync.RWMutex
+	lastId      int64
+	apps        map[int64]*api.App
+	files       map[int64]*api.ConfigFile
+	items       map[int64]*api.ConfigItem
+	releases    map[int64]*api.Release
+	revisions   map[int64][]*api.ConfigFileRevision
+	association []*association
+	events      []*api.AuditEvent
+	webhooks    map[int64]*api.Webhook
+	deliveries  []*api.WebhookDelivery
+}
+
+type association struct {
+	appId  int64
+	fileId int64
+}
+
+func NewRepository() *RepositoryImpl {
+	return &RepositoryImpl{
+		apps:      make(map[int64]*api.App),
+		files:     make(map[int64]*api.ConfigFile),
+		items:     make(map[int64]*api.ConfigItem),
+		releases:  make(map[int64]*api.Release),
+		revisions: make(map[int64][]*api.ConfigFileRevision),
+		webhooks:  make(map[int64]*api.Webhook),
+	}
+}
+
+func (repo *RepositoryImpl) ExistsAppById(id int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	_, ok := repo.apps[id]
+	return ok
+}
+
+func (repo *RepositoryImpl) ExistsAppByName(name string) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.appByName(name) != nil
+}
+
+func (repo *RepositoryImpl) GetAppByName(name string) (*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	app := repo.appByName(name)
+	if app == nil {
+		return nil, fmt.Errorf("app [name=%s] doesn't exist", name)
+	}
+	return copyApp(app), nil
+}
+
+func (repo *RepositoryImpl) InsertApp(app *api.App) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.appByName(app.Name) != nil {
+		return -1, fmt.Errorf("app [name=%s] already exists", app.Name)
+	}
+	id := repo.nextId()
+	repo.apps[id] = &api.App{Id: id, Name: app.Name, Outdated: app.Outdated}
+	return id, nil
+}
+
+func (repo *RepositoryImpl) RetrieveAppBrief(id int64) (*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.retrieveApp(id, false)
+}
+
+func (repo *RepositoryImpl) RetrieveAppDetail(id int64) (*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.retrieveApp(id, true)
+}
+
+func (repo *RepositoryImpl) UpdateAppAssociation(appId int64, addFileIds []int64, delFileIds []int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	app, ok := repo.apps[appId]
+	if !ok {
+		return fmt.Errorf("app [id=%d] doesn't exist", appId)
+	}
+	// the existing associations are kept as they are, like the unique key of the association table
+	for _, fileId := range addFileIds {
+		if !repo.associated(appId, fileId) {
+			repo.association = append(repo.association, &association{appId: appId, fileId: fileId})
+		}
+	}
+	del := make(map[int64]struct{}, len(delFileIds))
+	for _, fileId := range delFileIds {
+		del[fileId] = struct{}{}
+	}
+	repo.deleteAssociation(func(ass *association) bool {
+		_, ok := del[ass.fileId]
+		return ok && ass.appId == appId
+	})
+	app.Outdated = 1
+	return nil
+}
+
+func (repo *RepositoryImpl) UpdateAppOutdated(id int64, outdated bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if app, ok := repo.apps[id]; ok {
+		app.Outdated = 0
+		if outdated {
+			app.Outdated = 1
+		}
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) DeleteApp(id int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, cf := range repo.files {
+		if cf.NamespaceId == id {
+			repo.deleteConfigFile(cf.Id)
+		}
+	}
+	repo.deleteAssociation(func(ass *association) bool { return ass.appId == id })
+	for releaseId, release := range repo.releases {
+		if release.AppId == id {
+			delete(repo.releases, releaseId)
+		}
+	}
+	for hookId, hook := range repo.webhooks {
+		if hook.AppId == id {
+			repo.deleteWebhook(hookId)
+		}
+	}
+	delete(repo.apps, id)
+	return nil
+}
+
+func (repo *RepositoryImpl) InsertRelease(release *api.Release) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	r := *release
+	r.Id = repo.nextId()
+	r.Ctime = time.Now()
+	repo.releases[r.Id] = &r
+	return r.Id, nil
+}
+
+func (repo *RepositoryImpl) ListReleases(appId int64) ([]*api.Release, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	releases := make([]*api.Release, 0, 8)
+	for _, release := range repo.releases {
+		if release.AppId == appId {
+			r := *release
+			r.Content = ""
+			releases = append(releases, &r)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Id > releases[j].Id
+	})
+	return releases, nil
+}
+
+func (repo *RepositoryImpl) ExistsRelease(appId int64, id int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	release, ok := repo.releases[id]
+	return ok && release.AppId == appId
+}
+
+func (repo *RepositoryImpl) RetrieveRelease(id int64) (*api.Release, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	release, ok := repo.releases[id]
+	if !ok {
+		return nil, fmt.Errorf("release [id=%d] doesn't exist", id)
+	}
+	r := *release
+	return &r, nil
+}
+
+func (repo *RepositoryImpl) RetrieveLatestRelease(appId int64) (*api.Release, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.latestRelease(appId, api.ReleaseNormal), nil
+}
+
+func (repo *RepositoryImpl) RetrieveActiveGrayRelease(appId int64) (*api.Release, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.latestRelease(appId, api.ReleaseGray), nil
+}
+
+func (repo *RepositoryImpl) UpdateReleaseStatus(id int64, status byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if release, ok := repo.releases[id]; ok {
+		release.Status = status
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) UpdateReleaseRevision(id int64, revision int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if release, ok := repo.releases[id]; ok {
+		release.Revision = revision
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) ListConfigFilesBrief() ([]*api.ConfigFile, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	cfs := make([]*api.ConfigFile, 0, len(repo.files))
+	for _, cf := range repo.files {
+		cfs = append(cfs, repo.briefFile(cf))
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		return cfs[i].Id < cfs[j].Id
+	})
+	return cfs, nil
+}
+
+func (repo *RepositoryImpl) ExistsConfigFileByNameAndNamespaceId(filename string, namespaceId int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, cf := range repo.files {
+		if cf.Name == filename && cf.NamespaceId == namespaceId {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RepositoryImpl) ExistsConfigFileById(id int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	_, ok := repo.files[id]
+	return ok
+}
+
+func (repo *RepositoryImpl) InsertConfigFileWithItems(cf *api.ConfigFile, author string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	fileId := repo.nextId()
+	repo.files[fileId] = &api.ConfigFile{Id: fileId, Name: cf.Name, NamespaceId: cf.NamespaceId, Format: cf.Format, Public: cf.Public}
+	repo.association = append(repo.association, &association{appId: cf.NamespaceId, fileId: fileId})
+	repo.insertItems(fileId, cf.Items)
+	repo.insertRevision(fileId, author)
+	return fileId, nil
+}
+
+func (repo *RepositoryImpl) RetrieveConfigFileDetail(id int64) (*api.ConfigFile, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.retrieveFile(id)
+}
+
+func (repo *RepositoryImpl) UpdateConfigFile(fileId int64, version int64, items []*api.ConfigItem, author string) ([]*api.ConfigItemDiff, int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.files[fileId]; !ok {
+		return nil, 0, fmt.Errorf("config file [id=%d] doesn't exist", fileId)
+	}
+	current := int64(len(repo.revisions[fileId]))
+	if version > 0 && version != current {
+		return nil, current, &api.VersionConflictError{Version: current}
+	}
+	diffs := api.DiffConfigItems(copyItems(repo.fileItems(fileId)), items)
+	if len(diffs) == 0 {
+		return diffs, current, nil
+	}
+	added := make([]*api.ConfigItem, 0, len(diffs))
+	for _, diff := range diffs {
+		switch diff.Type {
+		case api.DiffAdded:
+			added = append(added, diff.New)
+		case api.DiffModified:
+			ci := repo.items[diff.Old.Id]
+			ci.Value, ci.Comment, ci.Type = diff.New.Value, diff.New.Comment, diff.New.Type
+		case api.DiffRemoved:
+			delete(repo.items, diff.Old.Id)
+		}
+	}
+	repo.insertItems(fileId, added)
+	repo.markAppsOutdated(fileId)
+	repo.insertRevision(fileId, author)
+	return diffs, current + 1, nil
+}
+
+func (repo *RepositoryImpl) DeleteConfigFile(id int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.deleteConfigFile(id)
+	return nil
+}
+
+func (repo *RepositoryImpl) UpdateConfigFilePublic(id int64, public bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if cf, ok := repo.files[id]; ok {
+		cf.Public = public
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) ListAssociatedApps(fileId int64) ([]*api.App, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	apps := make([]*api.App, 0, 8)
+	for _, ass := range repo.association {
+		if app, ok := repo.apps[ass.appId]; ok && ass.fileId == fileId {
+			apps = append(apps, copyApp(app))
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Id < apps[j].Id
+	})
+	return apps, nil
+}
+
+func (repo *RepositoryImpl) ExistsConfigItem(fileId int64, id int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	ci, ok := repo.items[id]
+	return ok && ci.FileId == fileId
+}
+
+func (repo *RepositoryImpl) ExistsConfigItemByName(fileId int64, name string) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, ci := range repo.items {
+		if ci.FileId == fileId && ci.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RepositoryImpl) RetrieveConfigItem(id int64) (*api.ConfigItem, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	ci, ok := repo.items[id]
+	if !ok {
+		return nil, fmt.Errorf("config item [id=%d] doesn't exist", id)
+	}
+	c := *ci
+	return &c, nil
+}
+
+func (repo *RepositoryImpl) InsertConfigItem(item *api.ConfigItem, version int64, author string) (int64, int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.files[item.FileId]; !ok {
+		return -1, 0, fmt.Errorf("config file [id=%d] doesn't exist", item.FileId)
+	}
+	if err := repo.checkVersion(item.FileId, version); err != nil {
+		return -1, 0, err
+	}
+	id := repo.nextId()
+	repo.items[id] = &api.ConfigItem{Id: id, FileId: item.FileId, Name: item.Name, Value: item.Value, Comment: item.Comment, Type: item.Type}
+	repo.markAppsOutdated(item.FileId)
+	repo.insertRevision(item.FileId, author)
+	return id, int64(len(repo.revisions[item.FileId])), nil
+}
+
+func (repo *RepositoryImpl) UpdateConfigItem(item *api.ConfigItem, version int64, author string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	ci, ok := repo.items[item.Id]
+	if !ok || ci.FileId != item.FileId {
+		return 0, fmt.Errorf("config item [id=%d] [file_id=%d] doesn't exist", item.Id, item.FileId)
+	}
+	if err := repo.checkVersion(item.FileId, version); err != nil {
+		return 0, err
+	}
+	ci.Name, ci.Value, ci.Comment, ci.Type = item.Name, item.Value, item.Comment, item.Type
+	repo.markAppsOutdated(item.FileId)
+	repo.insertRevision(item.FileId, author)
+	return int64(len(repo.revisions[item.FileId])), nil
+}
+
+func (repo *RepositoryImpl) DeleteConfigItem(fileId int64, id int64, version int64, author string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	ci, ok := repo.items[id]
+	if !ok || ci.FileId != fileId {
+		return 0, fmt.Errorf("config item [id=%d] [file_id=%d] doesn't exist", id, fileId)
+	}
+	if err := repo.checkVersion(fileId, version); err != nil {
+		return 0, err
+	}
+	delete(repo.items, id)
+	repo.markAppsOutdated(fileId)
+	repo.insertRevision(fileId, author)
+	return int64(len(repo.revisions[fileId])), nil
+}
+
+// checkVersion fails with *api.VersionConflictError if the version isn't the current one of the file,
+// version 0 matches any.
+func (repo *RepositoryImpl) checkVersion(fileId int64, version int64) error {
+	if current := int64(len(repo.revisions[fileId])); version > 0 && version != current {
+		return &api.VersionConflictError{Version: current}
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) ListConfigFileRevisions(fileId int64) ([]*api.ConfigFileRevision, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	revisions := repo.revisions[fileId]
+	result := make([]*api.ConfigFileRevision, 0, len(revisions))
+	for i := len(revisions) - 1; i >= 0; i-- {
+		rev := *revisions[i]
+		rev.Items = nil
+		result = append(result, &rev)
+	}
+	return result, nil
+}
+
+func (repo *RepositoryImpl) ExistsConfigFileRevision(fileId int64, revision int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return revision > 0 && revision <= int64(len(repo.revisions[fileId]))
+}
+
+func (repo *RepositoryImpl) RetrieveConfigFileRevision(fileId int64, revision int64) (*api.ConfigFileRevision, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	revisions := repo.revisions[fileId]
+	if revision <= 0 || revision > int64(len(revisions)) {
+		return nil, fmt.Errorf("config file [id=%d] [revision=%d] doesn't exist", fileId, revision)
+	}
+	rev := *revisions[revision-1]
+	rev.Items = copyItems(rev.Items)
+	return &rev, nil
+}
+
+// nextId returns the next id shared by all the entities, it must be called with the lock held.
+func (repo *RepositoryImpl) nextId() int64 {
+	repo.lastId++
+	return repo.lastId
+}
+
+func (repo *RepositoryImpl) associated(appId int64, fileId int64) bool {
+	for _, ass := range repo.association {
+		if ass.appId == appId && ass.fileId == fileId {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RepositoryImpl) appByName(name string) *api.App {
+	for _, app := range repo.apps {
+		if app.Name == name {
+			return app
+		}
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) retrieveApp(id int64, detail bool) (*api.App, error) {
+	app, ok := repo.apps[id]
+	if !ok {
+		return nil, fmt.Errorf("app [id=%d] doesn't exist", id)
+	}
+	result := copyApp(app)
+	result.Files = make([]*api.ConfigFile, 0, 8)
+	for _, ass := range repo.association {
+		if ass.appId != id {
+			continue
+		}
+		cf, ok := repo.files[ass.fileId]
+		if !ok {
+			continue
+		}
+		if !detail {
+			result.Files = append(result.Files, repo.briefFile(cf))
+			continue
+		}
+		cfDetail, err := repo.retrieveFile(cf.Id)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, cfDetail)
+	}
+	return result, nil
+}
+
+func (repo *RepositoryImpl) retrieveFile(id int64) (*api.ConfigFile, error) {
+	cf, ok := repo.files[id]
+	if !ok {
+		return nil, fmt.Errorf("config file [id=%d] doesn't exist", id)
+	}
+	result := repo.briefFile(cf)
+	result.Items = copyItems(repo.fileItems(id))
+	// the version is the latest revision
+	result.Version = int64(len(repo.revisions[id]))
+	return result, nil
+}
+
+// briefFile copies the file with its namespace app.
+func (repo *RepositoryImpl) briefFile(cf *api.ConfigFile) *api.ConfigFile {
+	result := &api.ConfigFile{Id: cf.Id, Name: cf.Name, NamespaceId: cf.NamespaceId, Format: cf.Format, Public: cf.Public, App: &api.App{}}
+	if app, ok := repo.apps[cf.NamespaceId]; ok {
+		result.App = copyApp(app)
+	}
+	return result
+}
+
+// fileItems returns the items of the file in insertion order.
+func (repo *RepositoryImpl) fileItems(fileId int64) []*api.ConfigItem {
+	items := make([]*api.ConfigItem, 0, 8)
+	for _, ci := range repo.items {
+		if ci.FileId == fileId {
+			items = append(items, ci)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+	return items
+}
+
+func (repo *RepositoryImpl) insertItems(fileId int64, items []*api.ConfigItem) {
+	for _, ci := range items {
+		id := repo.nextId()
+		repo.items[id] = &api.ConfigItem{Id: id, FileId: fileId, Name: ci.Name, Value: ci.Value, Comment: ci.Comment, Type: ci.Type}
+	}
+}
+
+// insertRevision saves the current items of the file as its next revision.
+func (repo *RepositoryImpl) insertRevision(fileId int64, author string) {
+	revisions := repo.revisions[fileId]
+	items := copyItems(repo.fileItems(fileId))
+	for _, ci := range items {
+		ci.Id = 0
+	}
+	rev := &api.ConfigFileRevision{
+		Id:       repo.nextId(),
+		FileId:   fileId,
+		Revision: int64(len(revisions)) + 1,
+		Author:   author,
+		Ctime:    time.Now(),
+		Items:    items,
+	}
+	repo.revisions[fileId] = append(revisions, rev)
+}
+
+func (repo *RepositoryImpl) markAppsOutdated(fileId int64) {
+	for _, ass := range repo.association {
+		if app, ok := repo.apps[ass.appId]; ok && ass.fileId == fileId {
+			app.Outdated = 1
+		}
+	}
+}
+
+// deleteConfigFile deletes the file with its items, revisions and associations,
+// the apps associated with the file are marked outdated.
+func (repo *RepositoryImpl) deleteConfigFile(id int64) {
+	repo.markAppsOutdated(id)
+	repo.deleteAssociation(func(ass *association) bool { return ass.fileId == id })
+	for _, ci := range repo.fileItems(id) {
+		delete(repo.items, ci.Id)
+	}
+	delete(repo.revisions, id)
+	delete(repo.files, id)
+}
+
+// deleteAssociation deletes the associations matching the filter.
+func (repo *RepositoryImpl) deleteAssociation(filter func(ass *association) bool) {
+	kept := repo.association[:0]
+	for _, ass := range repo.association {
+		if !filter(ass) {
+			kept = append(kept, ass)
+		}
+	}
+	repo.association = kept
+}
+
+func (repo *RepositoryImpl) latestRelease(appId int64, status byte) *api.Release {
+	var latest *api.Release
+	for _, release := range repo.releases {
+		if release.AppId == appId && release.Status == status && (latest == nil || release.Id > latest.Id) {
+			latest = release
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	r := *latest
+	return &r
+}
+
+func copyApp(app *api.App) *api.App {
+	return &api.App{Id: app.Id, Name: app.Name, Outdated: app.Outdated}
+}
+
+func copyItems(items []*api.ConfigItem) []*api.ConfigItem {
+	result := make([]*api.ConfigItem, 0, len(items))
+	for _, ci := range items {
+		c := *ci
+		result = append(result, &c)
+	}
+	return result
+}
+
+func (repo *RepositoryImpl) InsertAuditEvent(event *api.AuditEvent) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	e := *event
+	e.Id = repo.nextId()
+	e.Ctime = time.Now()
+	repo.events = append(repo.events, &e)
+	return e.Id, nil
+}
+
+func (repo *RepositoryImpl) QueryAuditEvents(filter *api.AuditFilter) ([]*api.AuditEvent, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	events := make([]*api.AuditEvent, 0, 16)
+	for i := len(repo.events) - 1; i >= 0 && (filter.Limit <= 0 || len(events) < filter.Limit); i-- {
+		if filter.Match(repo.events[i]) {
+			e := *repo.events[i]
+			events = append(events, &e)
+		}
+	}
+	return events, nil
+}
+
+func (repo *RepositoryImpl) InsertWebhook(hook *api.Webhook) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.apps[hook.AppId]; hook.AppId > 0 && !ok {
+		return -1, fmt.Errorf("app [id=%d] doesn't exist", hook.AppId)
+	}
+	h := *hook
+	h.Id = repo.nextId()
+	h.Events = append([]string(nil), hook.Events...)
+	h.Ctime = time.Now()
+	repo.webhooks[h.Id] = &h
+	return h.Id, nil
+}
+
+func (repo *RepositoryImpl) ExistsWebhook(id int64) bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	_, ok := repo.webhooks[id]
+	return ok
+}
+
+func (repo *RepositoryImpl) ListWebhooks() ([]*api.Webhook, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	hooks := make([]*api.Webhook, 0, len(repo.webhooks))
+	for _, hook := range repo.webhooks {
+		h := *hook
+		h.Events = append([]string(nil), hook.Events...)
+		hooks = append(hooks, &h)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Id < hooks[j].Id
+	})
+	return hooks, nil
+}
+
+func (repo *RepositoryImpl) DeleteWebhook(id int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.deleteWebhook(id)
+	return nil
+}
+
+func (repo *RepositoryImpl) InsertWebhookDelivery(delivery *api.WebhookDelivery) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.webhooks[delivery.WebhookId]; !ok {
+		return -1, fmt.Errorf("webhook [id=%d] doesn't exist", delivery.WebhookId)
+	}
+	d := *delivery
+	d.Id = repo.nextId()
+	d.Ctime = time.Now()
+	repo.deliveries = append(repo.deliveries, &d)
+	return d.Id, nil
+}
+
+func (repo *RepositoryImpl) QueryWebhookDeliveries(webhookId int64, limit int) ([]*api.WebhookDelivery, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	deliveries := make([]*api.WebhookDelivery, 0, 16)
+	for i := len(repo.deliveries) - 1; i >= 0 && (limit <= 0 || len(deliveries) < limit); i-- {
+		if repo.deliveries[i].WebhookId == webhookId {
+			d := *repo.deliveries[i]
+			deliveries = append(deliveries, &d)
+		}
+	}
+	return deliveries, nil
+}
+
+// deleteWebhook deletes the webhook with its deliveries.
+func (repo *RepositoryImpl) deleteWebhook(id int64) {
+	delete(repo.webhooks, id)
+	deliveries := repo.deliveries[:0]
+	for _, delivery := range repo.deliveries {
+		if delivery.WebhookId != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	repo.deliveries = deliveries
+}
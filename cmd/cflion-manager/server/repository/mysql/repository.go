@@ -16,10 +16,12 @@ package mysql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/cflion/cflion/pkg/log"
 	"github.com/cflion/cflion/pkg/manager/api"
 	"strings"
+	"time"
 )
 
 type RepositoryImpl struct {
@@ -40,7 +42,7 @@ func (repo *RepositoryImpl) ExistsAppByName(name string) bool {
 	var count int64
 	err := repo.DB.QueryRow("select count(1) from app where name = ?", name).Scan(&count)
 	if err != nil {
-		log.Errorf("Count app [name=%d] error: %s", name, err)
+		log.Errorf("Count app [name=%s] error: %s", name, err)
 		return false
 	}
 	return count == 1
@@ -72,7 +74,7 @@ func (repo *RepositoryImpl) RetrieveAppBrief(id int64) (*api.App, error) {
 		log.Errorf("RetrieveAppBrief app [id=%d] when scan app error: %s", id, err)
 		return nil, err
 	}
-	rows, err := repo.DB.Query("select cf.id, cf.name, cf.namespace_id, app.id as app_id, app.name as app_name, app.outdated from association as ass left join config_file as cf on ass.file_id = cf.id left join app on cf.namespace_id = app.id where ass.app_id = ?", id)
+	rows, err := repo.DB.Query("select cf.id, cf.name, cf.namespace_id, cf.format, cf.public, app.id as app_id, app.name as app_name, app.outdated from association as ass left join config_file as cf on ass.file_id = cf.id left join app on cf.namespace_id = app.id where ass.app_id = ?", id)
 	if err != nil {
 		log.Errorf("RetrieveAppBrief app [id=%d] when query config_file error: %s", id, err)
 		return nil, err
@@ -82,7 +84,7 @@ func (repo *RepositoryImpl) RetrieveAppBrief(id int64) (*api.App, error) {
 	for rows.Next() {
 		var cf api.ConfigFile
 		cf.App = &api.App{}
-		rows.Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
+		rows.Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.Format, &cf.Public, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
 		cfs = append(cfs, &cf)
 	}
 	app.Files = cfs
@@ -143,8 +145,166 @@ func (repo *RepositoryImpl) UpdateAppOutdated(id int64, outdated bool) error {
 	return nil
 }
 
+func (repo *RepositoryImpl) DeleteApp(id int64) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("DeleteApp begin transaction error: %s", err)
+		return err
+	}
+	defer tx.Rollback()
+	fileIds, err := queryIds(tx, "select id from config_file where namespace_id = ?", id)
+	if err != nil {
+		return err
+	}
+	err = deleteConfigFiles(tx, fileIds)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"delete from association where app_id = ?",
+		"delete from `release` where app_id = ?",
+		"delete from webhook_delivery where webhook_id in (select id from webhook where app_id = ?)",
+		"delete from webhook where app_id = ?",
+		"delete from app where id = ?",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			log.Errorf("DeleteApp [id=%d] [%s] error: %s", id, query, err)
+			return err
+		}
+	}
+	err = tx.Commit()
+	return err
+}
+
+func (repo *RepositoryImpl) InsertRelease(release *api.Release) (int64, error) {
+	rule, err := marshalGrayRule(release.Rule)
+	if err != nil {
+		return -1, err
+	}
+	res, err := repo.DB.Exec("insert into `release` (app_id, content, revision, publisher, comment, status, rule, ctime) values (?, ?, ?, ?, ?, ?, ?, now())", release.AppId, release.Content, release.Revision, release.Publisher, release.Comment, release.Status, rule)
+	if err != nil {
+		log.Errorf("Insert release [%s] error: %s", release, err)
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) ListReleases(appId int64) ([]*api.Release, error) {
+	rows, err := repo.DB.Query("select id, app_id, revision, publisher, comment, status, rule, ctime from `release` where app_id = ? order by id desc", appId)
+	if err != nil {
+		log.Errorf("ListReleases app [id=%d] error: %s", appId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	releases := make([]*api.Release, 0, 8)
+	for rows.Next() {
+		var release api.Release
+		var rule string
+		rows.Scan(&release.Id, &release.AppId, &release.Revision, &release.Publisher, &release.Comment, &release.Status, &rule, &release.Ctime)
+		release.Rule = unmarshalGrayRule(rule)
+		releases = append(releases, &release)
+	}
+	return releases, nil
+}
+
+func (repo *RepositoryImpl) ExistsRelease(appId int64, id int64) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from `release` where id = ? and app_id = ?", id, appId).Scan(&count)
+	if err != nil {
+		log.Errorf("Count release [id=%d] [app_id=%d] error: %s", id, appId, err)
+		return false
+	}
+	return count == 1
+}
+
+func (repo *RepositoryImpl) RetrieveRelease(id int64) (*api.Release, error) {
+	release, err := scanRelease(repo.DB.QueryRow("select id, app_id, content, revision, publisher, comment, status, rule, ctime from `release` where id = ?", id))
+	if err != nil {
+		log.Errorf("RetrieveRelease [id=%d] error: %s", id, err)
+		return nil, err
+	}
+	return release, nil
+}
+
+func (repo *RepositoryImpl) RetrieveLatestRelease(appId int64) (*api.Release, error) {
+	release, err := scanRelease(repo.DB.QueryRow("select id, app_id, content, revision, publisher, comment, status, rule, ctime from `release` where app_id = ? and status = ? order by id desc limit 1", appId, api.ReleaseNormal))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("RetrieveLatestRelease app [id=%d] error: %s", appId, err)
+		return nil, err
+	}
+	return release, nil
+}
+
+func (repo *RepositoryImpl) RetrieveActiveGrayRelease(appId int64) (*api.Release, error) {
+	release, err := scanRelease(repo.DB.QueryRow("select id, app_id, content, revision, publisher, comment, status, rule, ctime from `release` where app_id = ? and status = ? order by id desc limit 1", appId, api.ReleaseGray))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("RetrieveActiveGrayRelease app [id=%d] error: %s", appId, err)
+		return nil, err
+	}
+	return release, nil
+}
+
+func (repo *RepositoryImpl) UpdateReleaseStatus(id int64, status byte) error {
+	_, err := repo.DB.Exec("update `release` set status = ? where id = ?", status, id)
+	if err != nil {
+		log.Errorf("UpdateReleaseStatus [id=%d] [status=%d] error: %s", id, status, err)
+		return err
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) UpdateReleaseRevision(id int64, revision int64) error {
+	_, err := repo.DB.Exec("update `release` set revision = ? where id = ?", revision, id)
+	if err != nil {
+		log.Errorf("UpdateReleaseRevision [id=%d] [revision=%d] error: %s", id, revision, err)
+		return err
+	}
+	return nil
+}
+
+func scanRelease(row *sql.Row) (*api.Release, error) {
+	var release api.Release
+	var rule string
+	err := row.Scan(&release.Id, &release.AppId, &release.Content, &release.Revision, &release.Publisher, &release.Comment, &release.Status, &rule, &release.Ctime)
+	if err != nil {
+		return nil, err
+	}
+	release.Rule = unmarshalGrayRule(rule)
+	return &release, nil
+}
+
+func marshalGrayRule(rule *api.GrayRule) (string, error) {
+	if rule == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func unmarshalGrayRule(s string) *api.GrayRule {
+	if len(s) == 0 {
+		return nil
+	}
+	var rule api.GrayRule
+	if err := json.Unmarshal([]byte(s), &rule); err != nil {
+		log.Errorf("Unmarshal gray rule [%s] error: %s", s, err)
+		return nil
+	}
+	return &rule
+}
+
 func (repo *RepositoryImpl) ListConfigFilesBrief() ([]*api.ConfigFile, error) {
-	rows, err := repo.DB.Query("select cf.id, cf.name, cf.namespace_id, app.id as app_id, app.name as app_name, app.outdated from config_file as cf left join app on cf.namespace_id = app.id")
+	rows, err := repo.DB.Query("select cf.id, cf.name, cf.namespace_id, cf.format, cf.public, app.id as app_id, app.name as app_name, app.outdated from config_file as cf left join app on cf.namespace_id = app.id")
 	if err != nil {
 		log.Errorf("ListConfigFileBrief error: %s", err)
 		return nil, err
@@ -154,7 +314,7 @@ func (repo *RepositoryImpl) ListConfigFilesBrief() ([]*api.ConfigFile, error) {
 	for rows.Next() {
 		var cf api.ConfigFile
 		cf.App = &api.App{}
-		rows.Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
+		rows.Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.Format, &cf.Public, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
 		cfs = append(cfs, &cf)
 	}
 	return cfs, nil
@@ -180,14 +340,14 @@ func (repo *RepositoryImpl) ExistsConfigFileById(id int64) bool {
 	return count == 1
 }
 
-func (repo *RepositoryImpl) InsertConfigFileWithItems(cf *api.ConfigFile) (int64, error) {
+func (repo *RepositoryImpl) InsertConfigFileWithItems(cf *api.ConfigFile, author string) (int64, error) {
 	tx, err := repo.DB.Begin()
 	if err != nil {
 		log.Errorf("InsertConfigFileWithItems begin transaction error: %s", err)
 		return -1, err
 	}
 	defer tx.Rollback()
-	res, err := tx.Exec("insert into config_file (name, namespace_id, ctime, utime) values (?, ?, now(), now())", cf.Name, cf.NamespaceId)
+	res, err := tx.Exec("insert into config_file (name, namespace_id, format, public, ctime, utime) values (?, ?, ?, ?, now(), now())", cf.Name, cf.NamespaceId, cf.Format, cf.Public)
 	if err != nil {
 		log.Errorf("Insert config_file [%s] error: %s", cf, err)
 		return -1, err
@@ -202,18 +362,12 @@ func (repo *RepositoryImpl) InsertConfigFileWithItems(cf *api.ConfigFile) (int64
 		log.Errorf("Insert association [app_id=%d] [file_id=%d] error: %s", cf.NamespaceId, fileId, err)
 		return -1, err
 	}
-	// insert config_item
-	patterns := make([]string, 0, len(cf.Items))
-	params := make([]interface{}, 0, len(cf.Items))
-	for _, item := range cf.Items {
-		patterns = append(patterns, "(?, ?, ?, ?, now(), now())")
-		params = append(params, fileId, item.Name, item.Value, item.Comment)
+	err = insertBatchConfigItems(tx, fileId, cf.Items)
+	if err != nil {
+		return -1, err
 	}
-	query := fmt.Sprintf("insert into config_item (file_id, name, value, comment, ctime, utime) values %s", strings.Join(patterns, ","))
-	log.Info("query=", query)
-	_, err = tx.Exec(query, params...)
+	err = insertConfigFileRevision(tx, fileId, author)
 	if err != nil {
-		log.Error("Insert batch config_item %s error: %s", cf.Items, err)
 		return -1, err
 	}
 	err = tx.Commit()
@@ -223,12 +377,12 @@ func (repo *RepositoryImpl) InsertConfigFileWithItems(cf *api.ConfigFile) (int64
 func (repo *RepositoryImpl) RetrieveConfigFileDetail(id int64) (*api.ConfigFile, error) {
 	var cf api.ConfigFile
 	cf.App = &api.App{}
-	err := repo.DB.QueryRow("select cf.id, cf.name, cf.namespace_id, app.id as app_id, app.name as app_name, app.outdated from config_file as cf left join app on cf.namespace_id = app.id where cf.id = ?", id).Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
+	err := repo.DB.QueryRow("select cf.id, cf.name, cf.namespace_id, cf.format, cf.version, cf.public, app.id as app_id, app.name as app_name, app.outdated from config_file as cf left join app on cf.namespace_id = app.id where cf.id = ?", id).Scan(&cf.Id, &cf.Name, &cf.NamespaceId, &cf.Format, &cf.Version, &cf.Public, &cf.App.Id, &cf.App.Name, &cf.App.Outdated)
 	if err != nil {
 		log.Errorf("RetrieveConfigFileDetail [id=%d] error: %s", id, err)
 		return nil, err
 	}
-	rows, err := repo.DB.Query("select id, file_id, name, value, comment from config_item where file_id = ?", id)
+	rows, err := repo.DB.Query("select id, file_id, name, value, comment, type from config_item where file_id = ?", id)
 	if err != nil {
 		log.Errorf("RetrieveConfigFileDetail [id=%d] query config_item error: %s", id, err)
 		return nil, err
@@ -237,66 +391,614 @@ func (repo *RepositoryImpl) RetrieveConfigFileDetail(id int64) (*api.ConfigFile,
 	cis := make([]*api.ConfigItem, 0, 8)
 	for rows.Next() {
 		var ci api.ConfigItem
-		rows.Scan(&ci.Id, &ci.FileId, &ci.Name, &ci.Value, &ci.Comment)
+		rows.Scan(&ci.Id, &ci.FileId, &ci.Name, &ci.Value, &ci.Comment, &ci.Type)
 		cis = append(cis, &ci)
 	}
 	cf.Items = cis
 	return &cf, nil
 }
 
-func (repo *RepositoryImpl) UpdateConfigFile(fileId int64, items []*api.ConfigItem) error {
+// UpdateConfigFile replaces the items of the file with the given ones, a new revision is saved
+// and the associated apps are marked outdated only if anything changes.
+func (repo *RepositoryImpl) UpdateConfigFile(fileId int64, version int64, items []*api.ConfigItem, author string) ([]*api.ConfigItemDiff, int64, error) {
 	tx, err := repo.DB.Begin()
 	if err != nil {
 		log.Errorf("UpdateConfigFile begin transaction error: %s", err)
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	current, err := lockConfigFileVersion(tx, fileId)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > 0 && version != current {
+		return nil, current, &api.VersionConflictError{Version: current}
+	}
+	oldItems, err := queryConfigItems(tx, fileId)
+	if err != nil {
+		return nil, 0, err
+	}
+	diffs := api.DiffConfigItems(oldItems, items)
+	if len(diffs) == 0 {
+		return diffs, current, nil
+	}
+	added := make([]*api.ConfigItem, 0, len(diffs))
+	for _, diff := range diffs {
+		switch diff.Type {
+		case api.DiffAdded:
+			added = append(added, diff.New)
+		case api.DiffModified:
+			_, err = tx.Exec("update config_item set value = ?, comment = ?, type = ?, utime = now() where id = ?", diff.New.Value, diff.New.Comment, diff.New.Type, diff.Old.Id)
+			if err != nil {
+				log.Errorf("UpdateConfigFile update config_item [%s] error: %s", diff.Old, err)
+				return nil, 0, err
+			}
+		case api.DiffRemoved:
+			_, err = tx.Exec("delete from config_item where id = ?", diff.Old.Id)
+			if err != nil {
+				log.Errorf("UpdateConfigFile delete config_item [%s] error: %s", diff.Old, err)
+				return nil, 0, err
+			}
+		}
+	}
+	err = insertBatchConfigItems(tx, fileId, added)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = updateConfigFileItems(tx, fileId, author)
+	if err != nil {
+		return nil, 0, err
+	}
+	if current, err = lockConfigFileVersion(tx, fileId); err != nil {
+		return nil, 0, err
+	}
+	err = tx.Commit()
+	return diffs, current, err
+}
+
+func (repo *RepositoryImpl) DeleteConfigFile(id int64) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("DeleteConfigFile begin transaction error: %s", err)
+		return err
+	}
+	defer tx.Rollback()
+	err = deleteConfigFiles(tx, []int64{id})
+	if err != nil {
 		return err
 	}
+	err = tx.Commit()
+	return err
+}
+
+func (repo *RepositoryImpl) UpdateConfigFilePublic(id int64, public bool) error {
+	_, err := repo.DB.Exec("update config_file set public = ? where id = ?", public, id)
+	if err != nil {
+		log.Errorf("UpdateConfigFilePublic [id=%d] [public=%t] error: %s", id, public, err)
+		return err
+	}
+	return nil
+}
+
+func (repo *RepositoryImpl) ListAssociatedApps(fileId int64) ([]*api.App, error) {
+	rows, err := repo.DB.Query("select app.id, app.name, app.outdated from association as ass join app on ass.app_id = app.id where ass.file_id = ? order by app.id", fileId)
+	if err != nil {
+		log.Errorf("ListAssociatedApps config_file [id=%d] error: %s", fileId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	apps := make([]*api.App, 0, 8)
+	for rows.Next() {
+		var app api.App
+		rows.Scan(&app.Id, &app.Name, &app.Outdated)
+		apps = append(apps, &app)
+	}
+	return apps, nil
+}
+
+func (repo *RepositoryImpl) ExistsConfigItem(fileId int64, id int64) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from config_item where id = ? and file_id = ?", id, fileId).Scan(&count)
+	if err != nil {
+		log.Errorf("Count config_item [id=%d] [file_id=%d] error: %s", id, fileId, err)
+		return false
+	}
+	return count == 1
+}
+
+func (repo *RepositoryImpl) ExistsConfigItemByName(fileId int64, name string) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from config_item where file_id = ? and name = ?", fileId, name).Scan(&count)
+	if err != nil {
+		log.Errorf("Count config_item [file_id=%d] [name=%s] error: %s", fileId, name, err)
+		return false
+	}
+	return count > 0
+}
+
+func (repo *RepositoryImpl) RetrieveConfigItem(id int64) (*api.ConfigItem, error) {
+	var ci api.ConfigItem
+	err := repo.DB.QueryRow("select id, file_id, name, value, comment, type from config_item where id = ?", id).Scan(&ci.Id, &ci.FileId, &ci.Name, &ci.Value, &ci.Comment, &ci.Type)
+	if err != nil {
+		log.Errorf("RetrieveConfigItem [id=%d] error: %s", id, err)
+		return nil, err
+	}
+	return &ci, nil
+}
+
+func (repo *RepositoryImpl) InsertConfigItem(item *api.ConfigItem, version int64, author string) (int64, int64, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("InsertConfigItem begin transaction error: %s", err)
+		return -1, 0, err
+	}
+	defer tx.Rollback()
+	if err = checkConfigFileVersion(tx, item.FileId, version); err != nil {
+		return -1, 0, err
+	}
+	res, err := tx.Exec("insert into config_item (file_id, name, value, comment, type, ctime, utime) values (?, ?, ?, ?, ?, now(), now())", item.FileId, item.Name, item.Value, item.Comment, item.Type)
+	if err != nil {
+		log.Errorf("Insert config_item [%s] error: %s", item, err)
+		return -1, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Errorf("Get config_item insert id error: %s", err)
+		return -1, 0, err
+	}
+	err = updateConfigFileItems(tx, item.FileId, author)
+	if err != nil {
+		return -1, 0, err
+	}
+	if version, err = lockConfigFileVersion(tx, item.FileId); err != nil {
+		return -1, 0, err
+	}
+	err = tx.Commit()
+	return id, version, err
+}
+
+func (repo *RepositoryImpl) UpdateConfigItem(item *api.ConfigItem, version int64, author string) (int64, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("UpdateConfigItem begin transaction error: %s", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err = checkConfigFileVersion(tx, item.FileId, version); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("update config_item set name = ?, value = ?, comment = ?, type = ?, utime = now() where id = ? and file_id = ?", item.Name, item.Value, item.Comment, item.Type, item.Id, item.FileId)
+	if err != nil {
+		log.Errorf("Update config_item [%s] error: %s", item, err)
+		return 0, err
+	}
+	err = updateConfigFileItems(tx, item.FileId, author)
+	if err != nil {
+		return 0, err
+	}
+	if version, err = lockConfigFileVersion(tx, item.FileId); err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	return version, err
+}
+
+func (repo *RepositoryImpl) DeleteConfigItem(fileId int64, id int64, version int64, author string) (int64, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("DeleteConfigItem begin transaction error: %s", err)
+		return 0, err
+	}
 	defer tx.Rollback()
-	rows, err := tx.Query("select id, name, value, comment from config_item where file_id = ?", fileId)
+	if err = checkConfigFileVersion(tx, fileId, version); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("delete from config_item where id = ? and file_id = ?", id, fileId)
+	if err != nil {
+		log.Errorf("Delete config_item [id=%d] [file_id=%d] error: %s", id, fileId, err)
+		return 0, err
+	}
+	err = updateConfigFileItems(tx, fileId, author)
+	if err != nil {
+		return 0, err
+	}
+	if version, err = lockConfigFileVersion(tx, fileId); err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	return version, err
+}
+
+func (repo *RepositoryImpl) ListConfigFileRevisions(fileId int64) ([]*api.ConfigFileRevision, error) {
+	rows, err := repo.DB.Query("select id, file_id, revision, author, ctime from config_file_revision where file_id = ? order by revision desc", fileId)
+	if err != nil {
+		log.Errorf("ListConfigFileRevisions config_file [id=%d] error: %s", fileId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]*api.ConfigFileRevision, 0, 8)
+	for rows.Next() {
+		var revision api.ConfigFileRevision
+		rows.Scan(&revision.Id, &revision.FileId, &revision.Revision, &revision.Author, &revision.Ctime)
+		revisions = append(revisions, &revision)
+	}
+	return revisions, nil
+}
+
+func (repo *RepositoryImpl) ExistsConfigFileRevision(fileId int64, revision int64) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from config_file_revision where file_id = ? and revision = ?", fileId, revision).Scan(&count)
+	if err != nil {
+		log.Errorf("Count config_file_revision [file_id=%d] [revision=%d] error: %s", fileId, revision, err)
+		return false
+	}
+	return count == 1
+}
+
+func (repo *RepositoryImpl) RetrieveConfigFileRevision(fileId int64, revision int64) (*api.ConfigFileRevision, error) {
+	var rev api.ConfigFileRevision
+	var items string
+	err := repo.DB.QueryRow("select id, file_id, revision, author, items, ctime from config_file_revision where file_id = ? and revision = ?", fileId, revision).Scan(&rev.Id, &rev.FileId, &rev.Revision, &rev.Author, &items, &rev.Ctime)
+	if err != nil {
+		log.Errorf("RetrieveConfigFileRevision [file_id=%d] [revision=%d] error: %s", fileId, revision, err)
+		return nil, err
+	}
+	var revItems []*revisionItem
+	err = json.Unmarshal([]byte(items), &revItems)
+	if err != nil {
+		log.Errorf("RetrieveConfigFileRevision [file_id=%d] [revision=%d] unmarshal items error: %s", fileId, revision, err)
+		return nil, err
+	}
+	rev.Items = make([]*api.ConfigItem, 0, len(revItems))
+	for _, item := range revItems {
+		rev.Items = append(rev.Items, &api.ConfigItem{FileId: fileId, Name: item.Name, Value: item.Value, Comment: item.Comment, Type: item.Type})
+	}
+	return &rev, nil
+}
+
+// revisionItem is the json structure of an item saved in config_file_revision.
+type revisionItem struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Comment string `json:"comment,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+func (repo *RepositoryImpl) InsertAuditEvent(event *api.AuditEvent) (int64, error) {
+	res, err := repo.DB.Exec("insert into audit_event (actor, action, app_id, target, before_summary, after_summary, request_id, ctime) values (?, ?, ?, ?, ?, ?, ?, now())",
+		event.Actor, event.Action, event.AppId, event.Target, event.Before, event.After, event.RequestId)
+	if err != nil {
+		log.Errorf("Insert audit event [action=%s] [target=%s] error: %s", event.Action, event.Target, err)
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) QueryAuditEvents(filter *api.AuditFilter) ([]*api.AuditEvent, error) {
+	conds := make([]string, 0, 4)
+	params := make([]interface{}, 0, 5)
+	if filter.AppId > 0 {
+		conds = append(conds, "app_id = ?")
+		params = append(params, filter.AppId)
+	}
+	if len(filter.Actor) > 0 {
+		conds = append(conds, "actor = ?")
+		params = append(params, filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		conds = append(conds, "ctime >= ?")
+		params = append(params, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conds = append(conds, "ctime < ?")
+		params = append(params, filter.Until)
+	}
+	query := "select id, actor, action, app_id, target, before_summary, after_summary, request_id, ctime from audit_event"
+	if len(conds) > 0 {
+		query += " where " + strings.Join(conds, " and ")
+	}
+	query += " order by id desc"
+	if filter.Limit > 0 {
+		query += " limit ?"
+		params = append(params, filter.Limit)
+	}
+	rows, err := repo.DB.Query(query, params...)
 	if err != nil {
-		log.Errorf("UpdateConfigFile config_file [id=%d] query config_item error: %s", fileId, err)
+		log.Errorf("Query audit events error: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]*api.AuditEvent, 0, 16)
+	for rows.Next() {
+		var event api.AuditEvent
+		rows.Scan(&event.Id, &event.Actor, &event.Action, &event.AppId, &event.Target, &event.Before, &event.After, &event.RequestId, &event.Ctime)
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+func (repo *RepositoryImpl) InsertWebhook(hook *api.Webhook) (int64, error) {
+	if hook.AppId > 0 && !repo.ExistsAppById(hook.AppId) {
+		return -1, fmt.Errorf("app [id=%d] doesn't exist", hook.AppId)
+	}
+	res, err := repo.DB.Exec("insert into webhook (app_id, url, secret, events, ctime) values (?, ?, ?, ?, now())",
+		hook.AppId, hook.Url, hook.Secret, strings.Join(hook.Events, ","))
+	if err != nil {
+		log.Errorf("Insert webhook [%s] error: %s", hook, err)
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) ExistsWebhook(id int64) bool {
+	var count int64
+	err := repo.DB.QueryRow("select count(1) from webhook where id = ?", id).Scan(&count)
+	if err != nil {
+		log.Errorf("Count webhook [id=%d] error: %s", id, err)
+		return false
+	}
+	return count > 0
+}
+
+func (repo *RepositoryImpl) ListWebhooks() ([]*api.Webhook, error) {
+	rows, err := repo.DB.Query("select id, app_id, url, secret, events, ctime from webhook order by id")
+	if err != nil {
+		log.Errorf("List webhooks error: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := make([]*api.Webhook, 0, 8)
+	for rows.Next() {
+		var hook api.Webhook
+		var events string
+		rows.Scan(&hook.Id, &hook.AppId, &hook.Url, &hook.Secret, &events, &hook.Ctime)
+		if len(events) > 0 {
+			hook.Events = strings.Split(events, ",")
+		}
+		hooks = append(hooks, &hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (repo *RepositoryImpl) DeleteWebhook(id int64) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Errorf("DeleteWebhook begin transaction error: %s", err)
 		return err
 	}
-	oldItems := make(map[string]*api.ConfigItem, len(items))
+	defer tx.Rollback()
+	for _, query := range []string{
+		"delete from webhook_delivery where webhook_id = ?",
+		"delete from webhook where id = ?",
+	} {
+		if _, err = tx.Exec(query, id); err != nil {
+			log.Errorf("DeleteWebhook [id=%d] [%s] error: %s", id, query, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (repo *RepositoryImpl) InsertWebhookDelivery(delivery *api.WebhookDelivery) (int64, error) {
+	res, err := repo.DB.Exec("insert into webhook_delivery (webhook_id, event, payload, attempt, status_code, response, error, duration_ms, ctime) "+
+		"select id, ?, ?, ?, ?, ?, ?, ?, now() from webhook where id = ?",
+		delivery.Event, delivery.Payload, delivery.Attempt, delivery.StatusCode, delivery.Response, delivery.Error,
+		int64(delivery.Duration/time.Millisecond), delivery.WebhookId)
+	if err != nil {
+		log.Errorf("Insert delivery of webhook [id=%d] error: %s", delivery.WebhookId, err)
+		return -1, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return -1, fmt.Errorf("webhook [id=%d] doesn't exist", delivery.WebhookId)
+	}
+	return res.LastInsertId()
+}
+
+func (repo *RepositoryImpl) QueryWebhookDeliveries(webhookId int64, limit int) ([]*api.WebhookDelivery, error) {
+	query := "select id, webhook_id, event, payload, attempt, status_code, response, error, duration_ms, ctime from webhook_delivery where webhook_id = ? order by id desc"
+	params := []interface{}{webhookId}
+	if limit > 0 {
+		query += " limit ?"
+		params = append(params, limit)
+	}
+	rows, err := repo.DB.Query(query, params...)
+	if err != nil {
+		log.Errorf("Query deliveries of webhook [id=%d] error: %s", webhookId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*api.WebhookDelivery, 0, 16)
+	for rows.Next() {
+		var delivery api.WebhookDelivery
+		var duration int64
+		rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &delivery.Payload, &delivery.Attempt, &delivery.StatusCode,
+			&delivery.Response, &delivery.Error, &duration, &delivery.Ctime)
+		delivery.Duration = time.Duration(duration) * time.Millisecond
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func queryConfigItems(tx *sql.Tx, fileId int64) ([]*api.ConfigItem, error) {
+	rows, err := tx.Query("select id, file_id, name, value, comment, type from config_item where file_id = ? order by id", fileId)
+	if err != nil {
+		log.Errorf("Query config_item [file_id=%d] error: %s", fileId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	cis := make([]*api.ConfigItem, 0, 8)
 	for rows.Next() {
 		var ci api.ConfigItem
-		rows.Scan(&ci.Id, &ci.Name, &ci.Value, &ci.Comment)
-		oldItems[ci.Name] = &ci
-	}
-	outdated := false
-	for _, ci := range items {
-		if oldItem, ok := oldItems[ci.Name]; ok {
-			if ci.Value != oldItem.Value || ci.Comment != oldItem.Comment {
-				tx.Exec("update config_item set value = ?, comment = ? where id = ?", ci.Value, ci.Comment, oldItem.Id)
-				outdated = true
-			}
-		} else {
-			tx.Exec("insert into config_item (file_id, name, value, comment, ctime, utime) values (?, ?, ?, ?, now(), now())", fileId, ci.Name, ci.Value, ci.Comment)
+		rows.Scan(&ci.Id, &ci.FileId, &ci.Name, &ci.Value, &ci.Comment, &ci.Type)
+		cis = append(cis, &ci)
+	}
+	return cis, rows.Err()
+}
+
+func insertBatchConfigItems(tx *sql.Tx, fileId int64, items []*api.ConfigItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	patterns := make([]string, 0, len(items))
+	params := make([]interface{}, 0, len(items)*5)
+	for _, item := range items {
+		patterns = append(patterns, "(?, ?, ?, ?, ?, now(), now())")
+		params = append(params, fileId, item.Name, item.Value, item.Comment, item.Type)
+	}
+	query := fmt.Sprintf("insert into config_item (file_id, name, value, comment, type, ctime, utime) values %s", strings.Join(patterns, ","))
+	_, err := tx.Exec(query, params...)
+	if err != nil {
+		log.Errorf("Insert batch config_item %s error: %s", items, err)
+		return err
+	}
+	return nil
+}
+
+// insertConfigFileRevision saves the current items of the file as its next revision.
+func insertConfigFileRevision(tx *sql.Tx, fileId int64, author string) error {
+	var revision int64
+	err := tx.QueryRow("select coalesce(max(revision), 0) + 1 from config_file_revision where file_id = ? for update", fileId).Scan(&revision)
+	if err != nil {
+		log.Errorf("Query next revision of config_file [id=%d] error: %s", fileId, err)
+		return err
+	}
+	cis, err := queryConfigItems(tx, fileId)
+	if err != nil {
+		return err
+	}
+	revItems := make([]*revisionItem, 0, len(cis))
+	for _, ci := range cis {
+		revItems = append(revItems, &revisionItem{Name: ci.Name, Value: ci.Value, Comment: ci.Comment, Type: ci.Type})
+	}
+	items, err := json.Marshal(revItems)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("insert into config_file_revision (file_id, revision, author, items, ctime) values (?, ?, ?, ?, now())", fileId, revision, author, string(items))
+	if err != nil {
+		log.Errorf("Insert config_file_revision [file_id=%d] [revision=%d] error: %s", fileId, revision, err)
+		return err
+	}
+	_, err = tx.Exec("update config_file set version = ? where id = ?", revision, fileId)
+	if err != nil {
+		log.Errorf("Update version of config_file [id=%d] to [revision=%d] error: %s", fileId, revision, err)
+		return err
+	}
+	return nil
+}
+
+// lockConfigFileVersion locks the file until the transaction ends and returns its version.
+func lockConfigFileVersion(tx *sql.Tx, fileId int64) (int64, error) {
+	var version int64
+	err := tx.QueryRow("select version from config_file where id = ? for update", fileId).Scan(&version)
+	if err != nil {
+		log.Errorf("Lock config_file [id=%d] error: %s", fileId, err)
+		return 0, err
+	}
+	return version, nil
+}
+
+// checkConfigFileVersion locks the file until the transaction ends, it fails with *api.VersionConflictError
+// if the version isn't the current one, version 0 matches any.
+func checkConfigFileVersion(tx *sql.Tx, fileId int64, version int64) error {
+	current, err := lockConfigFileVersion(tx, fileId)
+	if err != nil {
+		return err
+	}
+	if version > 0 && version != current {
+		return &api.VersionConflictError{Version: current}
+	}
+	return nil
+}
+
+// updateConfigFileItems marks the apps associated with the file outdated and saves a revision
+// after the items of the file are changed.
+func updateConfigFileItems(tx *sql.Tx, fileId int64, author string) error {
+	err := markAppsOutdated(tx, fileId)
+	if err != nil {
+		return err
+	}
+	return insertConfigFileRevision(tx, fileId, author)
+}
+
+func markAppsOutdated(tx *sql.Tx, fileId int64) error {
+	_, err := tx.Exec("update app set app.outdated = 1 where app.id in (select ass.app_id from association as ass where ass.file_id = ?)", fileId)
+	if err != nil {
+		log.Errorf("Mark apps associated with config_file [id=%d] outdated error: %s", fileId, err)
+		return err
+	}
+	return nil
+}
+
+// deleteConfigFiles deletes the files with their items, revisions and associations,
+// the apps associated with the files are marked outdated.
+func deleteConfigFiles(tx *sql.Tx, fileIds []int64) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
+	patterns := make([]string, 0, len(fileIds))
+	params := make([]interface{}, 0, len(fileIds))
+	for _, fileId := range fileIds {
+		patterns = append(patterns, "?")
+		params = append(params, fileId)
+	}
+	in := strings.Join(patterns, ",")
+	for _, query := range []string{
+		"update app set app.outdated = 1 where app.id in (select ass.app_id from association as ass where ass.file_id in (%s))",
+		"delete from association where file_id in (%s)",
+		"delete from config_item where file_id in (%s)",
+		"delete from config_file_revision where file_id in (%s)",
+		"delete from config_file where id in (%s)",
+	} {
+		_, err := tx.Exec(fmt.Sprintf(query, in), params...)
+		if err != nil {
+			log.Errorf("Delete config_file [ids=%v] [%s] error: %s", fileIds, query, err)
+			return err
 		}
 	}
-	if outdated {
-		tx.Exec("update app set app.outdated = 1 where app.id in (select ass.app_id from association as ass where ass.file_id = ?)", fileId)
+	return nil
+}
+
+func queryIds(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		log.Errorf("Query ids [%s] %v error: %s", query, args, err)
+		return nil, err
 	}
-	err = tx.Commit()
-	return err
+	defer rows.Close()
+	ids := make([]int64, 0, 8)
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func insertAppBatchAssociation(tx *sql.Tx, appId int64, fileIds []int64) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
 	patterns := make([]string, 0, len(fileIds))
 	params := make([]interface{}, 0, len(fileIds))
 	for _, fileId := range fileIds {
 		patterns = append(patterns, "(?, ?, now(), now())")
 		params = append(params, appId, fileId)
 	}
-	query := fmt.Sprintf("insert into association (app_id, file_id, ctime, utime) values %s", strings.Join(patterns, ","))
+	// the existing associations are kept as they are
+	query := fmt.Sprintf("insert into association (app_id, file_id, ctime, utime) values %s on duplicate key update app_id = app_id", strings.Join(patterns, ","))
 	_, err := tx.Exec(query, params...)
 	if err != nil {
-		log.Errorf("Insert app [id=%d] association [file_ids=%s] error: %s", appId, fileIds, err)
+		log.Errorf("Insert app [id=%d] association [file_ids=%v] error: %s", appId, fileIds, err)
 		return err
 	}
 	return nil
 }
 
 func deleteAppBatchAssociation(tx *sql.Tx, appId int64, fileIds []int64) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
 	patterns := make([]string, 0, len(fileIds))
 	params := make([]interface{}, 0, len(fileIds)+1)
 	params = append(params, appId)
@@ -304,10 +1006,10 @@ func deleteAppBatchAssociation(tx *sql.Tx, appId int64, fileIds []int64) error {
 		patterns = append(patterns, "?")
 		params = append(params, fileId)
 	}
-	query := fmt.Sprintf("delete form association where app_id = ? and file_id in (%s)", strings.Join(patterns, ","))
+	query := fmt.Sprintf("delete from association where app_id = ? and file_id in (%s)", strings.Join(patterns, ","))
 	_, err := tx.Exec(query, params...)
 	if err != nil {
-		log.Errorf("Delete app [id=%d] association [file_ids=%s] error: %s", appId, fileIds, err)
+		log.Errorf("Delete app [id=%d] association [file_ids=%v] error: %s", appId, fileIds, err)
 		return err
 	}
 	return nil
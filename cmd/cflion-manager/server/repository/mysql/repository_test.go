@@ -0,0 +1,47 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/repotest"
+	_ "github.com/go-sql-driver/mysql"
+	"os"
+	"testing"
+)
+
+// TestRepositoryImpl runs against the database created by schema/cflion_manager.sql,
+// e.g. CFLION_MANAGER_TEST_DSN="root:@tcp(127.0.0.1:3306)/cflion_manager?parseTime=true",
+// all the tables are truncated.
+func TestRepositoryImpl(t *testing.T) {
+	dsn := os.Getenv("CFLION_MANAGER_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("CFLION_MANAGER_TEST_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repotest.TestRepository(t, func() server.Repository {
+		for _, table := range []string{"app", "config_file", "association", "config_item", "config_file_revision", "`release`", "audit_event", "webhook", "webhook_delivery"} {
+			if _, err := db.Exec("truncate table " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &RepositoryImpl{DB: db}
+	})
+}
@@ -0,0 +1,730 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package repotest is the contract test suite every implementation of server.Repository must pass.
+package repotest
+
+import (
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-manager/server"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"testing"
+	"time"
+)
+
+// TestRepository runs the suite, newRepo must return an empty repository for every test case.
+func TestRepository(t *testing.T, newRepo func() server.Repository) {
+	t.Run("App", func(t *testing.T) { testApp(t, newRepo()) })
+	t.Run("AppUniqueness", func(t *testing.T) { testAppUniqueness(t, newRepo()) })
+	t.Run("ConfigFile", func(t *testing.T) { testConfigFile(t, newRepo()) })
+	t.Run("Association", func(t *testing.T) { testAssociation(t, newRepo()) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepo()) })
+	t.Run("ConfigItem", func(t *testing.T) { testConfigItem(t, newRepo()) })
+	t.Run("ConfigFileRevision", func(t *testing.T) { testConfigFileRevision(t, newRepo()) })
+	t.Run("ConfigFileRollback", func(t *testing.T) { testConfigFileRollback(t, newRepo()) })
+	t.Run("ConfigFileVersion", func(t *testing.T) { testConfigFileVersion(t, newRepo()) })
+	t.Run("Release", func(t *testing.T) { testRelease(t, newRepo()) })
+	t.Run("ReleaseRollback", func(t *testing.T) { testReleaseRollback(t, newRepo()) })
+	t.Run("AuditEvent", func(t *testing.T) { testAuditEvent(t, newRepo()) })
+	t.Run("Webhook", func(t *testing.T) { testWebhook(t, newRepo()) })
+}
+
+func testApp(t *testing.T, repo server.Repository) {
+	id := mustInsertApp(t, repo, "demo")
+	if !repo.ExistsAppById(id) || !repo.ExistsAppByName("demo") {
+		t.Errorf("expect app [id=%d] exists", id)
+	}
+	if repo.ExistsAppById(id+1000) || repo.ExistsAppByName("none") {
+		t.Error("expect unknown app doesn't exist")
+	}
+	app, err := repo.GetAppByName("demo")
+	if err != nil || app.Id != id || app.Name != "demo" || app.Outdated != 1 {
+		t.Errorf("GetAppByName got %v: %v", app, err)
+	}
+	if _, err = repo.GetAppByName("none"); err == nil {
+		t.Error("expect error for unknown app")
+	}
+	if err = repo.UpdateAppOutdated(id, false); err != nil {
+		t.Fatal(err)
+	}
+	app, err = repo.RetrieveAppBrief(id)
+	if err != nil || app.Outdated != 0 || len(app.Files) != 0 {
+		t.Errorf("RetrieveAppBrief got %v: %v", app, err)
+	}
+	if _, err = repo.RetrieveAppBrief(id + 1000); err == nil {
+		t.Error("expect error for unknown app")
+	}
+}
+
+func testAppUniqueness(t *testing.T, repo server.Repository) {
+	mustInsertApp(t, repo, "demo")
+	if _, err := repo.InsertApp(&api.App{Name: "demo"}); err == nil {
+		t.Error("expect error when inserting an app with a duplicate name")
+	}
+}
+
+func testConfigFile(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1", "port", "3306")
+	if !repo.ExistsConfigFileById(fileId) || !repo.ExistsConfigFileByNameAndNamespaceId("db", appId) {
+		t.Errorf("expect config file [id=%d] exists", fileId)
+	}
+	if repo.ExistsConfigFileByNameAndNamespaceId("db", appId+1000) {
+		t.Error("expect config file doesn't exist in another namespace")
+	}
+	cf, err := repo.RetrieveConfigFileDetail(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Name != "db" || cf.NamespaceId != appId || cf.App.Name != "demo" {
+		t.Errorf("RetrieveConfigFileDetail got %s", cf)
+	}
+	assertItems(t, cf.Items, "host", "127.0.0.1", "port", "3306")
+	cfs, err := repo.ListConfigFilesBrief()
+	if err != nil || len(cfs) != 1 || cfs[0].Id != fileId || cfs[0].App.Id != appId {
+		t.Errorf("ListConfigFilesBrief got %v: %v", cfs, err)
+	}
+	// the file is associated with its namespace app
+	app, err := repo.RetrieveAppDetail(appId)
+	if err != nil || len(app.Files) != 1 {
+		t.Fatalf("RetrieveAppDetail got %v: %v", app, err)
+	}
+	assertItems(t, app.Files[0].Items, "host", "127.0.0.1", "port", "3306")
+
+	// the format of the file is kept
+	yamlId, err := repo.InsertConfigFileWithItems(&api.ConfigFile{Name: "app.yml", NamespaceId: appId, Format: api.FormatYAML}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf, err = repo.RetrieveConfigFileDetail(yamlId); err != nil || cf.Format != api.FormatYAML {
+		t.Errorf("expect yaml file, got %v: %v", cf, err)
+	}
+	app, _ = repo.RetrieveAppDetail(appId)
+	for _, cf := range app.Files {
+		if cf.Id == yamlId && cf.Format != api.FormatYAML {
+			t.Errorf("expect yaml file in app, got %s", cf)
+		}
+	}
+
+	// a file is private unless it is marked public
+	if cf.Public {
+		t.Error("expect a private file by default")
+	}
+	if err = repo.UpdateConfigFilePublic(yamlId, true); err != nil {
+		t.Fatal(err)
+	}
+	cfs, _ = repo.ListConfigFilesBrief()
+	for _, cf := range cfs {
+		if cf.Public != (cf.Id == yamlId) {
+			t.Errorf("expect only file [id=%d] public, got %s", yamlId, cf)
+		}
+	}
+
+	// a modified item marks the associated apps outdated
+	repo.UpdateAppOutdated(appId, false)
+	if cf, _ = repo.RetrieveConfigFileDetail(fileId); cf.Version != 1 {
+		t.Errorf("expect version 1 after inserting, got %d", cf.Version)
+	}
+	diffs, version, err := repo.UpdateConfigFile(fileId, 1, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}, {Name: "port", Value: "3306"}}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "host" || diffs[0].Type != api.DiffModified || version != 2 {
+		t.Errorf("expect host modified at version 2, got %v at %d", diffs, version)
+	}
+
+	// the update on an old version fails with the current version
+	_, version, err = repo.UpdateConfigFile(fileId, 1, []*api.ConfigItem{{Name: "host", Value: "10.0.0.2"}}, "bob")
+	if conflict, ok := err.(*api.VersionConflictError); !ok || conflict.Version != 2 || version != 2 {
+		t.Errorf("expect version conflict at version 2, got %d: %v", version, err)
+	}
+	cf, _ = repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "10.0.0.1", "port", "3306")
+	if app, _ = repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after updating an item")
+	}
+
+	// an unchanged content neither marks the apps outdated nor saves a revision
+	repo.UpdateAppOutdated(appId, false)
+	diffs, version, err = repo.UpdateConfigFile(fileId, 2, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}, {Name: "port", Value: "3306"}}, "alice")
+	if err != nil || len(diffs) != 0 || version != 2 {
+		t.Errorf("expect no change at version 2, got %v at %d: %v", diffs, version, err)
+	}
+	if app, _ = repo.RetrieveAppBrief(appId); app.Outdated != 0 {
+		t.Error("expect app not outdated without any change")
+	}
+	if revisions, _ := repo.ListConfigFileRevisions(fileId); len(revisions) != 2 {
+		t.Errorf("expect 2 revisions, got %v", revisions)
+	}
+
+	// the keys missing from the items are deleted, an added key marks the apps outdated
+	diffs, _, err = repo.UpdateConfigFile(fileId, 0, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}, {Name: "user", Value: "root"}}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 || diffs[0].Name != "port" || diffs[0].Type != api.DiffRemoved || diffs[1].Name != "user" || diffs[1].Type != api.DiffAdded {
+		t.Errorf("expect port removed and user added, got %v", diffs)
+	}
+	cf, _ = repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "10.0.0.1", "user", "root")
+	if app, _ = repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after replacing the items")
+	}
+
+	repo.UpdateAppOutdated(appId, false)
+	if _, _, err = repo.UpdateConfigFile(fileId, 0, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	cf, _ = repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "10.0.0.1")
+	if app, _ = repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after deleting an item")
+	}
+}
+
+func testAssociation(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	otherId := mustInsertApp(t, repo, "other")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1")
+	redisId := mustInsertConfigFile(t, repo, "redis", otherId, "addr", "127.0.0.1:6379")
+	if err := repo.UpdateAppOutdated(otherId, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateAppAssociation(otherId, []int64{fileId}, nil); err != nil {
+		t.Fatal(err)
+	}
+	app, err := repo.RetrieveAppDetail(otherId)
+	if err != nil || len(app.Files) != 2 {
+		t.Fatalf("expect 2 files associated with app [id=%d], got %v: %v", otherId, app, err)
+	}
+	if app.Outdated != 1 {
+		t.Error("expect app outdated after associating a file")
+	}
+	for _, cf := range app.Files {
+		if cf.Id == fileId && (cf.App.Id != appId || cf.App.Name != "demo" || len(cf.Items) != 1) {
+			t.Errorf("expect file [id=%d] in namespace demo with its items, got %s", fileId, cf)
+		}
+	}
+
+	// an existing association isn't added again
+	if err = repo.UpdateAppAssociation(otherId, []int64{fileId, redisId}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if app, _ = repo.RetrieveAppBrief(otherId); len(app.Files) != 2 {
+		t.Errorf("expect 2 files associated with app [id=%d] after associating them again, got %v", otherId, app.Files)
+	}
+	if apps, _ := repo.ListAssociatedApps(fileId); len(apps) != 2 {
+		t.Errorf("expect 2 apps associated with file [id=%d], got %v", fileId, apps)
+	}
+
+	// an item modified in the namespace app marks the apps associated with the file outdated
+	repo.UpdateAppOutdated(otherId, false)
+	_, _, err = repo.UpdateConfigFile(fileId, 0, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app, _ = repo.RetrieveAppBrief(otherId); app.Outdated != 1 {
+		t.Error("expect associated app outdated after updating the file")
+	}
+
+	// add and delete in one update
+	if err = repo.UpdateAppAssociation(otherId, nil, []int64{fileId}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateAppAssociation(appId, []int64{redisId}, []int64{fileId}); err != nil {
+		t.Fatal(err)
+	}
+	app, _ = repo.RetrieveAppBrief(otherId)
+	if len(app.Files) != 1 || app.Files[0].Id != redisId {
+		t.Errorf("expect only file [id=%d] associated with app [id=%d], got %v", redisId, otherId, app.Files)
+	}
+	app, _ = repo.RetrieveAppBrief(appId)
+	if len(app.Files) != 1 || app.Files[0].Id != redisId {
+		t.Errorf("expect only file [id=%d] associated with app [id=%d], got %v", redisId, appId, app.Files)
+	}
+	// the file still exists after its associations are deleted
+	if !repo.ExistsConfigFileById(fileId) {
+		t.Errorf("expect config file [id=%d] exists", fileId)
+	}
+}
+
+func testDelete(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	otherId := mustInsertApp(t, repo, "other")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1")
+	redisId := mustInsertConfigFile(t, repo, "redis", appId, "addr", "127.0.0.1:6379")
+	otherFileId := mustInsertConfigFile(t, repo, "mq", otherId, "addr", "127.0.0.1:5672")
+	if err := repo.UpdateAppAssociation(otherId, []int64{fileId, redisId}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=127.0.0.1", Publisher: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	apps, err := repo.ListAssociatedApps(fileId)
+	if err != nil || len(apps) != 2 || apps[0].Id != appId || apps[1].Id != otherId {
+		t.Errorf("expect apps [%d %d] associated with file [id=%d], got %v: %v", appId, otherId, fileId, apps, err)
+	}
+
+	repo.UpdateAppOutdated(otherId, false)
+	if err = repo.DeleteConfigFile(redisId); err != nil {
+		t.Fatal(err)
+	}
+	if repo.ExistsConfigFileById(redisId) || repo.ExistsConfigFileRevision(redisId, 1) {
+		t.Errorf("expect config file [id=%d] deleted with its revisions", redisId)
+	}
+	app, _ := repo.RetrieveAppBrief(otherId)
+	if len(app.Files) != 2 || app.Outdated != 1 {
+		t.Errorf("expect the associated app outdated without the deleted file, got %v", app)
+	}
+
+	repo.UpdateAppOutdated(otherId, false)
+	if err = repo.DeleteApp(appId); err != nil {
+		t.Fatal(err)
+	}
+	if repo.ExistsAppById(appId) || repo.ExistsAppByName("demo") || repo.ExistsConfigFileById(fileId) {
+		t.Errorf("expect app [id=%d] deleted with the files in its namespace", appId)
+	}
+	if releases, _ := repo.ListReleases(appId); len(releases) != 0 {
+		t.Errorf("expect releases deleted, got %v", releases)
+	}
+	app, _ = repo.RetrieveAppBrief(otherId)
+	if len(app.Files) != 1 || app.Files[0].Id != otherFileId || app.Outdated != 1 {
+		t.Errorf("expect the associated app outdated with only file [id=%d], got %v", otherFileId, app)
+	}
+	// the name can be reused
+	mustInsertApp(t, repo, "demo")
+}
+
+func testConfigItem(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1")
+	otherId := mustInsertConfigFile(t, repo, "cache", appId)
+	if err := repo.UpdateAppOutdated(appId, false); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := repo.InsertConfigItem(&api.ConfigItem{FileId: fileId, Name: "port", Value: "3306", Comment: "mysql port"}, 0, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.ExistsConfigItem(fileId, id) || repo.ExistsConfigItem(otherId, id) {
+		t.Errorf("expect config item [id=%d] exists only in its file", id)
+	}
+	if !repo.ExistsConfigItemByName(fileId, "port") || repo.ExistsConfigItemByName(otherId, "port") {
+		t.Error("expect config item [name=port] exists only in its file")
+	}
+	ci, err := repo.RetrieveConfigItem(id)
+	if err != nil || ci.FileId != fileId || ci.Name != "port" || ci.Value != "3306" || ci.Comment != "mysql port" {
+		t.Errorf("RetrieveConfigItem got %v: %v", ci, err)
+	}
+	if app, _ := repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after inserting an item")
+	}
+	repo.UpdateAppOutdated(appId, false)
+	_, err = repo.UpdateConfigItem(&api.ConfigItem{Id: id, FileId: fileId, Name: "db.port", Value: "3307"}, 0, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, _ := repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "127.0.0.1", "db.port", "3307")
+	if app, _ := repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after updating an item")
+	}
+	repo.UpdateAppOutdated(appId, false)
+	if _, err = repo.DeleteConfigItem(fileId, id, 0, "dave"); err != nil {
+		t.Fatal(err)
+	}
+	if repo.ExistsConfigItem(fileId, id) {
+		t.Errorf("expect config item [id=%d] deleted", id)
+	}
+	if _, err = repo.RetrieveConfigItem(id); err == nil {
+		t.Error("expect error for deleted item")
+	}
+	if app, _ := repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after deleting an item")
+	}
+	revisions, err := repo.ListConfigFileRevisions(fileId)
+	if err != nil || len(revisions) != 4 || revisions[0].Author != "dave" || revisions[1].Author != "carol" || revisions[2].Author != "bob" {
+		t.Errorf("expect a revision for every item change, got %v: %v", revisions, err)
+	}
+	rev, _ := repo.RetrieveConfigFileRevision(fileId, 3)
+	assertItems(t, rev.Items, "host", "127.0.0.1", "db.port", "3307")
+}
+
+func testConfigFileRevision(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1")
+	_, _, err := repo.UpdateConfigFile(fileId, 0, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1", Comment: "new host"}}, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := repo.ListConfigFileRevisions(fileId)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("expect 2 revisions, got %v: %v", revisions, err)
+	}
+	if revisions[0].Revision != 2 || revisions[0].Author != "bob" || revisions[1].Revision != 1 || revisions[1].Author != "alice" {
+		t.Errorf("expect revisions in descending order, got %v", revisions)
+	}
+	if !repo.ExistsConfigFileRevision(fileId, 1) || repo.ExistsConfigFileRevision(fileId, 3) {
+		t.Error("expect revision 1 exists and revision 3 doesn't")
+	}
+	rev, err := repo.RetrieveConfigFileRevision(fileId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertItems(t, rev.Items, "host", "127.0.0.1")
+	rev, _ = repo.RetrieveConfigFileRevision(fileId, 2)
+	if len(rev.Items) != 1 || rev.Items[0].Comment != "new host" {
+		t.Errorf("expect comment saved in revision, got %v", rev.Items)
+	}
+	if _, err = repo.RetrieveConfigFileRevision(fileId, 3); err == nil {
+		t.Error("expect error for unknown revision")
+	}
+}
+
+// testConfigFileRollback replaces the items with the ones of an old revision like the service does.
+func testConfigFileRollback(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1", "port", "3306")
+	otherId := mustInsertConfigFile(t, repo, "cache", appId, "addr", "127.0.0.1:6379")
+	if _, _, err := repo.UpdateConfigFile(fileId, 0, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}, {Name: "user", Value: "root"}}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := repo.RetrieveConfigFileRevision(fileId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.UpdateAppOutdated(appId, false)
+	diffs, version, err := repo.UpdateConfigFile(fileId, 0, rev.Items, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 3 || version != 3 {
+		t.Errorf("expect host and port restored and user removed at version 3, got %v at %d", diffs, version)
+	}
+	cf, _ := repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "127.0.0.1", "port", "3306")
+	if app, _ := repo.RetrieveAppBrief(appId); app.Outdated != 1 {
+		t.Error("expect app outdated after rolling back")
+	}
+	revisions, err := repo.ListConfigFileRevisions(fileId)
+	if err != nil || len(revisions) != 3 || revisions[0].Revision != 3 || revisions[0].Author != "carol" {
+		t.Fatalf("expect the rollback saved as revision 3, got %v: %v", revisions, err)
+	}
+	rev, _ = repo.RetrieveConfigFileRevision(fileId, 3)
+	assertItems(t, rev.Items, "host", "127.0.0.1", "port", "3306")
+	// the old revisions are kept
+	rev, _ = repo.RetrieveConfigFileRevision(fileId, 2)
+	assertItems(t, rev.Items, "host", "10.0.0.1", "user", "root")
+
+	// the revisions belong to their files
+	if revisions, _ = repo.ListConfigFileRevisions(otherId); len(revisions) != 1 || revisions[0].FileId != otherId {
+		t.Errorf("expect only the revision of file [id=%d], got %v", otherId, revisions)
+	}
+	if repo.ExistsConfigFileRevision(otherId, 2) {
+		t.Errorf("expect no revision 2 of file [id=%d]", otherId)
+	}
+	if revisions, err = repo.ListConfigFileRevisions(fileId + 1000); err != nil || len(revisions) != 0 {
+		t.Errorf("expect no revision of unknown file, got %v: %v", revisions, err)
+	}
+}
+
+func testConfigFileVersion(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	fileId := mustInsertConfigFile(t, repo, "db", appId, "host", "127.0.0.1")
+	// the item changes produce new versions as well
+	id, version, err := repo.InsertConfigItem(&api.ConfigItem{FileId: fileId, Name: "port", Value: "3306"}, 1, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf, _ := repo.RetrieveConfigFileDetail(fileId); cf.Version != 2 || version != 2 {
+		t.Errorf("expect version 2 after inserting an item, got %d and %d", cf.Version, version)
+	}
+	// the item changes on another version conflict
+	if _, _, err = repo.InsertConfigItem(&api.ConfigItem{FileId: fileId, Name: "user", Value: "root"}, 1, "bob"); !isVersionConflict(err, 2) {
+		t.Errorf("expect version conflict at version 2 when inserting an item, got %v", err)
+	}
+	if _, err = repo.UpdateConfigItem(&api.ConfigItem{Id: id, FileId: fileId, Name: "port", Value: "3307"}, 1, "bob"); !isVersionConflict(err, 2) {
+		t.Errorf("expect version conflict at version 2 when updating an item, got %v", err)
+	}
+	if _, err = repo.DeleteConfigItem(fileId, id, 3, "bob"); !isVersionConflict(err, 2) {
+		t.Errorf("expect version conflict at version 2 when deleting an item, got %v", err)
+	}
+	for _, version := range []int64{1, 3} {
+		diffs, current, err := repo.UpdateConfigFile(fileId, version, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}}, "carol")
+		conflict, ok := err.(*api.VersionConflictError)
+		if !ok || conflict.Version != 2 || current != 2 || len(diffs) != 0 {
+			t.Errorf("expect version conflict at version 2 when updating version %d, got %v at %d: %v", version, diffs, current, err)
+		}
+	}
+	// nothing is changed by the conflicted updates
+	cf, _ := repo.RetrieveConfigFileDetail(fileId)
+	assertItems(t, cf.Items, "host", "127.0.0.1", "port", "3306")
+	if revisions, _ := repo.ListConfigFileRevisions(fileId); len(revisions) != 2 {
+		t.Errorf("expect no revision saved by the conflicted updates, got %v", revisions)
+	}
+	if _, version, err := repo.UpdateConfigFile(fileId, 2, []*api.ConfigItem{{Name: "host", Value: "10.0.0.1"}}, "carol"); err != nil || version != 3 {
+		t.Errorf("expect version 3 after updating the current version, got %d: %v", version, err)
+	}
+	if id, version, err = repo.InsertConfigItem(&api.ConfigItem{FileId: fileId, Name: "port", Value: "3306"}, 3, "bob"); err != nil || version != 4 {
+		t.Fatalf("expect version 4 after inserting an item on the current version, got %d: %v", version, err)
+	}
+	if version, err = repo.UpdateConfigItem(&api.ConfigItem{Id: id, FileId: fileId, Name: "port", Value: "3307"}, 4, "bob"); err != nil || version != 5 {
+		t.Errorf("expect version 5 after updating an item on the current version, got %d: %v", version, err)
+	}
+	if version, err = repo.DeleteConfigItem(fileId, id, 5, "bob"); err != nil || version != 6 {
+		t.Errorf("expect version 6 after deleting an item on the current version, got %d: %v", version, err)
+	}
+}
+
+func isVersionConflict(err error, version int64) bool {
+	conflict, ok := err.(*api.VersionConflictError)
+	return ok && conflict.Version == version
+}
+
+func testRelease(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	if release, err := repo.RetrieveLatestRelease(appId); err != nil || release != nil {
+		t.Errorf("expect no release, got %v: %v", release, err)
+	}
+	first, err := repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=127.0.0.1", Revision: 5, Publisher: "alice", Comment: "init"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := &api.GrayRule{IPs: []string{"10.0.1.*"}}
+	gray, err := repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=10.0.0.1", Revision: 6, Publisher: "bob", Status: api.ReleaseGray, Rule: rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.ExistsRelease(appId, first) || repo.ExistsRelease(appId+1000, first) {
+		t.Error("expect release exists only in its app")
+	}
+	release, err := repo.RetrieveRelease(first)
+	if err != nil || release.Content != "[db]\nhost=127.0.0.1" || release.Revision != 5 || release.Publisher != "alice" || release.Comment != "init" {
+		t.Errorf("RetrieveRelease got %v: %v", release, err)
+	}
+	if release, _ = repo.RetrieveLatestRelease(appId); release == nil || release.Id != first {
+		t.Errorf("expect the latest normal release [id=%d], got %v", first, release)
+	}
+	release, err = repo.RetrieveActiveGrayRelease(appId)
+	if err != nil || release == nil || release.Id != gray || release.Rule == nil || release.Rule.IPs[0] != "10.0.1.*" {
+		t.Errorf("RetrieveActiveGrayRelease got %v: %v", release, err)
+	}
+	if err = repo.UpdateReleaseStatus(gray, api.ReleaseAbandoned); err != nil {
+		t.Fatal(err)
+	}
+	if release, _ = repo.RetrieveActiveGrayRelease(appId); release != nil {
+		t.Errorf("expect no active gray release, got %v", release)
+	}
+	releases, err := repo.ListReleases(appId)
+	if err != nil || len(releases) != 2 || releases[0].Id != gray || releases[0].Status != api.ReleaseAbandoned {
+		t.Errorf("ListReleases got %v: %v", releases, err)
+	}
+	if err = repo.UpdateReleaseRevision(first, 7); err != nil {
+		t.Fatal(err)
+	}
+	if release, _ = repo.RetrieveRelease(first); release.Revision != 7 {
+		t.Errorf("expect revision 7, got %d", release.Revision)
+	}
+}
+
+// testReleaseRollback records a rollback as a new release like the service does.
+func testReleaseRollback(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	otherId := mustInsertApp(t, repo, "other")
+	first, err := repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=127.0.0.1", Revision: 5, Publisher: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=10.0.0.1", Revision: 6, Publisher: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertRelease(&api.Release{AppId: otherId, Content: "[mq]\naddr=127.0.0.1:5672", Revision: 7, Publisher: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	release, _ := repo.RetrieveRelease(first)
+	rollback, err := repo.InsertRelease(&api.Release{AppId: appId, Content: release.Content, Publisher: "carol", Comment: fmt.Sprintf("Rollback to release [id=%d]", first)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateReleaseRevision(rollback, 8); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := repo.RetrieveLatestRelease(appId)
+	if err != nil || latest == nil || latest.Id != rollback || latest.Content != "[db]\nhost=127.0.0.1" || latest.Revision != 8 || latest.Publisher != "carol" {
+		t.Errorf("expect the rollback as the latest release, got %v: %v", latest, err)
+	}
+	releases, err := repo.ListReleases(appId)
+	if err != nil || len(releases) != 3 || releases[0].Id != rollback || releases[2].Id != first {
+		t.Errorf("expect the releases of the app from the newest, got %v: %v", releases, err)
+	}
+	if repo.ExistsRelease(otherId, first) {
+		t.Errorf("expect release [id=%d] not in app [id=%d]", first, otherId)
+	}
+
+	// a failed rollback isn't the latest release
+	failed, err := repo.InsertRelease(&api.Release{AppId: appId, Content: "[db]\nhost=10.0.0.1", Publisher: "dave"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateReleaseStatus(failed, api.ReleaseFailed); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _ = repo.RetrieveLatestRelease(appId); latest == nil || latest.Id != rollback {
+		t.Errorf("expect release [id=%d] still the latest, got %v", rollback, latest)
+	}
+	if release, _ = repo.RetrieveRelease(failed); release.Status != api.ReleaseFailed {
+		t.Errorf("expect release [id=%d] failed, got %v", failed, release)
+	}
+}
+
+func mustInsertApp(t *testing.T, repo server.Repository, name string) int64 {
+	id, err := repo.InsertApp(&api.App{Name: name, Outdated: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// mustInsertConfigFile inserts the file with the name/value pairs by alice.
+func mustInsertConfigFile(t *testing.T, repo server.Repository, name string, namespaceId int64, kvs ...string) int64 {
+	items := make([]*api.ConfigItem, 0, len(kvs)/2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		items = append(items, &api.ConfigItem{Name: kvs[i], Value: kvs[i+1]})
+	}
+	id, err := repo.InsertConfigFileWithItems(&api.ConfigFile{Name: name, NamespaceId: namespaceId, Items: items}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// assertItems checks the items are the name/value pairs in order.
+func assertItems(t *testing.T, items []*api.ConfigItem, kvs ...string) {
+	t.Helper()
+	if len(items)*2 != len(kvs) {
+		t.Errorf("expect items %v, got %v", kvs, items)
+		return
+	}
+	for i, item := range items {
+		if item.Name != kvs[i*2] || item.Value != kvs[i*2+1] {
+			t.Errorf("expect item %s=%s, got %s", kvs[i*2], kvs[i*2+1], item)
+		}
+	}
+}
+
+func testAuditEvent(t *testing.T, repo server.Repository) {
+	events := []*api.AuditEvent{
+		{Actor: "alice", Action: api.ActionCreateApp, AppId: 1, Target: "app [name=demo]", After: "name=demo", RequestId: "r1"},
+		{Actor: "bob", Action: api.ActionCreateConfigFile, AppId: 1, Target: "config file [id=2] [name=db]", After: "items=2", RequestId: "r2"},
+		{Actor: "alice", Action: api.ActionCreateApp, AppId: 3, Target: "app [name=other]", After: "name=other", RequestId: "r3"},
+	}
+	for _, event := range events {
+		if _, err := repo.InsertAuditEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hour := time.Hour
+	cases := []struct {
+		filter     *api.AuditFilter
+		requestIds []string
+	}{
+		{&api.AuditFilter{}, []string{"r3", "r2", "r1"}},
+		{&api.AuditFilter{AppId: 1}, []string{"r2", "r1"}},
+		{&api.AuditFilter{Actor: "alice"}, []string{"r3", "r1"}},
+		{&api.AuditFilter{AppId: 1, Actor: "alice"}, []string{"r1"}},
+		{&api.AuditFilter{Limit: 2}, []string{"r3", "r2"}},
+		{&api.AuditFilter{Since: time.Now().Add(-hour), Until: time.Now().Add(hour)}, []string{"r3", "r2", "r1"}},
+		{&api.AuditFilter{Since: time.Now().Add(hour)}, []string{}},
+		{&api.AuditFilter{Until: time.Now().Add(-hour)}, []string{}},
+	}
+	for _, c := range cases {
+		got, err := repo.QueryAuditEvents(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0, len(got))
+		for _, event := range got {
+			ids = append(ids, event.RequestId)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(c.requestIds) {
+			t.Errorf("QueryAuditEvents %+v expect %v, got %v", c.filter, c.requestIds, ids)
+		}
+	}
+	got, _ := repo.QueryAuditEvents(&api.AuditFilter{Limit: 1})
+	if e := got[0]; e.Actor != "alice" || e.Action != api.ActionCreateApp || e.Target != "app [name=other]" || e.After != "name=other" || e.Ctime.IsZero() {
+		t.Errorf("unexpected event %s", e)
+	}
+}
+
+func testWebhook(t *testing.T, repo server.Repository) {
+	appId := mustInsertApp(t, repo, "demo")
+	allId, err := repo.InsertWebhook(&api.Webhook{Url: "http://127.0.0.1/all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appHookId, err := repo.InsertWebhook(&api.Webhook{AppId: appId, Url: "http://127.0.0.1/demo", Secret: "secret", Events: []string{api.EventAppPublished, api.EventAppOutdated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertWebhook(&api.Webhook{AppId: appId + 1000, Url: "http://127.0.0.1/none"}); err == nil {
+		t.Error("expect error when subscribing an unknown app")
+	}
+	if !repo.ExistsWebhook(allId) || repo.ExistsWebhook(appHookId+1000) {
+		t.Error("ExistsWebhook got unexpected result")
+	}
+	hooks, err := repo.ListWebhooks()
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("ListWebhooks got %v: %v", hooks, err)
+	}
+	if h := hooks[1]; h.Id != appHookId || h.AppId != appId || h.Secret != "secret" || fmt.Sprint(h.Events) != "[app.published app.outdated]" || h.Ctime.IsZero() {
+		t.Errorf("unexpected webhook %s", h)
+	}
+	if len(hooks[0].Events) != 0 {
+		t.Errorf("expect no event of the webhook subscribing all, got %v", hooks[0].Events)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivery := &api.WebhookDelivery{WebhookId: allId, Event: api.EventAppPublished, Payload: "{}", Attempt: attempt, StatusCode: 500, Response: "oops", Duration: 20 * time.Millisecond}
+		if _, err = repo.InsertWebhookDelivery(delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = repo.InsertWebhookDelivery(&api.WebhookDelivery{WebhookId: appHookId, Event: api.EventAppOutdated, Attempt: 1, Error: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertWebhookDelivery(&api.WebhookDelivery{WebhookId: appHookId + 1000, Attempt: 1}); err == nil {
+		t.Error("expect error when delivering to an unknown webhook")
+	}
+	deliveries, err := repo.QueryWebhookDeliveries(allId, 2)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Attempt != 3 || deliveries[1].Attempt != 2 {
+		t.Fatalf("expect the latest 2 deliveries from the newest, got %v: %v", deliveries, err)
+	}
+	if d := deliveries[0]; d.StatusCode != 500 || d.Response != "oops" || d.Payload != "{}" || d.Duration != 20*time.Millisecond || d.Ctime.IsZero() {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+
+	if err = repo.DeleteWebhook(allId); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, _ = repo.QueryWebhookDeliveries(allId, 0); repo.ExistsWebhook(allId) || len(deliveries) != 0 {
+		t.Errorf("expect the webhook deleted with its deliveries, got %v", deliveries)
+	}
+	// the webhooks are deleted with the app
+	if err = repo.DeleteApp(appId); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, _ = repo.QueryWebhookDeliveries(appHookId, 0); repo.ExistsWebhook(appHookId) || len(deliveries) != 0 {
+		t.Errorf("expect the webhook of the deleted app deleted, got %v", deliveries)
+	}
+}
@@ -0,0 +1,65 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes registers the handlers of the service in the /v1 group, except the ones of RegisterClientRoutes.
+func RegisterRoutes(v1 *gin.RouterGroup, service api.Service) {
+	v1.POST("/apps", CreateApp(service))
+	v1.PUT("/apps", PublishApp(service))
+	v1.GET("/apps/:name", ViewApp(service))
+	v1.PUT("/apps/:name", UpdateApp(service))
+	v1.DELETE("/apps/:name", DeleteApp(service))
+	v1.DELETE("/apps/:name/config-files/:file_id", DeleteAppAssociation(service))
+	v1.GET("/apps/:name/diff", DiffApp(service))
+	v1.GET("/apps/:name/releases", ListReleases(service))
+	v1.POST("/apps/:name/releases/:id/rollback", RollbackRelease(service))
+	v1.PUT("/apps/:name/gray", PromoteGrayRelease(service))
+	v1.DELETE("/apps/:name/gray", AbandonGrayRelease(service))
+
+	v1.GET("/config-files", ListConfigFiles(service))
+	v1.POST("/config-files", CreateConfigFile(service))
+	v1.GET("/config-files/:file_id", ViewConfigFile(service))
+	v1.PUT("/config-files/:file_id", UpdateConfigFile(service))
+	v1.DELETE("/config-files/:file_id", DeleteConfigFile(service))
+	v1.GET("/config-files/:file_id/apps", ListConfigFileApps(service))
+	v1.PUT("/config-files/:file_id/public", UpdateConfigFilePublic(service))
+	v1.GET("/config-files/:file_id/items", ListConfigItems(service))
+	v1.POST("/config-files/:file_id/items", CreateConfigItem(service))
+	v1.GET("/config-files/:file_id/items/:item_id", ViewConfigItem(service))
+	v1.PUT("/config-files/:file_id/items/:item_id", UpdateConfigItem(service))
+	v1.DELETE("/config-files/:file_id/items/:item_id", DeleteConfigItem(service))
+	v1.GET("/config-files/:file_id/revisions", ListConfigFileRevisions(service))
+	v1.GET("/config-files/:file_id/revisions/:revision", ViewConfigFileRevision(service))
+	v1.POST("/config-files/:file_id/revisions/:revision/rollback", RollbackConfigFile(service))
+
+	v1.GET("/audit", ListAuditEvents(service))
+
+	v1.GET("/webhooks", ListWebhooks(service))
+	v1.POST("/webhooks", CreateWebhook(service))
+	v1.DELETE("/webhooks/:webhook_id", DeleteWebhook(service))
+	v1.GET("/webhooks/:webhook_id/deliveries", ListWebhookDeliveries(service))
+}
+
+// RegisterClientRoutes registers the read only handlers the clients call in the /v1 group,
+// which is authenticated by the secret of the clients instead of the one of the console.
+func RegisterClientRoutes(v1 *gin.RouterGroup, service api.Service) {
+	v1.GET("/apps/:name/config", PollAppConfig(service))
+	v1.GET("/watchers", QueryWatcher(service))
+}
@@ -16,12 +16,11 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/cflion/cflion/pkg/common"
 	"github.com/cflion/cflion/pkg/log"
 	"github.com/cflion/cflion/pkg/manager/api"
-	"github.com/coreos/etcd/clientv3"
-	"github.com/spf13/viper"
-	"strings"
 	"time"
 )
 
@@ -34,17 +33,105 @@ type Repository interface {
 	RetrieveAppDetail(id int64) (*api.App, error)
 	UpdateAppAssociation(appId int64, addFileIds []int64, delFileIds []int64) error
 	UpdateAppOutdated(id int64, outdated bool) error
+	// DeleteApp deletes the app with its associations, releases, webhooks and the config files in its namespace.
+	DeleteApp(id int64) error
+
+	InsertRelease(release *api.Release) (int64, error)
+	ListReleases(appId int64) ([]*api.Release, error)
+	ExistsRelease(appId int64, id int64) bool
+	RetrieveRelease(id int64) (*api.Release, error)
+	// RetrieveLatestRelease returns nil if the app has never been published.
+	RetrieveLatestRelease(appId int64) (*api.Release, error)
+	// RetrieveActiveGrayRelease returns nil if the app has no active gray release.
+	RetrieveActiveGrayRelease(appId int64) (*api.Release, error)
+	UpdateReleaseStatus(id int64, status byte) error
+	UpdateReleaseRevision(id int64, revision int64) error
 
 	ListConfigFilesBrief() ([]*api.ConfigFile, error)
 	ExistsConfigFileByNameAndNamespaceId(filename string, namespaceId int64) bool
 	ExistsConfigFileById(id int64) bool
-	InsertConfigFileWithItems(cf *api.ConfigFile) (int64, error)
+	InsertConfigFileWithItems(cf *api.ConfigFile, author string) (int64, error)
 	RetrieveConfigFileDetail(id int64) (*api.ConfigFile, error)
-	UpdateConfigFile(fileId int64, items []*api.ConfigItem) error
+	// UpdateConfigFile replaces the items of the file and returns the changes with the version after updating,
+	// the file gets a new revision and the apps associated with the file are marked outdated if anything changes.
+	// It fails with *api.VersionConflictError if the version isn't the current one, version 0 matches any.
+	UpdateConfigFile(fileId int64, version int64, items []*api.ConfigItem, author string) ([]*api.ConfigItemDiff, int64, error)
+	// DeleteConfigFile deletes the file with its items, revisions and associations,
+	// the apps associated with the file are marked outdated.
+	DeleteConfigFile(id int64) error
+	ListAssociatedApps(fileId int64) ([]*api.App, error)
+	UpdateConfigFilePublic(id int64, public bool) error
+
+	ExistsConfigItem(fileId int64, id int64) bool
+	ExistsConfigItemByName(fileId int64, name string) bool
+	RetrieveConfigItem(id int64) (*api.ConfigItem, error)
+	// InsertConfigItem, UpdateConfigItem and DeleteConfigItem produce a new revision of the file
+	// and mark the apps associated with the file outdated, they return the version after changing.
+	// They fail with *api.VersionConflictError if the version isn't the current one, version 0 matches any.
+	InsertConfigItem(item *api.ConfigItem, version int64, author string) (int64, int64, error)
+	UpdateConfigItem(item *api.ConfigItem, version int64, author string) (int64, error)
+	DeleteConfigItem(fileId int64, id int64, version int64, author string) (int64, error)
+
+	ListConfigFileRevisions(fileId int64) ([]*api.ConfigFileRevision, error)
+	ExistsConfigFileRevision(fileId int64, revision int64) bool
+	RetrieveConfigFileRevision(fileId int64, revision int64) (*api.ConfigFileRevision, error)
+
+	InsertAuditEvent(event *api.AuditEvent) (int64, error)
+	QueryAuditEvents(filter *api.AuditFilter) ([]*api.AuditEvent, error)
+
+	InsertWebhook(hook *api.Webhook) (int64, error)
+	ExistsWebhook(id int64) bool
+	ListWebhooks() ([]*api.Webhook, error)
+	// DeleteWebhook deletes the webhook with its deliveries.
+	DeleteWebhook(id int64) error
+	InsertWebhookDelivery(delivery *api.WebhookDelivery) (int64, error)
+	// QueryWebhookDeliveries returns the deliveries of the webhook from the newest, limit 0 means no limit.
+	QueryWebhookDeliveries(webhookId int64, limit int) ([]*api.WebhookDelivery, error)
+}
+
+// Publisher stores the published config of apps, which is watched by the clients.
+type Publisher interface {
+	// Put puts the value of the key and returns the revision after putting.
+	Put(key string, value string) (int64, error)
+	// Get returns the value of the key and the current revision, the value is empty if the key doesn't exist.
+	Get(key string) (string, int64, error)
+	// Delete deletes the key and returns the revision after deleting.
+	Delete(key string) (int64, error)
+	// Txn applies the ops atomically and returns the revision after applying, a key must not appear in two ops.
+	Txn(ops []*PublishOp) (int64, error)
+	// Watch sends the events of the key after the revision until the context is done,
+	// the channel is closed after an event with Err.
+	Watch(ctx context.Context, key string, revision int64) <-chan *PublishEvent
+	// WatchPrefix is Watch of all the keys with the prefix, the events are sent in the order of their revisions.
+	WatchPrefix(ctx context.Context, prefix string, revision int64) <-chan *PublishEvent
+	Close() error
+}
+
+// PublishOp puts the value of the key, or deletes the key if Delete is true.
+type PublishOp struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// PublishEvent is a change of a published key.
+type PublishEvent struct {
+	Key      string
+	Value    string
+	Revision int64
+	Deleted  bool
+	Err      error
 }
 
 type ServiceImpl struct {
-	Repo Repository
+	Repo      Repository
+	Publisher Publisher
+	// Layout is the publish layout of apps, api.LayoutBlob if it's empty
+	Layout string
+	// Notifier notifies the webhooks of the events, no webhook is notified if it's nil
+	Notifier *Notifier
+	// Watcher wakes up the long polling requests when the apps change
+	Watcher *ConfigWatcher
 }
 
 func (service *ServiceImpl) ListApps() ([]map[string]interface{}, error) {
@@ -63,9 +150,17 @@ func (service *ServiceImpl) GetAppByName(name string) (*api.App, error) {
 	return service.Repo.GetAppByName(name)
 }
 
-func (service *ServiceImpl) CreateApp(name string) (int64, error) {
+func (service *ServiceImpl) CreateApp(name string, operator *api.Operator) (int64, error) {
 	app := &api.App{Name: name, Outdated: 1}
-	return service.Repo.InsertApp(app)
+	id, err := service.Repo.InsertApp(app)
+	if err != nil {
+		return id, err
+	}
+	service.audit(operator, api.ActionCreateApp, id, fmt.Sprintf("app [name=%s]", name), "", fmt.Sprintf("name=%s", name))
+	// the new app is outdated until it's published
+	app.Id = id
+	service.notify(api.EventAppOutdated, api.ActionCreateApp, app, operator, nil)
+	return id, nil
 }
 
 func (service *ServiceImpl) ViewApp(id int64) (map[string]interface{}, error) {
@@ -76,47 +171,561 @@ func (service *ServiceImpl) ViewApp(id int64) (map[string]interface{}, error) {
 	return app.Brief(), nil
 }
 
-func (service *ServiceImpl) UpdateAppAssociation(id int64, fileIds []int64) error {
+func (service *ServiceImpl) UpdateAppAssociation(id int64, fileIds []int64, operator *api.Operator) error {
 	fileIds = common.DistinctInt64Slice(fileIds)
 	cg, err := service.Repo.RetrieveAppBrief(id)
 	if err != nil {
 		return err
 	}
-	curFileIds := make([]int64, 0, len(cg.Files))
-	for _, cf := range cg.Files {
-		curFileIds = append(curFileIds, cf.Id)
-	}
+	curFileIds := fileIdsOf(cg)
 	addFileIds, delFileIds := common.DiffTwoInt64Slice(fileIds, curFileIds)
-	return service.Repo.UpdateAppAssociation(id, addFileIds, delFileIds)
+	if err = service.checkAssociation(id, addFileIds); err != nil {
+		return err
+	}
+	if err = service.Repo.UpdateAppAssociation(id, addFileIds, delFileIds); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionUpdateAppAssociation, id, fmt.Sprintf("app [name=%s]", cg.Name),
+		fmt.Sprintf("files=%v", curFileIds), fmt.Sprintf("files=%v added=%v removed=%v", fileIds, addFileIds, delFileIds))
+	if len(addFileIds) > 0 || len(delFileIds) > 0 {
+		service.notify(api.EventAppOutdated, api.ActionUpdateAppAssociation, cg, operator,
+			map[string]interface{}{"files": fileIds, "added": addFileIds, "removed": delFileIds})
+	}
+	return nil
 }
 
-func (service *ServiceImpl) PublishApp(id int64) error {
-	app, err := service.Repo.RetrieveAppDetail(id)
+// checkAssociation checks the files exist, and are public or in the namespace of the app.
+func (service *ServiceImpl) checkAssociation(appId int64, fileIds []int64) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
+	cfs, err := service.Repo.ListConfigFilesBrief()
 	if err != nil {
 		return err
 	}
-	value := app.ConfigFmt()
-	etcdEndpoints := viper.GetStringSlice("etcd.endpoints")
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   etcdEndpoints,
-		DialTimeout: 5 * time.Second,
-	})
+	files := make(map[int64]*api.ConfigFile, len(cfs))
+	for _, cf := range cfs {
+		files[cf.Id] = cf
+	}
+	for _, fileId := range fileIds {
+		cf, ok := files[fileId]
+		if !ok {
+			return &api.AssociationError{FileId: fileId, Reason: "doesn't exist"}
+		}
+		if !cf.Public && cf.NamespaceId != appId {
+			return &api.AssociationError{FileId: fileId, Reason: fmt.Sprintf("is private in namespace [%s]", cf.Namespace())}
+		}
+	}
+	return nil
+}
+
+func (service *ServiceImpl) ExistsAppAssociation(appId int64, fileId int64) bool {
+	app, err := service.Repo.RetrieveAppBrief(appId)
+	if err != nil {
+		return false
+	}
+	for _, cf := range app.Files {
+		if cf.Id == fileId {
+			return true
+		}
+	}
+	return false
+}
+
+func (service *ServiceImpl) DeleteAppAssociation(appId int64, fileId int64, operator *api.Operator) error {
+	app, err := service.Repo.RetrieveAppBrief(appId)
+	if err != nil {
+		return err
+	}
+	if err = service.Repo.UpdateAppAssociation(appId, nil, []int64{fileId}); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionDeleteAppAssociation, appId, fmt.Sprintf("app [name=%s]", app.Name),
+		fmt.Sprintf("files=%v", fileIdsOf(app)), fmt.Sprintf("removed=%d", fileId))
+	service.notify(api.EventAppOutdated, api.ActionDeleteAppAssociation, app, operator,
+		map[string]interface{}{"removed": []int64{fileId}})
+	return nil
+}
+
+// fileIdsOf returns the ids of the files associated with the app.
+func fileIdsOf(app *api.App) []int64 {
+	fileIds := make([]int64, 0, len(app.Files))
+	for _, cf := range app.Files {
+		fileIds = append(fileIds, cf.Id)
+	}
+	return fileIds
+}
+
+// DeleteApp removes the app from the publisher and then deletes it with the config files in its namespace,
+// so the keys are never left published after the app is gone and a failed deletion can be retried.
+// The other apps associated with the deleted files are outdated and notified.
+func (service *ServiceImpl) DeleteApp(id int64, operator *api.Operator) error {
+	app, err := service.Repo.RetrieveAppBrief(id)
+	if err != nil {
+		return err
+	}
+	consumers, err := service.ListAppConsumers(id)
+	if err != nil {
+		return err
+	}
+	manifest, err := service.manifest(app)
 	if err != nil {
-		log.Errorf("Connect to etcd [%s] error: %s", etcdEndpoints, err)
 		return err
 	}
-	defer cli.Close()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt("etcd.requestTimeout"))*time.Second)
-	_, err = cli.Put(ctx, app.Key(), value)
-	cancel()
+	ops := make([]*PublishOp, 0, 8)
+	for _, key := range []string{app.Key(), app.GrayKey(), app.ManifestKey()} {
+		ops = append(ops, &PublishOp{Key: key, Delete: true})
+	}
+	if manifest != nil {
+		for _, file := range manifest.Files {
+			ops = append(ops, &PublishOp{Key: file.Key, Delete: true})
+		}
+	}
+	if _, err = service.Publisher.Txn(ops); err != nil {
+		return err
+	}
+	if err = service.Repo.DeleteApp(id); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionDeleteApp, id, fmt.Sprintf("app [name=%s]", app.Name),
+		fmt.Sprintf("files=%v", fileIdsOf(app)), "")
+	service.notifyOutdated(consumers, api.ActionDeleteApp, operator, map[string]interface{}{"deleted_app": app.Name})
+	return nil
+}
+
+func (service *ServiceImpl) ListAppConsumers(id int64) ([]*api.App, error) {
+	cfs, err := service.Repo.ListConfigFilesBrief()
 	if err != nil {
-		log.Errorf("Put [key=%s] [value=%s] into etcd error: %s", app.Key(), value, err)
+		return nil, err
+	}
+	consumers := make([]*api.App, 0, 8)
+	seen := make(map[int64]struct{})
+	for _, cf := range cfs {
+		if cf.NamespaceId != id {
+			continue
+		}
+		apps, err := service.Repo.ListAssociatedApps(cf.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range apps {
+			if _, ok := seen[app.Id]; !ok && app.Id != id {
+				seen[app.Id] = struct{}{}
+				consumers = append(consumers, app)
+			}
+		}
+	}
+	return consumers, nil
+}
+
+// PublishApp publishes the current config of the app to all the instances, which ends the active gray release.
+func (service *ServiceImpl) PublishApp(id int64, checksum string, operator *api.Operator, comment string) error {
+	app, err := service.Repo.RetrieveAppDetail(id)
+	if err != nil {
+		return err
+	}
+	if err = checkChecksum(app, checksum); err != nil {
+		return err
+	}
+	latest, err := service.Repo.RetrieveLatestRelease(id)
+	if err != nil {
+		return err
+	}
+	release := &api.Release{AppId: id, Content: app.ConfigFmt(), Publisher: operator.Name, Comment: comment}
+	if err = service.publish(app, release); err != nil {
+		return err
+	}
+	before := "release=none"
+	if latest != nil {
+		before = fmt.Sprintf("release=%d revision=%d", latest.Id, latest.Revision)
+	}
+	service.audit(operator, api.ActionPublishApp, id, fmt.Sprintf("app [name=%s]", app.Name),
+		before, fmt.Sprintf("release=%d revision=%d", release.Id, release.Revision))
+	service.notify(api.EventAppPublished, api.ActionPublishApp, app, operator,
+		map[string]interface{}{"release_id": release.Id, "revision": release.Revision, "comment": comment})
+	if err = service.endGrayRelease(app, api.ReleaseAbandoned); err != nil {
 		return err
 	}
 	err = service.Repo.UpdateAppOutdated(id, false)
 	return err
 }
 
+// PublishAppGray publishes the current config of the app to the instances matching the rule,
+// which replaces the active gray release.
+func (service *ServiceImpl) PublishAppGray(id int64, rule *api.GrayRule, checksum string, operator *api.Operator, comment string) error {
+	app, err := service.Repo.RetrieveAppDetail(id)
+	if err != nil {
+		return err
+	}
+	if err = checkChecksum(app, checksum); err != nil {
+		return err
+	}
+	old, err := service.Repo.RetrieveActiveGrayRelease(id)
+	if err != nil {
+		return err
+	}
+	content := app.ConfigFmt()
+	value, err := json.Marshal(&api.GrayRelease{Content: content, Rule: rule})
+	if err != nil {
+		return err
+	}
+	revision, err := service.Publisher.Put(app.GrayKey(), string(value))
+	if err != nil {
+		return err
+	}
+	release := &api.Release{AppId: id, Content: content, Revision: revision, Publisher: operator.Name, Comment: comment, Status: api.ReleaseGray, Rule: rule}
+	if release.Id, err = service.Repo.InsertRelease(release); err != nil {
+		return err
+	}
+	before := "gray=none"
+	if old != nil {
+		before = fmt.Sprintf("gray=%d", old.Id)
+		if err = service.Repo.UpdateReleaseStatus(old.Id, api.ReleaseAbandoned); err != nil {
+			return err
+		}
+	}
+	service.audit(operator, api.ActionPublishAppGray, id, fmt.Sprintf("app [name=%s]", app.Name),
+		before, fmt.Sprintf("gray=%d revision=%d ips=%v instances=%v", release.Id, revision, rule.IPs, rule.Instances))
+	service.notify(api.EventAppPublished, api.ActionPublishAppGray, app, operator,
+		map[string]interface{}{"release_id": release.Id, "revision": revision, "comment": comment, "gray": rule})
+	return nil
+}
+
+// checkChecksum fails if the checksum isn't empty and differs from the one of the current config of the app,
+// so the config is published only if it's the one which the checksum is taken from.
+func checkChecksum(app *api.App, checksum string) error {
+	if current := app.Checksum(); len(checksum) > 0 && checksum != current {
+		return &api.ChecksumConflictError{Checksum: current}
+	}
+	return nil
+}
+
+func (service *ServiceImpl) ExistsGrayRelease(appId int64) bool {
+	gray, err := service.Repo.RetrieveActiveGrayRelease(appId)
+	return err == nil && gray != nil
+}
+
+// PromoteGrayRelease publishes the content of the active gray release to all the instances.
+func (service *ServiceImpl) PromoteGrayRelease(appId int64, checksum string, operator *api.Operator) error {
+	gray, err := service.Repo.RetrieveActiveGrayRelease(appId)
+	if err != nil {
+		return err
+	}
+	if gray == nil {
+		return fmt.Errorf("app [id=%d] has no active gray release", appId)
+	}
+	if current := api.Checksum(gray.Content); len(checksum) > 0 && checksum != current {
+		return &api.ChecksumConflictError{Checksum: current}
+	}
+	app, err := service.Repo.RetrieveAppDetail(appId)
+	if err != nil {
+		return err
+	}
+	release := &api.Release{
+		AppId:     appId,
+		Content:   gray.Content,
+		Publisher: operator.Name,
+		Comment:   fmt.Sprintf("Promote gray release [id=%d]", gray.Id),
+	}
+	if err = service.publish(app, release); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionPromoteGrayRelease, appId, fmt.Sprintf("app [name=%s]", app.Name),
+		fmt.Sprintf("gray=%d", gray.Id), fmt.Sprintf("release=%d revision=%d", release.Id, release.Revision))
+	service.notify(api.EventAppPublished, api.ActionPromoteGrayRelease, app, operator,
+		map[string]interface{}{"release_id": release.Id, "revision": release.Revision, "comment": release.Comment})
+	if err = service.endGrayRelease(app, api.ReleasePromoted); err != nil {
+		return err
+	}
+	return service.updateOutdated(app, gray.Content, api.ActionPromoteGrayRelease, operator)
+}
+
+// AbandonGrayRelease removes the active gray release, the instances go back to the full release.
+func (service *ServiceImpl) AbandonGrayRelease(appId int64, operator *api.Operator) error {
+	app, err := service.Repo.RetrieveAppBrief(appId)
+	if err != nil {
+		return err
+	}
+	gray, err := service.Repo.RetrieveActiveGrayRelease(appId)
+	if err != nil {
+		return err
+	}
+	if err = service.endGrayRelease(app, api.ReleaseAbandoned); err != nil {
+		return err
+	}
+	if gray != nil {
+		service.audit(operator, api.ActionAbandonGrayRelease, appId, fmt.Sprintf("app [name=%s]", app.Name),
+			fmt.Sprintf("gray=%d", gray.Id), "gray=none")
+		// the gray instances go back to the full release
+		service.notify(api.EventAppPublished, api.ActionAbandonGrayRelease, app, operator,
+			map[string]interface{}{"abandoned_release_id": gray.Id})
+	}
+	return nil
+}
+
+// endGrayRelease deletes the gray key of the app and marks the active gray release with the status.
+func (service *ServiceImpl) endGrayRelease(app *api.App, status byte) error {
+	gray, err := service.Repo.RetrieveActiveGrayRelease(app.Id)
+	if err != nil || gray == nil {
+		return err
+	}
+	if _, err = service.Publisher.Delete(app.GrayKey()); err != nil {
+		return err
+	}
+	return service.Repo.UpdateReleaseStatus(gray.Id, status)
+}
+
+// publish records the full release and puts its content into the publisher in the layout of the service,
+// the release is marked failed if it can't be published.
+func (service *ServiceImpl) publish(app *api.App, release *api.Release) error {
+	id, err := service.Repo.InsertRelease(release)
+	if err != nil {
+		return err
+	}
+	release.Id = id
+	ops, err := service.publishOps(app, release)
+	if err == nil {
+		release.Revision, err = service.Publisher.Txn(ops)
+	}
+	if err != nil {
+		log.Errorf("Publish release [id=%d] of app [name=%s] error: %s", id, app.Name, err)
+		service.Repo.UpdateReleaseStatus(id, api.ReleaseFailed)
+		return err
+	}
+	return service.Repo.UpdateReleaseRevision(id, release.Revision)
+}
+
+// publishOps puts the release in the layout of the service, and deletes the keys of the other layout
+// and the files which are no longer published.
+func (service *ServiceImpl) publishOps(app *api.App, release *api.Release) ([]*PublishOp, error) {
+	old, err := service.manifest(app)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]*PublishOp, 0, 8)
+	published := make(map[string]struct{})
+	if service.Layout == api.LayoutFiles {
+		manifest, contents := api.NewManifest(app, release.Id, release.Content)
+		value, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, &PublishOp{Key: app.Key(), Delete: true})
+		for _, file := range manifest.Files {
+			ops = append(ops, &PublishOp{Key: file.Key, Value: contents[file.Key]})
+			published[file.Key] = struct{}{}
+		}
+		ops = append(ops, &PublishOp{Key: app.ManifestKey(), Value: string(value)})
+	} else {
+		ops = append(ops, &PublishOp{Key: app.Key(), Value: release.Content})
+		if old != nil {
+			ops = append(ops, &PublishOp{Key: app.ManifestKey(), Delete: true})
+		}
+	}
+	if old != nil {
+		for _, file := range old.Files {
+			if _, ok := published[file.Key]; !ok {
+				ops = append(ops, &PublishOp{Key: file.Key, Delete: true})
+			}
+		}
+	}
+	return ops, nil
+}
+
+// manifest returns the published manifest of the app, nil if the app isn't published in the files layout.
+func (service *ServiceImpl) manifest(app *api.App) (*api.Manifest, error) {
+	value, _, err := service.Publisher.Get(app.ManifestKey())
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+	var manifest api.Manifest
+	if err = json.Unmarshal([]byte(value), &manifest); err != nil {
+		log.Errorf("Unmarshal manifest of app [name=%s] error: %s", app.Name, err)
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (service *ServiceImpl) PollAppConfig(ctx context.Context, name string, revision int64, wait time.Duration, instanceId string, ip string) (*api.AppConfig, error) {
+	if service.Watcher == nil {
+		return nil, fmt.Errorf("config watcher isn't started")
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		current, changed := service.Watcher.Revision(name)
+		if current > revision {
+			return service.appConfig(name, current, instanceId, ip)
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, nil
+		case <-service.Watcher.Done():
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// appConfig reads the published config of the app, the gray release is taken if it matches the instance.
+func (service *ServiceImpl) appConfig(name string, revision int64, instanceId string, ip string) (*api.AppConfig, error) {
+	app := &api.App{Name: name}
+	config := &api.AppConfig{App: name, Revision: revision}
+	value, _, err := service.Publisher.Get(app.GrayKey())
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > 0 {
+		var gray api.GrayRelease
+		if err = json.Unmarshal([]byte(value), &gray); err != nil {
+			log.Errorf("Unmarshal gray release of app [name=%s] error: %s", name, err)
+			return nil, err
+		}
+		if gray.Rule.Match(instanceId, ip) {
+			config.Content, config.Gray = gray.Content, true
+			return config, nil
+		}
+	}
+	config.Content, err = service.publishedContent(app)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// publishedContent returns the published content of the app in either layout. The files of the manifest
+// are read again if they don't match the manifest, which happens while the app is being published.
+func (service *ServiceImpl) publishedContent(app *api.App) (string, error) {
+	var err error
+	for i := 0; i < 3; i++ {
+		var manifest *api.Manifest
+		if manifest, err = service.manifest(app); err != nil {
+			return "", err
+		}
+		if manifest == nil {
+			content, _, err := service.Publisher.Get(app.Key())
+			return content, err
+		}
+		contents := make(map[string]string, len(manifest.Files))
+		for _, file := range manifest.Files {
+			content, _, err := service.Publisher.Get(file.Key)
+			if err != nil {
+				return "", err
+			}
+			contents[file.Key] = content
+		}
+		if err = checkManifest(manifest, contents); err == nil {
+			return manifest.ConfigFmt(contents), nil
+		}
+	}
+	return "", err
+}
+
+// checkManifest checks the contents of the files, which are indexed by key, match the checksums in the manifest.
+func checkManifest(manifest *api.Manifest, contents map[string]string) error {
+	for _, file := range manifest.Files {
+		if api.Checksum(contents[file.Key]) != file.Checksum {
+			return fmt.Errorf("checksum of [key=%s] doesn't match the manifest of release [id=%d]", file.Key, manifest.ReleaseId)
+		}
+	}
+	return nil
+}
+
+func (service *ServiceImpl) ListReleases(appId int64) ([]map[string]interface{}, error) {
+	releases, err := service.Repo.ListReleases(appId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(releases))
+	for _, release := range releases {
+		result = append(result, release.Brief())
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) ExistsRelease(appId int64, id int64) bool {
+	return service.Repo.ExistsRelease(appId, id)
+}
+
+// RollbackRelease publishes the content of the release again, which is recorded as a new release.
+func (service *ServiceImpl) RollbackRelease(appId int64, id int64, operator *api.Operator) error {
+	release, err := service.Repo.RetrieveRelease(id)
+	if err != nil {
+		return err
+	}
+	app, err := service.Repo.RetrieveAppDetail(appId)
+	if err != nil {
+		return err
+	}
+	rollback := &api.Release{
+		AppId:     appId,
+		Content:   release.Content,
+		Publisher: operator.Name,
+		Comment:   fmt.Sprintf("Rollback to release [id=%d]", id),
+	}
+	latest, err := service.Repo.RetrieveLatestRelease(appId)
+	if err != nil {
+		return err
+	}
+	if err = service.publish(app, rollback); err != nil {
+		return err
+	}
+	before := "release=none"
+	if latest != nil {
+		before = fmt.Sprintf("release=%d revision=%d", latest.Id, latest.Revision)
+	}
+	service.audit(operator, api.ActionRollbackRelease, appId, fmt.Sprintf("app [name=%s]", app.Name), before,
+		fmt.Sprintf("release=%d revision=%d rollback=%d", rollback.Id, rollback.Revision, id))
+	service.notify(api.EventAppPublished, api.ActionRollbackRelease, app, operator,
+		map[string]interface{}{"release_id": rollback.Id, "revision": rollback.Revision, "comment": rollback.Comment})
+	return service.updateOutdated(app, release.Content, api.ActionRollbackRelease, operator)
+}
+
+// updateOutdated marks the app outdated unless its working copy is the same as the published content,
+// the outdated app is notified.
+func (service *ServiceImpl) updateOutdated(app *api.App, published string, action string, operator *api.Operator) error {
+	outdated := app.ConfigFmt() != published
+	if err := service.Repo.UpdateAppOutdated(app.Id, outdated); err != nil {
+		return err
+	}
+	if outdated {
+		service.notify(api.EventAppOutdated, action, app, operator, nil)
+	}
+	return nil
+}
+
+// DiffApp compares the current config of the app with the latest published one, the checksum of the current
+// config can be given to PublishApp to publish exactly the compared config.
+func (service *ServiceImpl) DiffApp(id int64) (map[string]interface{}, error) {
+	app, err := service.Repo.RetrieveAppDetail(id)
+	if err != nil {
+		return nil, err
+	}
+	release, err := service.Repo.RetrieveLatestRelease(id)
+	if err != nil {
+		return nil, err
+	}
+	var releaseId int64
+	var published []*api.ConfigFile
+	if release != nil {
+		releaseId = release.Id
+		published = api.ParseConfigFmt(release.Content)
+	}
+	diffs := api.DiffConfigFiles(published, app.Files)
+	files := make([]map[string]interface{}, 0, len(diffs))
+	for _, diff := range diffs {
+		files = append(files, diff.Detail())
+	}
+	return map[string]interface{}{
+		"release_id": releaseId,
+		"changed":    len(diffs) > 0,
+		"files":      files,
+		"checksum":   app.Checksum(),
+	}, nil
+}
+
 func (service *ServiceImpl) ListConfigFiles() ([]map[string]interface{}, error) {
 	cfs, err := service.Repo.ListConfigFilesBrief()
 	if err != nil {
@@ -137,10 +746,23 @@ func (service *ServiceImpl) ExistsConfigFileById(id int64) bool {
 	return service.Repo.ExistsConfigFileById(id)
 }
 
-func (service *ServiceImpl) CreateConfigFile(name string, namespaceId int64, content string) (int64, error) {
-	cis := parseContent(content)
-	cf := &api.ConfigFile{Name: name, NamespaceId: namespaceId, Items: cis}
-	return service.Repo.InsertConfigFileWithItems(cf)
+// CreateConfigFile parses the content in the format, which is properties if it's empty.
+func (service *ServiceImpl) CreateConfigFile(name string, namespaceId int64, format string, public bool, content string, operator *api.Operator) (int64, error) {
+	if len(format) == 0 {
+		format = api.FormatProperties
+	}
+	cis, err := api.ParseContent(format, content)
+	if err != nil {
+		return -1, err
+	}
+	cf := &api.ConfigFile{Name: name, NamespaceId: namespaceId, Format: format, Public: public, Items: cis}
+	id, err := service.Repo.InsertConfigFileWithItems(cf, operator.Name)
+	if err != nil {
+		return id, err
+	}
+	service.audit(operator, api.ActionCreateConfigFile, namespaceId, fmt.Sprintf("config file [id=%d] [name=%s]", id, name),
+		"", fmt.Sprintf("format=%s public=%t items=%d", format, public, len(cis)))
+	return id, nil
 }
 
 func (service *ServiceImpl) ViewConfigFile(id int64) (map[string]interface{}, error) {
@@ -151,32 +773,392 @@ func (service *ServiceImpl) ViewConfigFile(id int64) (map[string]interface{}, er
 	return cf.Detail(), nil
 }
 
-func (service *ServiceImpl) UpdateConfigFile(id int64, content string) error {
-	cis := parseContent(content)
-	return service.Repo.UpdateConfigFile(id, cis)
+// UpdateConfigFile replaces the items of the file with the content, the keys missing from the content are deleted.
+func (service *ServiceImpl) UpdateConfigFile(id int64, version int64, content string, operator *api.Operator) (map[string]interface{}, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(id)
+	if err != nil {
+		return nil, err
+	}
+	cis, err := api.ParseContent(cf.Format, content)
+	if err != nil {
+		return nil, err
+	}
+	diffs, version, err := service.Repo.UpdateConfigFile(id, version, cis, operator.Name)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, 3)
+	for _, diff := range diffs {
+		counts[diff.Type]++
+	}
+	service.audit(operator, api.ActionUpdateConfigFile, cf.NamespaceId, fmt.Sprintf("config file [id=%d] [name=%s]", id, cf.Name),
+		fmt.Sprintf("version=%d items=%d", cf.Version, len(cf.Items)),
+		fmt.Sprintf("version=%d items=%d added=%d modified=%d removed=%d", version, len(cis), counts[api.DiffAdded], counts[api.DiffModified], counts[api.DiffRemoved]))
+	if len(diffs) > 0 {
+		service.notifyOutdated(service.associatedApps(id), api.ActionUpdateConfigFile, operator,
+			map[string]interface{}{"config_file_id": id, "config_file": cf.Name, "version": version})
+	}
+	data := configItemDiffs(diffs)
+	data["version"] = version
+	return data, nil
 }
 
-func parseContent(content string) []*api.ConfigItem {
-	lines := strings.Split(content, "\n")
-	current := &api.ConfigItem{}
-	items := make([]*api.ConfigItem, 0, len(lines))
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
-			continue
+func (service *ServiceImpl) DeleteConfigFile(id int64, operator *api.Operator) error {
+	cf, err := service.Repo.RetrieveConfigFileDetail(id)
+	if err != nil {
+		return err
+	}
+	// the associations are deleted with the file
+	apps := service.associatedApps(id)
+	if err = service.Repo.DeleteConfigFile(id); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionDeleteConfigFile, cf.NamespaceId, fmt.Sprintf("config file [id=%d] [name=%s]", id, cf.Name),
+		fmt.Sprintf("version=%d items=%d", cf.Version, len(cf.Items)), "")
+	service.notifyOutdated(apps, api.ActionDeleteConfigFile, operator,
+		map[string]interface{}{"config_file_id": id, "config_file": cf.Name, "deleted": true})
+	return nil
+}
+
+func (service *ServiceImpl) ListConfigFileConsumers(id int64) ([]*api.App, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(id)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := service.Repo.ListAssociatedApps(id)
+	if err != nil {
+		return nil, err
+	}
+	consumers := make([]*api.App, 0, len(apps))
+	for _, app := range apps {
+		if app.Id != cf.NamespaceId {
+			consumers = append(consumers, app)
 		}
-		if line[0:1] == "#" {
-			current.Comment = strings.TrimSpace(line[1:])
-		} else {
-			kv := strings.Split(line, "=")
-			if len(kv) != 2 {
-				log.Errorf("Parse config [%s] failed", line)
-				continue
-			}
-			current.Name, current.Value = kv[0], kv[1]
-			items = append(items, current)
-			current = &api.ConfigItem{}
+	}
+	return consumers, nil
+}
+
+func (service *ServiceImpl) ListConfigFileApps(id int64) ([]map[string]interface{}, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(id)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := service.Repo.ListAssociatedApps(id)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(apps))
+	for _, app := range apps {
+		result = append(result, map[string]interface{}{
+			"id":        app.Id,
+			"name":      app.Name,
+			"outdated":  app.Outdated,
+			"namespace": app.Id == cf.NamespaceId,
+		})
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) UpdateConfigFilePublic(id int64, public bool, operator *api.Operator) error {
+	cf, err := service.Repo.RetrieveConfigFileDetail(id)
+	if err != nil {
+		return err
+	}
+	if err = service.Repo.UpdateConfigFilePublic(id, public); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionUpdateConfigFilePublic, cf.NamespaceId, fmt.Sprintf("config file [id=%d] [name=%s]", id, cf.Name),
+		fmt.Sprintf("public=%t", cf.Public), fmt.Sprintf("public=%t", public))
+	return nil
+}
+
+func (service *ServiceImpl) ListConfigItems(fileId int64) ([]map[string]interface{}, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(fileId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(cf.Items))
+	for _, ci := range cf.Items {
+		result = append(result, ci.Detail())
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) ExistsConfigItem(fileId int64, id int64) bool {
+	return service.Repo.ExistsConfigItem(fileId, id)
+}
+
+func (service *ServiceImpl) ExistsConfigItemByName(fileId int64, name string) bool {
+	return service.Repo.ExistsConfigItemByName(fileId, name)
+}
+
+func (service *ServiceImpl) GetConfigItem(id int64) (*api.ConfigItem, error) {
+	return service.Repo.RetrieveConfigItem(id)
+}
+
+func (service *ServiceImpl) ViewConfigItem(id int64) (map[string]interface{}, error) {
+	ci, err := service.Repo.RetrieveConfigItem(id)
+	if err != nil {
+		return nil, err
+	}
+	return ci.Detail(), nil
+}
+
+func (service *ServiceImpl) CreateConfigItem(item *api.ConfigItem, version int64, operator *api.Operator) (int64, int64, error) {
+	cf, err := service.validateConfigItem(item)
+	if err != nil {
+		return -1, 0, err
+	}
+	id, version, err := service.Repo.InsertConfigItem(item, version, operator.Name)
+	if err != nil {
+		return id, version, err
+	}
+	service.audit(operator, api.ActionCreateConfigItem, cf.NamespaceId, fmt.Sprintf("config item [id=%d] [name=%s] of config file [id=%d]", id, item.Name, cf.Id),
+		"", fmt.Sprintf("value=%s", item.Value))
+	service.notifyOutdated(service.associatedApps(cf.Id), api.ActionCreateConfigItem, operator,
+		map[string]interface{}{"config_file_id": cf.Id, "config_file": cf.Name, "item": item.Name})
+	return id, version, nil
+}
+
+func (service *ServiceImpl) UpdateConfigItem(item *api.ConfigItem, version int64, operator *api.Operator) (int64, error) {
+	cf, err := service.validateConfigItem(item)
+	if err != nil {
+		return 0, err
+	}
+	old, err := service.Repo.RetrieveConfigItem(item.Id)
+	if err != nil {
+		return 0, err
+	}
+	if version, err = service.Repo.UpdateConfigItem(item, version, operator.Name); err != nil {
+		return version, err
+	}
+	service.audit(operator, api.ActionUpdateConfigItem, cf.NamespaceId, fmt.Sprintf("config item [id=%d] [name=%s] of config file [id=%d]", item.Id, item.Name, cf.Id),
+		fmt.Sprintf("name=%s value=%s", old.Name, old.Value), fmt.Sprintf("name=%s value=%s", item.Name, item.Value))
+	service.notifyOutdated(service.associatedApps(cf.Id), api.ActionUpdateConfigItem, operator,
+		map[string]interface{}{"config_file_id": cf.Id, "config_file": cf.Name, "item": item.Name})
+	return version, nil
+}
+
+// validateConfigItem checks the file can still be rendered in its format with the item added or updated,
+// it returns the file of the item. An item without type in a yaml, json or toml file is rendered as the
+// value looks like, and the items of a properties file have no type.
+func (service *ServiceImpl) validateConfigItem(item *api.ConfigItem) (*api.ConfigFile, error) {
+	if err := item.Validate(); err != nil {
+		return nil, err
+	}
+	cf, err := service.Repo.RetrieveConfigFileDetail(item.FileId)
+	if err != nil {
+		return nil, err
+	}
+	if (cf.Format == "" || cf.Format == api.FormatProperties) && len(item.Type) > 0 {
+		return nil, fmt.Errorf("Config item [name=%s] of the properties file [id=%d] can't have a type", item.Name, cf.Id)
+	}
+	items := make([]*api.ConfigItem, 0, len(cf.Items)+1)
+	for _, ci := range cf.Items {
+		if ci.Id != item.Id {
+			items = append(items, ci)
 		}
 	}
-	return items
+	if _, err = api.RenderItems(cf.Format, append(items, item)); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func (service *ServiceImpl) DeleteConfigItem(fileId int64, id int64, version int64, operator *api.Operator) (int64, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(fileId)
+	if err != nil {
+		return 0, err
+	}
+	old, err := service.Repo.RetrieveConfigItem(id)
+	if err != nil {
+		return 0, err
+	}
+	if version, err = service.Repo.DeleteConfigItem(fileId, id, version, operator.Name); err != nil {
+		return version, err
+	}
+	service.audit(operator, api.ActionDeleteConfigItem, cf.NamespaceId, fmt.Sprintf("config item [id=%d] [name=%s] of config file [id=%d]", id, old.Name, fileId),
+		fmt.Sprintf("value=%s", old.Value), "")
+	service.notifyOutdated(service.associatedApps(fileId), api.ActionDeleteConfigItem, operator,
+		map[string]interface{}{"config_file_id": fileId, "config_file": cf.Name, "item": old.Name})
+	return version, nil
+}
+
+func (service *ServiceImpl) ListConfigFileRevisions(fileId int64) ([]map[string]interface{}, error) {
+	revisions, err := service.Repo.ListConfigFileRevisions(fileId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(revisions))
+	for _, revision := range revisions {
+		result = append(result, revision.Brief())
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) ExistsConfigFileRevision(fileId int64, revision int64) bool {
+	return service.Repo.ExistsConfigFileRevision(fileId, revision)
+}
+
+func (service *ServiceImpl) ViewConfigFileRevision(fileId int64, revision int64) (map[string]interface{}, error) {
+	rev, err := service.Repo.RetrieveConfigFileRevision(fileId, revision)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := service.Repo.RetrieveConfigFileDetail(fileId)
+	if err != nil {
+		return nil, err
+	}
+	rev.Format = cf.Format
+	return rev.Detail(), nil
+}
+
+// RollbackConfigFile replaces the items of the file at the version with the ones of the revision, which produces
+// a new revision if anything changes.
+func (service *ServiceImpl) RollbackConfigFile(fileId int64, revision int64, version int64, operator *api.Operator) (int64, error) {
+	cf, err := service.Repo.RetrieveConfigFileDetail(fileId)
+	if err != nil {
+		return 0, err
+	}
+	rev, err := service.Repo.RetrieveConfigFileRevision(fileId, revision)
+	if err != nil {
+		return 0, err
+	}
+	items := make([]*api.ConfigItem, 0, len(rev.Items))
+	for _, item := range rev.Items {
+		items = append(items, &api.ConfigItem{FileId: fileId, Name: item.Name, Value: item.Value, Comment: item.Comment, Type: item.Type})
+	}
+	diffs, version, err := service.Repo.UpdateConfigFile(fileId, version, items, operator.Name)
+	if err != nil {
+		return version, err
+	}
+	service.audit(operator, api.ActionRollbackConfigFile, cf.NamespaceId, fmt.Sprintf("config file [id=%d] [name=%s]", fileId, cf.Name),
+		fmt.Sprintf("version=%d items=%d", cf.Version, len(cf.Items)),
+		fmt.Sprintf("version=%d items=%d revision=%d changed=%d", version, len(items), revision, len(diffs)))
+	if len(diffs) > 0 {
+		service.notifyOutdated(service.associatedApps(fileId), api.ActionRollbackConfigFile, operator,
+			map[string]interface{}{"config_file_id": fileId, "config_file": cf.Name, "version": version})
+	}
+	return version, nil
+}
+
+func configItemDiffs(diffs []*api.ConfigItemDiff) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(diffs))
+	for _, diff := range diffs {
+		items = append(items, diff.Detail())
+	}
+	return map[string]interface{}{
+		"changed": len(diffs) > 0,
+		"items":   items,
+	}
+}
+
+func (service *ServiceImpl) ListAuditEvents(filter *api.AuditFilter) ([]map[string]interface{}, error) {
+	events, err := service.Repo.QueryAuditEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(events))
+	for _, event := range events {
+		result = append(result, event.Brief())
+	}
+	return result, nil
+}
+
+// audit records the operation performed by the operator, the error is only logged since the operation is done.
+func (service *ServiceImpl) audit(operator *api.Operator, action string, appId int64, target, before, after string) {
+	event := &api.AuditEvent{Actor: operator.Name, Action: action, AppId: appId, Target: target, Before: before, After: after, RequestId: operator.RequestId}
+	if _, err := service.Repo.InsertAuditEvent(event); err != nil {
+		log.Errorf("Insert audit event [action=%s] [target=%s] error: %s", action, target, err)
+	}
+}
+
+// notify sends the event of the app caused by the action to the webhooks.
+func (service *ServiceImpl) notify(event, action string, app *api.App, operator *api.Operator, data map[string]interface{}) {
+	if service.Notifier == nil {
+		return
+	}
+	service.Notifier.Notify(&api.WebhookEvent{
+		Event:     event,
+		AppId:     app.Id,
+		App:       app.Name,
+		Action:    action,
+		Actor:     operator.Name,
+		RequestId: operator.RequestId,
+		Data:      data,
+		Time:      time.Now(),
+	})
+}
+
+// notifyOutdated sends the outdated event of each app caused by the action to the webhooks.
+func (service *ServiceImpl) notifyOutdated(apps []*api.App, action string, operator *api.Operator, data map[string]interface{}) {
+	for _, app := range apps {
+		service.notify(api.EventAppOutdated, action, app, operator, data)
+	}
+}
+
+// associatedApps returns the apps associated with the file to notify, the error is only logged.
+func (service *ServiceImpl) associatedApps(fileId int64) []*api.App {
+	apps, err := service.Repo.ListAssociatedApps(fileId)
+	if err != nil {
+		log.Errorf("List apps of config file [id=%d] to notify error: %s", fileId, err)
+	}
+	return apps
+}
+
+func (service *ServiceImpl) ListWebhooks() ([]map[string]interface{}, error) {
+	hooks, err := service.Repo.ListWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(hooks))
+	for _, hook := range hooks {
+		result = append(result, hook.Brief())
+	}
+	return result, nil
+}
+
+func (service *ServiceImpl) ExistsWebhook(id int64) bool {
+	return service.Repo.ExistsWebhook(id)
+}
+
+func (service *ServiceImpl) CreateWebhook(hook *api.Webhook, operator *api.Operator) (int64, error) {
+	id, err := service.Repo.InsertWebhook(hook)
+	if err != nil {
+		return id, err
+	}
+	service.audit(operator, api.ActionCreateWebhook, hook.AppId, fmt.Sprintf("webhook [id=%d]", id),
+		"", fmt.Sprintf("url=%s events=%v", hook.Url, hook.Events))
+	return id, nil
+}
+
+func (service *ServiceImpl) DeleteWebhook(id int64, operator *api.Operator) error {
+	hooks, err := service.Repo.ListWebhooks()
+	if err != nil {
+		return err
+	}
+	hook := &api.Webhook{Id: id}
+	for _, h := range hooks {
+		if h.Id == id {
+			hook = h
+		}
+	}
+	if err = service.Repo.DeleteWebhook(id); err != nil {
+		return err
+	}
+	service.audit(operator, api.ActionDeleteWebhook, hook.AppId, fmt.Sprintf("webhook [id=%d]", id),
+		fmt.Sprintf("url=%s events=%v", hook.Url, hook.Events), "")
+	return nil
+}
+
+func (service *ServiceImpl) ListWebhookDeliveries(webhookId int64, limit int) ([]map[string]interface{}, error) {
+	deliveries, err := service.Repo.QueryWebhookDeliveries(webhookId, limit)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		result = append(result, delivery.Brief())
+	}
+	return result, nil
 }
@@ -0,0 +1,370 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/memory"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/cflion/cflion/pkg/transport/restful"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePublisher keeps the keys in a map, the watches are not supported.
+type fakePublisher struct {
+	Publisher
+	mu       sync.Mutex
+	kvs      map[string]string
+	revision int64
+	// err fails the changes if it isn't nil
+	err error
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{kvs: make(map[string]string)}
+}
+
+func (publisher *fakePublisher) Put(key string, value string) (int64, error) {
+	return publisher.Txn([]*PublishOp{{Key: key, Value: value}})
+}
+
+func (publisher *fakePublisher) Get(key string) (string, int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	return publisher.kvs[key], publisher.revision, nil
+}
+
+func (publisher *fakePublisher) Delete(key string) (int64, error) {
+	return publisher.Txn([]*PublishOp{{Key: key, Delete: true}})
+}
+
+func (publisher *fakePublisher) Txn(ops []*PublishOp) (int64, error) {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if publisher.err != nil {
+		return 0, publisher.err
+	}
+	publisher.revision++
+	for _, op := range ops {
+		if op.Delete {
+			delete(publisher.kvs, op.Key)
+		} else {
+			publisher.kvs[op.Key] = op.Value
+		}
+	}
+	return publisher.revision, nil
+}
+
+// newTestService returns a service of the memory repository with app demo owning the file db.
+func newTestService(t *testing.T) (*ServiceImpl, *api.App, int64) {
+	repo := memory.NewRepository()
+	service := &ServiceImpl{Repo: repo, Publisher: newFakePublisher()}
+	operator := &api.Operator{Name: "alice", RequestId: "r0"}
+	appId, err := service.CreateApp("demo", operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileId, err := service.CreateConfigFile("db", appId, "", false, "host=127.0.0.1\n", operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, _ := repo.RetrieveAppBrief(appId)
+	return service, app, fileId
+}
+
+func TestServiceImpl_RollbackRelease(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	operator := &api.Operator{Name: "alice", RequestId: "r1"}
+	if err := service.PublishApp(app.Id, "", operator, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := service.Repo.RetrieveLatestRelease(app.Id)
+	first, _ := service.Repo.RetrieveRelease(latest.Id)
+	if _, err := service.UpdateConfigFile(fileId, 0, "host=10.0.0.1\n", operator); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.PublishApp(app.Id, "", operator, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RollbackRelease(app.Id, first.Id, &api.Operator{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	releases, _ := service.Repo.ListReleases(app.Id)
+	if len(releases) != 3 || releases[0].Publisher != "bob" || releases[0].Revision == 0 {
+		t.Fatalf("expect the rollback recorded as a new release, got %v", releases)
+	}
+	if rollback, _ := service.Repo.RetrieveRelease(releases[0].Id); rollback.Content != first.Content {
+		t.Errorf("expect the content of release [id=%d], got %q", first.Id, rollback.Content)
+	}
+	if content, _, _ := service.Publisher.Get(app.Key()); content != first.Content {
+		t.Errorf("expect the content of release [id=%d] published, got %q", first.Id, content)
+	}
+	// the working copy differs from the rolled back content
+	if a, _ := service.Repo.RetrieveAppBrief(app.Id); a.Outdated != 1 {
+		t.Error("expect app outdated after rolling back")
+	}
+	data, _ := service.ListReleases(app.Id)
+	if len(data) != 3 || data[0]["id"] != releases[0].Id {
+		t.Errorf("expect the releases from the newest, got %v", data)
+	}
+}
+
+func TestServiceImpl_RollbackReleaseWithoutNormal(t *testing.T) {
+	service, app, _ := newTestService(t)
+	operator := &api.Operator{Name: "alice", RequestId: "r1"}
+	if err := service.PublishAppGray(app.Id, &api.GrayRule{IPs: []string{"10.0.0.*"}}, "", operator, "gray"); err != nil {
+		t.Fatal(err)
+	}
+	releases, _ := service.Repo.ListReleases(app.Id)
+	if len(releases) != 1 {
+		t.Fatalf("expect the gray release only, got %v", releases)
+	}
+	// the app has no normal release to audit as before
+	if err := service.RollbackRelease(app.Id, releases[0].Id, &api.Operator{Name: "bob", RequestId: "r2"}); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := service.Repo.QueryAuditEvents(&api.AuditFilter{Limit: 1})
+	if len(events) != 1 || events[0].Action != api.ActionRollbackRelease || events[0].Before != "release=none" || events[0].RequestId != "r2" {
+		t.Errorf("expect the rollback audited, got %+v", events)
+	}
+	if releases, _ = service.Repo.ListReleases(app.Id); len(releases) != 2 || releases[0].Publisher != "bob" {
+		t.Errorf("expect the rollback recorded as a new release, got %v", releases)
+	}
+}
+
+func TestServiceImpl_PublishAppChecksum(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	operator := &api.Operator{Name: "alice", RequestId: "r1"}
+	diff, _ := service.DiffApp(app.Id)
+	checksum := diff["checksum"].(string)
+	if _, err := service.UpdateConfigFile(fileId, 0, "host=10.0.0.1\n", operator); err != nil {
+		t.Fatal(err)
+	}
+	// the config has changed since the checksum is taken
+	err := service.PublishApp(app.Id, checksum, operator, "v1")
+	if conflict, ok := err.(*api.ChecksumConflictError); !ok || conflict.Checksum == checksum {
+		t.Fatalf("expect checksum conflict, got %v", err)
+	}
+	if err = service.PublishAppGray(app.Id, &api.GrayRule{IPs: []string{"10.0.0.*"}}, checksum, operator, "v1"); err == nil {
+		t.Fatal("expect checksum conflict of the gray release")
+	}
+	if releases, _ := service.Repo.ListReleases(app.Id); len(releases) != 0 {
+		t.Fatalf("expect nothing published, got %v", releases)
+	}
+	diff, _ = service.DiffApp(app.Id)
+	if err = service.PublishApp(app.Id, diff["checksum"].(string), operator, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _, _ := service.Publisher.Get(app.Key()); content != "[db]\nhost=10.0.0.1\n" {
+		t.Errorf("expect the config of the checksum published, got %q", content)
+	}
+
+	// the gray release is promoted only with its checksum
+	diff, _ = service.DiffApp(app.Id)
+	if err = service.PublishAppGray(app.Id, &api.GrayRule{IPs: []string{"10.0.0.*"}}, diff["checksum"].(string), operator, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = service.PromoteGrayRelease(app.Id, checksum, operator); err == nil {
+		t.Fatal("expect checksum conflict of promoting the gray release")
+	}
+	if err = service.PromoteGrayRelease(app.Id, diff["checksum"].(string), operator); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceImpl_RollbackConfigFile(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	operator := &api.Operator{Name: "alice", RequestId: "r1"}
+	if _, err := service.UpdateConfigFile(fileId, 0, "host=10.0.0.1\nport=3306\n", operator); err != nil {
+		t.Fatal(err)
+	}
+	service.Repo.UpdateAppOutdated(app.Id, false)
+	// the rollback on a stale version conflicts
+	if _, err := service.RollbackConfigFile(fileId, 1, 1, &api.Operator{Name: "bob"}); err == nil {
+		t.Fatal("expect version conflict when rolling back version 1")
+	} else if conflict, ok := err.(*api.VersionConflictError); !ok || conflict.Version != 2 {
+		t.Fatalf("expect version conflict at version 2, got %v", err)
+	}
+	if version, err := service.RollbackConfigFile(fileId, 1, 2, &api.Operator{Name: "bob"}); err != nil || version != 3 {
+		t.Fatalf("expect version 3 after rolling back, got %d: %v", version, err)
+	}
+	cf, _ := service.Repo.RetrieveConfigFileDetail(fileId)
+	if len(cf.Items) != 1 || cf.Items[0].Name != "host" || cf.Items[0].Value != "127.0.0.1" || cf.Version != 3 {
+		t.Errorf("expect the items of revision 1 at version 3, got %s", cf)
+	}
+	revisions, _ := service.ListConfigFileRevisions(fileId)
+	if len(revisions) != 3 || revisions[0]["revision"] != int64(3) || revisions[0]["author"] != "bob" {
+		t.Errorf("expect the rollback saved as revision 3, got %v", revisions)
+	}
+	if a, _ := service.Repo.RetrieveAppBrief(app.Id); a.Outdated != 1 {
+		t.Error("expect app outdated after rolling back")
+	}
+	// rolling back to the current items changes nothing
+	if _, err := service.RollbackConfigFile(fileId, 3, 0, &api.Operator{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, _ = service.ListConfigFileRevisions(fileId); len(revisions) != 3 {
+		t.Errorf("expect no new revision, got %v", revisions)
+	}
+}
+
+func TestServiceImpl_ConfigItemType(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	operator := &api.Operator{Name: "alice"}
+	content := "port: \"8080\"\nratio: 1.50\ntags: []\n"
+	yamlId, err := service.CreateConfigFile("server", app.Id, api.FormatYAML, false, content, operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = service.CreateConfigItem(&api.ConfigItem{FileId: yamlId, Name: "debug", Value: "true", Type: api.ItemBool}, 0, operator); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = service.CreateConfigItem(&api.ConfigItem{FileId: yamlId, Name: "timeout", Value: "3s", Type: api.ItemNumber}, 0, operator); err == nil {
+		t.Error("expect error when the value isn't of the type")
+	}
+	if _, _, err = service.CreateConfigItem(&api.ConfigItem{FileId: fileId, Name: "user", Value: "root", Type: api.ItemString}, 0, operator); err == nil {
+		t.Error("expect error when an item of a properties file has a type")
+	}
+	cf, _ := service.Repo.RetrieveConfigFileDetail(yamlId)
+	rendered, err := api.RenderItems(cf.Format, cf.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "port: \"8080\"\nratio: 1.5\ntags: []\ndebug: true"; rendered != expected {
+		t.Errorf("expect the types kept as %q, got %q", expected, rendered)
+	}
+}
+
+func TestServiceImpl_DeleteApp(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	if err := service.PublishApp(app.Id, "", &api.Operator{Name: "alice"}, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	publisher := service.Publisher.(*fakePublisher)
+	publisher.err = errors.New("etcd is unavailable")
+	// the app is kept if its keys can't be deleted
+	if err := service.DeleteApp(app.Id, &api.Operator{Name: "alice"}); err == nil {
+		t.Fatal("expect error when the publisher fails")
+	}
+	if !service.Repo.ExistsAppById(app.Id) || !service.Repo.ExistsConfigFileById(fileId) {
+		t.Error("expect the app kept after failing to delete its keys")
+	}
+	publisher.err = nil
+	if err := service.DeleteApp(app.Id, &api.Operator{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if service.Repo.ExistsAppById(app.Id) || service.Repo.ExistsConfigFileById(fileId) {
+		t.Error("expect the app deleted with its config files")
+	}
+	if content, _, _ := publisher.Get(app.Key()); len(content) > 0 {
+		t.Errorf("expect the key of the app deleted, got %q", content)
+	}
+}
+
+func TestRegisterRoutes_Audit(t *testing.T) {
+	service, app, fileId := newTestService(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router.Group("/v1"), service)
+
+	var cacheId, itemId int64
+	files := fmt.Sprintf("/v1/config-files/%d", fileId)
+	// the cases change the apps, files and webhooks in turn, the urls are built when the case runs
+	cases := []struct {
+		route  string
+		url    func() string
+		body   interface{}
+		action string
+	}{
+		{"POST /v1/apps", nil, map[string]interface{}{"name": "other"}, api.ActionCreateApp},
+		{"POST /v1/config-files", nil, map[string]interface{}{"namespace_id": app.Id, "filename": "cache", "config": "size=1"}, api.ActionCreateConfigFile},
+		{"DELETE /v1/apps/:name/config-files/:file_id", func() string {
+			cfs, _ := service.Repo.ListConfigFilesBrief()
+			cacheId = cfs[len(cfs)-1].Id
+			return fmt.Sprintf("/v1/apps/demo/config-files/%d", cacheId)
+		}, nil, api.ActionDeleteAppAssociation},
+		{"PUT /v1/apps/:name", func() string { return "/v1/apps/demo" }, map[string]interface{}{"config_files": []int64{fileId}}, api.ActionUpdateAppAssociation},
+		{"PUT /v1/config-files/:file_id", nil, map[string]interface{}{"config": "host=10.0.0.1\n"}, api.ActionUpdateConfigFile},
+		{"PUT /v1/config-files/:file_id/public", func() string { return files + "/public" }, map[string]interface{}{"public": true}, api.ActionUpdateConfigFilePublic},
+		{"POST /v1/config-files/:file_id/items", func() string { return files + "/items" }, map[string]interface{}{"name": "port", "value": "3306"}, api.ActionCreateConfigItem},
+		{"PUT /v1/config-files/:file_id/items/:item_id", func() string {
+			cf, _ := service.Repo.RetrieveConfigFileDetail(fileId)
+			for _, item := range cf.Items {
+				if item.Name == "port" {
+					itemId = item.Id
+				}
+			}
+			return fmt.Sprintf("%s/items/%d", files, itemId)
+		}, map[string]interface{}{"name": "port", "value": "3307"}, api.ActionUpdateConfigItem},
+		{"DELETE /v1/config-files/:file_id/items/:item_id", func() string { return fmt.Sprintf("%s/items/%d", files, itemId) }, nil, api.ActionDeleteConfigItem},
+		{"POST /v1/config-files/:file_id/revisions/:revision/rollback", func() string { return files + "/revisions/1/rollback" }, nil, api.ActionRollbackConfigFile},
+		{"PUT /v1/apps", nil, map[string]interface{}{"name": "demo"}, api.ActionPublishApp},
+		{"PUT /v1/apps", nil, map[string]interface{}{"name": "demo", "gray": map[string]interface{}{"ips": []string{"10.0.0.*"}}}, api.ActionPublishAppGray},
+		{"PUT /v1/apps/:name/gray", func() string { return "/v1/apps/demo/gray" }, nil, api.ActionPromoteGrayRelease},
+		{"PUT /v1/apps", nil, map[string]interface{}{"name": "demo", "gray": map[string]interface{}{"ips": []string{"10.0.0.*"}}}, api.ActionPublishAppGray},
+		{"DELETE /v1/apps/:name/gray", func() string { return "/v1/apps/demo/gray" }, nil, api.ActionAbandonGrayRelease},
+		{"POST /v1/apps/:name/releases/:id/rollback", func() string {
+			releases, _ := service.Repo.ListReleases(app.Id)
+			return fmt.Sprintf("/v1/apps/demo/releases/%d/rollback", releases[len(releases)-1].Id)
+		}, nil, api.ActionRollbackRelease},
+		{"POST /v1/webhooks", nil, map[string]interface{}{"url": "http://127.0.0.1/hook", "app": "demo"}, api.ActionCreateWebhook},
+		{"DELETE /v1/webhooks/:webhook_id", func() string {
+			hooks, _ := service.Repo.ListWebhooks()
+			return fmt.Sprintf("/v1/webhooks/%d", hooks[0].Id)
+		}, nil, api.ActionDeleteWebhook},
+		{"DELETE /v1/config-files/:file_id", func() string { return fmt.Sprintf("/v1/config-files/%d", cacheId) }, nil, api.ActionDeleteConfigFile},
+		{"DELETE /v1/apps/:name", func() string { return "/v1/apps/other" }, nil, api.ActionDeleteApp},
+	}
+	covered := make(map[string]bool)
+	for i, c := range cases {
+		covered[c.route] = true
+		method, path := c.route[:strings.Index(c.route, " ")], c.route[strings.Index(c.route, " ")+1:]
+		if c.url != nil {
+			path = c.url()
+		} else if strings.Contains(path, ":file_id") {
+			path = files
+		}
+		var buf bytes.Buffer
+		if c.body != nil {
+			json.NewEncoder(&buf).Encode(c.body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+		req.Header.Set(OperatorHeader, "alice")
+		req.Header.Set(restful.RequestIdHeader, fmt.Sprintf("r%d", i))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s expect success, got %d: %s", method, path, w.Code, w.Body)
+		}
+		events, _ := service.Repo.QueryAuditEvents(&api.AuditFilter{Limit: 1})
+		if len(events) != 1 || events[0].Action != c.action || events[0].Actor != "alice" || events[0].RequestId != fmt.Sprintf("r%d", i) {
+			t.Errorf("%s %s expect audit event %s, got %v", method, path, c.action, events)
+		}
+	}
+	for _, route := range router.Routes() {
+		if route.Method != "GET" && !covered[route.Method+" "+route.Path] {
+			t.Errorf("route %s %s isn't checked for the audit event", route.Method, route.Path)
+		}
+	}
+}
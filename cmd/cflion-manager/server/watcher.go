@@ -0,0 +1,138 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"context"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"sync"
+	"time"
+)
+
+// rewatchInterval is the wait before watching again after the watch fails.
+const rewatchInterval = time.Second
+
+// ConfigWatcher shares a single watch of all the published keys among the long polling requests. It records the
+// revision of the last change of every app, the apps unchanged since the watch started have the start revision.
+type ConfigWatcher struct {
+	publisher Publisher
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu        sync.Mutex
+	start     int64
+	revisions map[string]int64
+	// changed is closed at the next change of the app
+	changed map[string]chan struct{}
+}
+
+// NewConfigWatcher starts watching the keys of all the apps from the current revision of the publisher.
+func NewConfigWatcher(publisher Publisher) (*ConfigWatcher, error) {
+	_, revision, err := publisher.Get(api.KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := &ConfigWatcher{
+		publisher: publisher,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		start:     revision,
+		revisions: make(map[string]int64),
+		changed:   make(map[string]chan struct{}),
+	}
+	go watcher.run(ctx, revision)
+	return watcher, nil
+}
+
+// Revision returns the revision of the app, and a channel which is closed at the next change of the app.
+func (watcher *ConfigWatcher) Revision(app string) (int64, <-chan struct{}) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	revision, ok := watcher.revisions[app]
+	if !ok {
+		revision = watcher.start
+	}
+	ch, ok := watcher.changed[app]
+	if !ok {
+		ch = make(chan struct{})
+		watcher.changed[app] = ch
+	}
+	return revision, ch
+}
+
+// Done is closed after the watcher is closed.
+func (watcher *ConfigWatcher) Done() <-chan struct{} {
+	return watcher.done
+}
+
+// Close stops the watch.
+func (watcher *ConfigWatcher) Close() {
+	watcher.cancel()
+	<-watcher.done
+}
+
+func (watcher *ConfigWatcher) run(ctx context.Context, revision int64) {
+	defer close(watcher.done)
+	for {
+		for event := range watcher.publisher.WatchPrefix(ctx, api.KeyPrefix, revision) {
+			if event.Err != nil {
+				break
+			}
+			if app, ok := api.AppNameOfKey(event.Key); ok {
+				watcher.change(app, event.Revision)
+			}
+			revision = event.Revision
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rewatchInterval):
+		}
+		// the changes while not watching are unknown, so every app is regarded as changed
+		_, current, err := watcher.publisher.Get(api.KeyPrefix)
+		if err != nil {
+			log.Errorf("Get current revision to watch apps error: %s", err)
+			continue
+		}
+		log.Warnf("Watch apps again from [revision=%d]", current)
+		watcher.reset(current)
+		revision = current
+	}
+}
+
+// change records the revision of the app and wakes up the requests waiting for the app.
+func (watcher *ConfigWatcher) change(app string, revision int64) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.revisions[app] = revision
+	if ch, ok := watcher.changed[app]; ok {
+		close(ch)
+		delete(watcher.changed, app)
+	}
+}
+
+// reset regards all the apps changed at the revision.
+func (watcher *ConfigWatcher) reset(revision int64) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.start = revision
+	watcher.revisions = make(map[string]int64)
+	for _, ch := range watcher.changed {
+		close(ch)
+	}
+	watcher.changed = make(map[string]chan struct{})
+}
@@ -0,0 +1,154 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxDeliveryResponse is the max bytes of the response body recorded in a delivery.
+const maxDeliveryResponse = 1024
+
+// Notifier posts the events to the webhooks subscribing them asynchronously. A delivery failed with an error or
+// a non 2xx response is retried up to Retries times, the first retry waits Backoff and every next one doubles it.
+// All the attempts are recorded in the repository.
+type Notifier struct {
+	Repo    Repository
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+
+	wg       sync.WaitGroup
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func NewNotifier(repo Repository, timeout time.Duration, retries int, backoff time.Duration) *Notifier {
+	return &Notifier{
+		Repo:    repo,
+		Client:  &http.Client{Timeout: timeout},
+		Retries: retries,
+		Backoff: backoff,
+		done:    make(chan struct{}),
+	}
+}
+
+// Notify delivers the event in background, it's dropped after the notifier is closed.
+func (n *Notifier) Notify(event *api.WebhookEvent) {
+	select {
+	case <-n.done:
+		log.Warnf("Notifier closed, drop event [%s] of app [id=%d]", event.Event, event.AppId)
+		return
+	default:
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.dispatch(event)
+	}()
+}
+
+// Close stops the retries waiting for backoff and waits for the deliveries in flight.
+func (n *Notifier) Close() {
+	n.doneOnce.Do(func() { close(n.done) })
+	n.wg.Wait()
+}
+
+func (n *Notifier) dispatch(event *api.WebhookEvent) {
+	hooks, err := n.Repo.ListWebhooks()
+	if err != nil {
+		log.Errorf("List webhooks for event [%s] of app [id=%d] error: %s", event.Event, event.AppId, err)
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Marshal event [%s] of app [id=%d] error: %s", event.Event, event.AppId, err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		if !hook.Subscribes(event) {
+			continue
+		}
+		wg.Add(1)
+		go func(hook *api.Webhook) {
+			defer wg.Done()
+			n.deliver(hook, event.Event, payload)
+		}(hook)
+	}
+	wg.Wait()
+}
+
+func (n *Notifier) deliver(hook *api.Webhook, event string, payload []byte) {
+	backoff := n.Backoff
+	for attempt := 1; ; attempt++ {
+		delivery := n.post(hook, event, payload)
+		delivery.Attempt = attempt
+		if _, err := n.Repo.InsertWebhookDelivery(delivery); err != nil {
+			log.Errorf("Insert delivery of webhook [id=%d] error: %s", hook.Id, err)
+		}
+		if delivery.Succeeded() || attempt > n.Retries {
+			if !delivery.Succeeded() {
+				log.Warnf("Deliver event [%s] to webhook [id=%d] failed after %d attempts", event, hook.Id, attempt)
+			}
+			return
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// post posts the payload signed by the secret of the webhook, and returns the delivery without Attempt.
+func (n *Notifier) post(hook *api.Webhook, event string, payload []byte) *api.WebhookDelivery {
+	delivery := &api.WebhookDelivery{WebhookId: hook.Id, Event: event, Payload: string(payload)}
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cflion-Event", event)
+	if len(hook.Secret) > 0 {
+		signature.SignPayloadRequest(req, hook.Secret, payload)
+	}
+	start := time.Now()
+	resp, err := n.Client.Do(req)
+	delivery.Duration = time.Since(start)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDeliveryResponse))
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	delivery.Response = string(body)
+	return delivery
+}
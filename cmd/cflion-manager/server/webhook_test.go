@@ -0,0 +1,261 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cflion/cflion/cmd/cflion-manager/server/repository/memory"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitDeliveries waits until the webhook has n deliveries.
+func waitDeliveries(t *testing.T, repo Repository, webhookId int64, n int) []*api.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := repo.QueryWebhookDeliveries(webhookId, 0)
+		if len(deliveries) >= n || time.Now().After(deadline) {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	// the receiver checks the signature and fails the first two deliveries
+	var mu sync.Mutex
+	var received []*api.WebhookEvent
+	count := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := signature.VerifyPayload(r, body, "secret", time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if count++; count <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("busy"))
+			return
+		}
+		var event api.WebhookEvent
+		json.Unmarshal(body, &event)
+		received = append(received, &event)
+	}))
+	defer receiver.Close()
+
+	repo := memory.NewRepository()
+	demoId, _ := repo.InsertApp(&api.App{Name: "demo"})
+	otherId, _ := repo.InsertApp(&api.App{Name: "other"})
+	// the file is associated with its namespace app
+	repo.InsertConfigFileWithItems(&api.ConfigFile{Name: "db", NamespaceId: demoId}, "alice")
+	hookId, _ := repo.InsertWebhook(&api.Webhook{AppId: demoId, Url: receiver.URL, Secret: "secret", Events: []string{api.EventAppOutdated}})
+	otherHookId, _ := repo.InsertWebhook(&api.Webhook{AppId: otherId, Url: receiver.URL, Secret: "secret"})
+
+	notifier := NewNotifier(repo, time.Second, 3, 10*time.Millisecond)
+	defer notifier.Close()
+	service := &ServiceImpl{Repo: repo, Notifier: notifier}
+	if err := service.UpdateAppAssociation(demoId, nil, &api.Operator{Name: "alice", RequestId: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := waitDeliveries(t, repo, hookId, 3)
+	if len(deliveries) != 3 {
+		t.Fatalf("expect 3 attempts, got %v", deliveries)
+	}
+	if d := deliveries[2]; d.Attempt != 1 || d.StatusCode != http.StatusServiceUnavailable || d.Response != "busy" || d.Succeeded() {
+		t.Errorf("unexpected first attempt %+v", d)
+	}
+	if d := deliveries[0]; d.Attempt != 3 || !d.Succeeded() || d.Event != api.EventAppOutdated {
+		t.Errorf("unexpected last attempt %+v", d)
+	}
+	mu.Lock()
+	if len(received) != 1 || received[0].App != "demo" || received[0].Action != api.ActionUpdateAppAssociation ||
+		received[0].Actor != "alice" || received[0].RequestId != "r1" {
+		t.Errorf("unexpected received events %+v", received)
+	}
+	mu.Unlock()
+	if deliveries, _ = repo.QueryWebhookDeliveries(otherHookId, 0); len(deliveries) != 0 {
+		t.Errorf("expect no delivery to the webhook of another app, got %v", deliveries)
+	}
+}
+
+func TestNotifier_DeleteApp(t *testing.T) {
+	var mu sync.Mutex
+	var received []*api.WebhookEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var event api.WebhookEvent
+		json.Unmarshal(body, &event)
+		mu.Lock()
+		received = append(received, &event)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	repo := memory.NewRepository()
+	demoId, _ := repo.InsertApp(&api.App{Name: "demo"})
+	otherId, _ := repo.InsertApp(&api.App{Name: "other"})
+	fileId, _ := repo.InsertConfigFileWithItems(&api.ConfigFile{Name: "db", NamespaceId: demoId, Public: true}, "alice")
+	hookId, _ := repo.InsertWebhook(&api.Webhook{AppId: otherId, Url: receiver.URL, Secret: "secret", Events: []string{api.EventAppOutdated}})
+
+	notifier := NewNotifier(repo, time.Second, 1, 10*time.Millisecond)
+	defer notifier.Close()
+	service := &ServiceImpl{Repo: repo, Publisher: newFakePublisher(), Notifier: notifier}
+	if err := service.UpdateAppAssociation(otherId, []int64{fileId}, &api.Operator{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	repo.UpdateAppOutdated(otherId, false)
+	if err := service.DeleteApp(demoId, &api.Operator{Name: "bob", RequestId: "r2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitDeliveries(t, repo, hookId, 2)
+	if app, _ := repo.RetrieveAppBrief(otherId); app.Outdated != 1 || len(app.Files) != 0 {
+		t.Errorf("expect the consumer outdated without the deleted file, got %s", app)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	notified := false
+	for _, event := range received {
+		notified = notified || (event.App == "other" && event.Action == api.ActionDeleteApp &&
+			event.Actor == "bob" && event.Data["deleted_app"] == "demo")
+	}
+	if !notified {
+		t.Errorf("expect the consumer notified of the deleted app, got %+v", received)
+	}
+}
+
+func TestNotifier_GiveUp(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	repo := memory.NewRepository()
+	hookId, _ := repo.InsertWebhook(&api.Webhook{Url: receiver.URL})
+	notifier := NewNotifier(repo, time.Second, 2, time.Millisecond)
+	notifier.Notify(&api.WebhookEvent{Event: api.EventAppPublished, AppId: 1, App: "demo"})
+	// the failed delivery is retried twice
+	waitDeliveries(t, repo, hookId, 3)
+	time.Sleep(50 * time.Millisecond)
+	notifier.Close()
+	if deliveries, _ := repo.QueryWebhookDeliveries(hookId, 0); len(deliveries) != 3 || deliveries[0].Attempt != 3 {
+		t.Errorf("expect 3 failed attempts, got %v", deliveries)
+	}
+	// the events are dropped after the notifier is closed
+	notifier.Notify(&api.WebhookEvent{Event: api.EventAppPublished, AppId: 1, App: "demo"})
+	time.Sleep(20 * time.Millisecond)
+	if deliveries, _ := repo.QueryWebhookDeliveries(hookId, 0); len(deliveries) != 3 {
+		t.Errorf("expect no delivery after closed, got %d", len(deliveries))
+	}
+}
+
+func TestServiceImpl_Notify(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event api.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		received = append(received, event.Event+" "+event.Action)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	service, app, fileId := newTestService(t)
+	hookId, _ := service.Repo.InsertWebhook(&api.Webhook{AppId: app.Id, Url: receiver.URL})
+	service.Notifier = NewNotifier(service.Repo, time.Second, 1, time.Millisecond)
+	defer service.Notifier.Close()
+	operator := &api.Operator{Name: "alice", RequestId: "r1"}
+	rule := &api.GrayRule{IPs: []string{"10.0.0.*"}}
+
+	// every operation which publishes the app or makes it outdated is notified
+	steps := []func() error{
+		func() error {
+			id, version, err := service.CreateConfigItem(&api.ConfigItem{FileId: fileId, Name: "port", Value: "3306"}, 0, operator)
+			if err == nil {
+				version, err = service.UpdateConfigItem(&api.ConfigItem{Id: id, FileId: fileId, Name: "port", Value: "3307"}, version, operator)
+			}
+			if err == nil {
+				_, err = service.DeleteConfigItem(fileId, id, version, operator)
+			}
+			return err
+		},
+		func() error {
+			_, err := service.UpdateConfigFile(fileId, 0, "host=10.0.0.1\n", operator)
+			return err
+		},
+		func() error {
+			_, err := service.RollbackConfigFile(fileId, 1, 0, operator)
+			return err
+		},
+		func() error { return service.PublishApp(app.Id, "", operator, "v1") },
+		func() error { return service.PublishAppGray(app.Id, rule, "", operator, "gray") },
+		func() error { return service.PromoteGrayRelease(app.Id, "", operator) },
+		func() error { return service.PublishAppGray(app.Id, rule, "", operator, "gray") },
+		func() error { return service.AbandonGrayRelease(app.Id, operator) },
+		func() error {
+			latest, _ := service.Repo.RetrieveLatestRelease(app.Id)
+			return service.RollbackRelease(app.Id, latest.Id, operator)
+		},
+		func() error {
+			cacheId, err := service.CreateConfigFile("cache", app.Id, "", false, "size=1", operator)
+			if err == nil {
+				err = service.DeleteAppAssociation(app.Id, cacheId, operator)
+			}
+			return err
+		},
+		func() error { return service.DeleteConfigFile(fileId, operator) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+	}
+	expected := []string{
+		"app.outdated " + api.ActionCreateConfigItem,
+		"app.outdated " + api.ActionUpdateConfigItem,
+		"app.outdated " + api.ActionDeleteConfigItem,
+		"app.outdated " + api.ActionUpdateConfigFile,
+		"app.outdated " + api.ActionRollbackConfigFile,
+		"app.published " + api.ActionPublishApp,
+		"app.published " + api.ActionPublishAppGray,
+		"app.published " + api.ActionPromoteGrayRelease,
+		"app.published " + api.ActionPublishAppGray,
+		"app.published " + api.ActionAbandonGrayRelease,
+		"app.published " + api.ActionRollbackRelease,
+		"app.outdated " + api.ActionDeleteAppAssociation,
+		"app.outdated " + api.ActionDeleteConfigFile,
+	}
+	waitDeliveries(t, service.Repo, hookId, len(expected))
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(received)
+	sort.Strings(expected)
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Errorf("expect events %v, got %v", expected, received)
+	}
+}
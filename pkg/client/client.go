@@ -0,0 +1,590 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package client watches the config of an app published by cflion-manager.
+//
+// cli, err := client.New(&client.Config{ManagerEndpoint: "http://127.0.0.1:8080", App: "demo"})
+//
+// host, ok := cli.Get("db", "host")
+//
+// cli.OnChange(func(event *client.ChangeEvent) { reload(event.Files()) })
+//
+// defer cli.Close()
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"github.com/cflion/cflion/pkg/transport/signature"
+	"github.com/coreos/etcd/clientv3"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout    = 5 * time.Second
+	defaultRequestTimeout = 3 * time.Second
+	retryInterval         = 3 * time.Second
+)
+
+// Config defines how a client finds and watches the config of an app.
+type Config struct {
+	// ManagerEndpoint is the address of cflion-manager, e.g. http://127.0.0.1:8080.
+	ManagerEndpoint string
+	// Secret signs the requests to the manager, it is the auth.clientSecret of the manager.
+	Secret string
+	App    string
+	// Endpoints and Key are resolved from the manager when any of them is empty.
+	Endpoints []string
+	Key       string
+	// GrayKey is the key of the gray release, derived from App when it is not resolved.
+	GrayKey string
+	// ManifestKey is the key of the manifest in the files layout, derived from App when it is not resolved.
+	// The config is read from the files listed in the manifest if it exists, or from Key otherwise.
+	ManifestKey string
+	// InstanceId and IP identify the instance in the rule of a gray release,
+	// IP defaults to the first non-loopback IPv4 address.
+	InstanceId     string
+	IP             string
+	DialTimeout    time.Duration
+	RequestTimeout time.Duration
+	// CacheDir is the directory to save the last received config, no snapshot is saved when it is empty.
+	CacheDir string
+}
+
+// Client holds the latest config of an app and keeps it updated by watching etcd.
+type Client struct {
+	cfg *Config
+	cli *clientv3.Client
+
+	mu       sync.RWMutex
+	data     *snapshot
+	stale    bool
+	main     string
+	revision int64
+	// gray is the gray release matching the instance, nil if there is none
+	gray         *api.GrayRelease
+	grayRevision int64
+	listeners    []func(event *ChangeEvent)
+
+	// layoutFiles and fileContents are only accessed by the loop, fileContents holds the files of the
+	// last manifest indexed by key
+	layoutFiles  bool
+	fileContents map[string]string
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running bool
+	done    chan struct{}
+}
+
+// snapshot is the parsed config of an app at a revision.
+type snapshot struct {
+	content string
+	files   []*api.ConfigFile
+	values  map[string]map[string]string
+}
+
+// New creates a client, loads the current config of the app and starts watching it.
+//
+// When CacheDir is set and etcd can not be reached, the client starts with the last snapshot
+// saved in CacheDir and reconciles the config once it connects to etcd.
+func New(cfg *Config) (*Client, error) {
+	if len(cfg.App) == 0 {
+		return nil, errors.New("client: app is required")
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = defaultRequestTimeout
+	}
+	c := newClient(cfg)
+	cached := c.readSnapshot()
+	if len(cfg.Endpoints) == 0 || len(cfg.Key) == 0 {
+		if err := resolveWatcher(cfg); err != nil {
+			if cached == nil {
+				return nil, err
+			}
+			log.Warnf("Resolve watcher of app [name=%s] error, use the cached one: %s", cfg.App, err)
+			if len(cfg.Endpoints) == 0 {
+				cfg.Endpoints = cached.Endpoints
+			}
+			if len(cfg.Key) == 0 {
+				cfg.Key = cached.Key
+			}
+		}
+	}
+	if len(cfg.GrayKey) == 0 {
+		cfg.GrayKey = (&api.App{Name: cfg.App}).GrayKey()
+	}
+	if len(cfg.ManifestKey) == 0 {
+		cfg.ManifestKey = (&api.App{Name: cfg.App}).ManifestKey()
+	}
+	if len(cfg.IP) == 0 {
+		cfg.IP = localIP()
+	}
+	if err := c.connect(); err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		c.restore(cached)
+		log.Warnf("Etcd [%s] is unreachable, app [name=%s] is running on stale config of [revision=%d] saved at %s: %s",
+			cfg.Endpoints, cfg.App, cached.Revision, cached.Time.Format(time.RFC3339), err)
+		c.start(c.reconnect)
+		return c, nil
+	}
+	c.start(c.watch)
+	return c, nil
+}
+
+func newClient(cfg *Config) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		cfg:    cfg,
+		data:   parseSnapshot(""),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// resolveWatcher queries the etcd endpoints and key of the app from the manager.
+func resolveWatcher(cfg *Config) error {
+	if len(cfg.ManagerEndpoint) == 0 {
+		return errors.New("client: manager endpoint is required when etcd endpoints or key is empty")
+	}
+	httpClient := http.Client{Timeout: cfg.RequestTimeout}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/watchers?app=%s", cfg.ManagerEndpoint, url.QueryEscape(cfg.App)), nil)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Secret) > 0 {
+		if err = signature.SignRequest(req, cfg.Secret, ""); err != nil {
+			return err
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Errorf("Query watcher of app [name=%s] error: %s", cfg.App, err)
+		return err
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		Msg  string `json:"msg"`
+		Data struct {
+			Key         string   `json:"key"`
+			GrayKey     string   `json:"gray_key"`
+			ManifestKey string   `json:"manifest_key"`
+			Endpoints   []string `json:"endpoints"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("client: query watcher of app [name=%s] failed: [status=%d] %s", cfg.App, resp.StatusCode, ret.Msg)
+	}
+	if len(cfg.Endpoints) == 0 {
+		cfg.Endpoints = ret.Data.Endpoints
+	}
+	if len(cfg.Key) == 0 {
+		cfg.Key = ret.Data.Key
+	}
+	if len(cfg.GrayKey) == 0 {
+		cfg.GrayKey = ret.Data.GrayKey
+	}
+	if len(cfg.ManifestKey) == 0 {
+		cfg.ManifestKey = ret.Data.ManifestKey
+	}
+	return nil
+}
+
+// localIP returns the first non-loopback IPv4 address of the host.
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Warnf("Get interface addresses error: %s", err)
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
+
+// load gets the current config and gray release of the app from etcd at the same revision.
+func (c *Client) load() error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.RequestTimeout)
+	resp, err := c.cli.Txn(ctx).Then(clientv3.OpGet(c.cfg.Key), clientv3.OpGet(c.cfg.GrayKey), clientv3.OpGet(c.cfg.ManifestKey)).Commit()
+	cancel()
+	if err != nil {
+		log.Errorf("Get [key=%s] [gray_key=%s] [manifest_key=%s] from etcd error: %s", c.cfg.Key, c.cfg.GrayKey, c.cfg.ManifestKey, err)
+		return err
+	}
+	values := make([]string, 3)
+	for i, r := range resp.Responses {
+		if kvs := r.GetResponseRange().Kvs; len(kvs) > 0 {
+			values[i] = string(kvs[0].Value)
+		}
+	}
+	content := values[0]
+	c.layoutFiles = len(values[2]) > 0
+	if c.layoutFiles {
+		if content, err = c.fetchFiles(values[2], resp.Header.Revision); err != nil {
+			return err
+		}
+	}
+	c.update(&content, &values[1], resp.Header.Revision)
+	return nil
+}
+
+// fetchFiles gets the files listed in the manifest at the revision and joins them like the content of Key,
+// the files whose checksums are unchanged are taken from the last manifest.
+func (c *Client) fetchFiles(value string, revision int64) (string, error) {
+	var manifest api.Manifest
+	if err := json.Unmarshal([]byte(value), &manifest); err != nil {
+		log.Errorf("Unmarshal manifest [key=%s] error: %s", c.cfg.ManifestKey, err)
+		return "", err
+	}
+	contents := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if content, ok := c.fileContents[file.Key]; ok && api.Checksum(content) == file.Checksum {
+			contents[file.Key] = content
+			continue
+		}
+		ctx, cancel := context.WithTimeout(c.ctx, c.cfg.RequestTimeout)
+		resp, err := c.cli.Get(ctx, file.Key, clientv3.WithRev(revision))
+		cancel()
+		if err != nil {
+			log.Errorf("Get [key=%s] at [revision=%d] from etcd error: %s", file.Key, revision, err)
+			return "", err
+		}
+		var content string
+		if len(resp.Kvs) > 0 {
+			content = string(resp.Kvs[0].Value)
+		}
+		if api.Checksum(content) != file.Checksum {
+			return "", fmt.Errorf("client: checksum of [key=%s] at [revision=%d] doesn't match the manifest", file.Key, revision)
+		}
+		contents[file.Key] = content
+	}
+	c.fileContents = contents
+	return manifest.ConfigFmt(contents), nil
+}
+
+// connect connects to etcd and loads the current config.
+func (c *Client) connect() error {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   c.cfg.Endpoints,
+		DialTimeout: c.cfg.DialTimeout,
+	})
+	if err != nil {
+		log.Errorf("Connect to etcd [%s] error: %s", c.cfg.Endpoints, err)
+		return err
+	}
+	c.cli = cli
+	if err = c.load(); err != nil {
+		c.cli = nil
+		cli.Close()
+		return err
+	}
+	return nil
+}
+
+// reconnect keeps connecting to etcd until it succeeds, then reconciles the stale config and watches.
+func (c *Client) reconnect() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			close(c.done)
+			return
+		case <-time.After(retryInterval):
+		}
+		c.mu.Lock()
+		// the revision of etcd may go back after restoring from a backup, always take the loaded config
+		c.revision = 0
+		c.grayRevision = 0
+		c.mu.Unlock()
+		if err := c.connect(); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		c.stale = false
+		c.mu.Unlock()
+		log.Infof("Reconnect to etcd [%s], app [name=%s] is running on config of [revision=%d]", c.cfg.Endpoints, c.cfg.App, c.Revision())
+		c.watch()
+		return
+	}
+}
+
+// start runs the background loop, which must close done after the client is closed.
+func (c *Client) start(loop func()) {
+	c.running = true
+	go loop()
+}
+
+// watch keeps watching the keys of the app until the client is closed.
+func (c *Client) watch() {
+	defer close(c.done)
+	for {
+		ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(c.ctx))
+		c.watchKeys(ctx)
+		cancel()
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+		// the watch may have missed events, resync before watching again
+		if err := c.load(); err != nil {
+			log.Warnf("Resync [key=%s] error: %s", c.cfg.Key, err)
+		}
+	}
+}
+
+// watchKeys watches the key, the gray key and the manifest key of the app until any of the watches fails.
+func (c *Client) watchKeys(ctx context.Context) {
+	c.mu.RLock()
+	revision, grayRevision := c.revision, c.grayRevision
+	c.mu.RUnlock()
+	wch := c.cli.Watch(ctx, c.cfg.Key, clientv3.WithRev(revision+1))
+	gch := c.cli.Watch(ctx, c.cfg.GrayKey, clientv3.WithRev(grayRevision+1))
+	mch := c.cli.Watch(ctx, c.cfg.ManifestKey, clientv3.WithRev(revision+1))
+	for {
+		var resp clientv3.WatchResponse
+		var ok, gray, manifest bool
+		select {
+		case resp, ok = <-wch:
+		case resp, ok = <-gch:
+			gray = true
+		case resp, ok = <-mch:
+			manifest = true
+		}
+		if !ok {
+			return
+		}
+		if err := resp.Err(); err != nil {
+			log.Warnf("Watch [key=%s] [gray=%t] [manifest=%t] error: %s", c.cfg.Key, gray, manifest, err)
+			return
+		}
+		for _, ev := range resp.Events {
+			var value string
+			if ev.Type == clientv3.EventTypePut {
+				value = string(ev.Kv.Value)
+			}
+			var err error
+			switch {
+			case gray:
+				c.applyGray(value, ev.Kv.ModRevision)
+			case manifest && ev.Type == clientv3.EventTypePut:
+				err = c.applyManifest(value, ev.Kv.ModRevision)
+			case manifest, ev.Type == clientv3.EventTypeDelete && !c.layoutFiles:
+				// the layout may be switched, in which the events of the two keys arrive in any order
+				err = c.load()
+			case !c.layoutFiles:
+				c.apply(value, ev.Kv.ModRevision)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// apply replaces the content of the key at the revision.
+func (c *Client) apply(content string, revision int64) {
+	c.update(&content, nil, revision)
+}
+
+// applyManifest replaces the content with the files listed in the manifest at the revision.
+func (c *Client) applyManifest(value string, revision int64) error {
+	content, err := c.fetchFiles(value, revision)
+	if err != nil {
+		return err
+	}
+	c.layoutFiles = true
+	c.apply(content, revision)
+	return nil
+}
+
+// applyGray replaces the gray release at the revision, the value is empty if the gray release is removed.
+func (c *Client) applyGray(value string, revision int64) {
+	c.update(nil, &value, revision)
+}
+
+// update replaces the content and the gray release which are not nil, switches the config to the
+// gray content if the gray release matches the instance or to the content otherwise,
+// and notifies the listeners of the changes.
+func (c *Client) update(content *string, gray *string, revision int64) {
+	c.mu.Lock()
+	var applied bool
+	if content != nil && revision >= c.revision {
+		applied = c.main != *content
+		c.main = *content
+		c.revision = revision
+	}
+	if gray != nil && revision >= c.grayRevision {
+		old := c.gray
+		c.gray = c.matchGray(*gray)
+		c.grayRevision = revision
+		applied = applied || old != nil || c.gray != nil
+	}
+	effective := c.main
+	if c.gray != nil {
+		effective = c.gray.Content
+	}
+	if effective == c.data.content {
+		c.mu.Unlock()
+		// the main config is saved apart from the gray release, which may change without the effective config
+		if applied {
+			c.saveSnapshot()
+		}
+		return
+	}
+	old := c.data
+	c.data = parseSnapshot(effective)
+	changes := diffValues(old.values, c.data.values)
+	listeners := make([]func(event *ChangeEvent), len(c.listeners))
+	copy(listeners, c.listeners)
+	c.mu.Unlock()
+	c.saveSnapshot()
+	if len(changes) == 0 {
+		return
+	}
+	event := &ChangeEvent{Revision: revision, Changes: changes}
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// matchGray parses the value of the gray key, returns nil if the gray release doesn't match the instance.
+func (c *Client) matchGray(value string) *api.GrayRelease {
+	if len(value) == 0 {
+		return nil
+	}
+	var gray api.GrayRelease
+	if err := json.Unmarshal([]byte(value), &gray); err != nil {
+		log.Errorf("Unmarshal gray release [key=%s] error: %s", c.cfg.GrayKey, err)
+		return nil
+	}
+	if !gray.Rule.Match(c.cfg.InstanceId, c.cfg.IP) {
+		return nil
+	}
+	return &gray
+}
+
+func parseSnapshot(content string) *snapshot {
+	files := api.ParseConfigFmt(content)
+	values := make(map[string]map[string]string, len(files))
+	for _, cf := range files {
+		kv := make(map[string]string, len(cf.Items))
+		for _, item := range cf.Items {
+			kv[item.Name] = item.Value
+		}
+		values[cf.Name] = kv
+	}
+	return &snapshot{content: content, files: files, values: values}
+}
+
+// OnChange registers a listener called after the config changes.
+func (c *Client) OnChange(listener func(event *ChangeEvent)) {
+	c.mu.Lock()
+	c.listeners = append(c.listeners, listener)
+	c.mu.Unlock()
+}
+
+// Stale reports whether the client is running on the snapshot saved in CacheDir because etcd is unreachable.
+func (c *Client) Stale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stale
+}
+
+// Gray reports whether the client is running on a gray release.
+func (c *Client) Gray() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gray != nil
+}
+
+// Revision returns the etcd revision of the current config.
+func (c *Client) Revision() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.revision
+}
+
+// Get returns the value of the key in the file.
+func (c *Client) Get(file, key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.data.values[file][key]
+	return value, ok
+}
+
+// File returns a copy of all the key/value pairs in the file.
+func (c *Client) File(file string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	kv, ok := c.data.values[file]
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string, len(kv))
+	for k, v := range kv {
+		result[k] = v
+	}
+	return result
+}
+
+// Files returns the names of the files in publishing order.
+func (c *Client) Files() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.data.files))
+	for _, cf := range c.data.files {
+		names = append(names, cf.Name)
+	}
+	return names
+}
+
+// ConfigFiles returns the parsed config files, the result must not be modified.
+func (c *Client) ConfigFiles() []*api.ConfigFile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.data.files
+}
+
+// Close stops watching and closes the connection to etcd.
+func (c *Client) Close() error {
+	c.cancel()
+	if !c.running {
+		return nil
+	}
+	<-c.done
+	if c.cli == nil {
+		return nil
+	}
+	return c.cli.Close()
+}
@@ -0,0 +1,98 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import "testing"
+
+func TestClient_apply(t *testing.T) {
+	c := newClient(&Config{App: "demo"})
+	var events []*ChangeEvent
+	c.OnChange(func(event *ChangeEvent) {
+		events = append(events, event)
+	})
+	c.apply("[db]\nhost=127.0.0.1\nport=3306\n", 2)
+	if host, ok := c.Get("db", "host"); !ok || host != "127.0.0.1" {
+		t.Errorf("expect host 127.0.0.1, got %s", host)
+	}
+	c.apply("[db]\nhost=10.0.0.1\nuser=root\n", 5)
+	// stale revision is ignored
+	c.apply("[db]\nhost=127.0.0.1\n", 3)
+	if len(events) != 2 {
+		t.Fatalf("expect 2 events, got %d", len(events))
+	}
+	changes := events[1].Changes
+	expected := []*Change{
+		{File: "db", Key: "host", Type: Modified, OldValue: "127.0.0.1", NewValue: "10.0.0.1"},
+		{File: "db", Key: "port", Type: Deleted, OldValue: "3306"},
+		{File: "db", Key: "user", Type: Added, NewValue: "root"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expect %s, got %s", expected, changes)
+	}
+	for i := range expected {
+		if *changes[i] != *expected[i] {
+			t.Errorf("expect %s, got %s", expected[i], changes[i])
+		}
+	}
+	if c.Revision() != 5 {
+		t.Errorf("expect revision 5, got %d", c.Revision())
+	}
+}
+
+func TestClient_File(t *testing.T) {
+	c := newClient(&Config{App: "demo"})
+	c.apply("[db]\nhost=127.0.0.1\n\n[redis]\naddr=127.0.0.1:6379\n", 1)
+	files := c.Files()
+	if len(files) != 2 || files[0] != "db" || files[1] != "redis" {
+		t.Errorf("expect [db redis], got %s", files)
+	}
+	kv := c.File("redis")
+	kv["addr"] = "changed"
+	if addr, _ := c.Get("redis", "addr"); addr != "127.0.0.1:6379" {
+		t.Errorf("File must return a copy, got %s", addr)
+	}
+	if c.File("none") != nil {
+		t.Errorf("expect nil for unknown file")
+	}
+}
+
+func TestClient_applyGray(t *testing.T) {
+	c := newClient(&Config{App: "demo", InstanceId: "i-1", IP: "10.0.1.5"})
+	var events []*ChangeEvent
+	c.OnChange(func(event *ChangeEvent) {
+		events = append(events, event)
+	})
+	c.apply("[db]\nhost=127.0.0.1\n", 2)
+	c.applyGray(`{"content":"[db]\nhost=10.0.0.1\n","rule":{"instances":["i-2"]}}`, 3)
+	if host, _ := c.Get("db", "host"); host != "127.0.0.1" || c.Gray() {
+		t.Errorf("expect the full release for an unmatched instance, got %s", host)
+	}
+	c.applyGray(`{"content":"[db]\nhost=10.0.0.1\n","rule":{"ips":["10.0.1.*"]}}`, 4)
+	if host, _ := c.Get("db", "host"); host != "10.0.0.1" || !c.Gray() {
+		t.Errorf("expect the gray release for a matched instance, got %s", host)
+	}
+	// the full release doesn't override the gray release
+	c.apply("[db]\nhost=127.0.0.2\n", 5)
+	if host, _ := c.Get("db", "host"); host != "10.0.0.1" {
+		t.Errorf("expect the gray release, got %s", host)
+	}
+	c.applyGray("", 6)
+	if host, _ := c.Get("db", "host"); host != "127.0.0.2" || c.Gray() {
+		t.Errorf("expect the full release after the gray release is removed, got %s", host)
+	}
+	if len(events) != 3 {
+		t.Errorf("expect 3 events, got %d", len(events))
+	}
+}
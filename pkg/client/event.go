@@ -0,0 +1,104 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ChangeType is the type of a changed key.
+type ChangeType int
+
+// Change types.
+const (
+	Added ChangeType = iota
+	Modified
+	Deleted
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a changed key of a file.
+type Change struct {
+	File     string
+	Key      string
+	Type     ChangeType
+	OldValue string
+	NewValue string
+}
+
+// ChangeEvent is passed to the listeners after the config changes.
+type ChangeEvent struct {
+	Revision int64
+	Changes  []*Change
+}
+
+func (change *Change) String() string {
+	return fmt.Sprintf("Change {File=%s | Key=%s | Type=%s | OldValue=%s | NewValue=%s}", change.File, change.Key, change.Type, change.OldValue, change.NewValue)
+}
+
+// Files returns the names of the changed files.
+func (event *ChangeEvent) Files() []string {
+	m := make(map[string]struct{}, len(event.Changes))
+	files := make([]string, 0, len(event.Changes))
+	for _, change := range event.Changes {
+		if _, ok := m[change.File]; !ok {
+			m[change.File] = struct{}{}
+			files = append(files, change.File)
+		}
+	}
+	return files
+}
+
+// diffValues compares the key/value pairs of every file, the changes are sorted by file and key.
+func diffValues(old, new map[string]map[string]string) []*Change {
+	changes := make([]*Change, 0, 8)
+	for file, kv := range new {
+		for key, value := range kv {
+			oldValue, ok := old[file][key]
+			if !ok {
+				changes = append(changes, &Change{File: file, Key: key, Type: Added, NewValue: value})
+			} else if oldValue != value {
+				changes = append(changes, &Change{File: file, Key: key, Type: Modified, OldValue: oldValue, NewValue: value})
+			}
+		}
+	}
+	for file, kv := range old {
+		for key, value := range kv {
+			if _, ok := new[file][key]; !ok {
+				changes = append(changes, &Change{File: file, Key: key, Type: Deleted, OldValue: value})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].File != changes[j].File {
+			return changes[i].File < changes[j].File
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
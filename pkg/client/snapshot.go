@@ -0,0 +1,108 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"github.com/cflion/cflion/pkg/common"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/cflion/cflion/pkg/manager/api"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedSnapshot is the last received config of an app saved in the cache directory. Content is the main
+// config at Revision, the gray release matching the instance is kept apart in Gray at GrayRevision.
+type cachedSnapshot struct {
+	App          string           `json:"app"`
+	Key          string           `json:"key"`
+	Endpoints    []string         `json:"endpoints"`
+	Revision     int64            `json:"revision"`
+	Content      string           `json:"content"`
+	Gray         *api.GrayRelease `json:"gray,omitempty"`
+	GrayRevision int64            `json:"gray_revision,omitempty"`
+	Time         time.Time        `json:"time"`
+}
+
+func (c *Client) snapshotPath() string {
+	return filepath.Join(c.cfg.CacheDir, url.PathEscape(c.cfg.App)+".snapshot")
+}
+
+// readSnapshot reads the saved snapshot, returns nil if there is no valid one.
+func (c *Client) readSnapshot() *cachedSnapshot {
+	if len(c.cfg.CacheDir) == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(c.snapshotPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Read snapshot [%s] error: %s", c.snapshotPath(), err)
+		}
+		return nil
+	}
+	var cached cachedSnapshot
+	if err = json.Unmarshal(b, &cached); err != nil || cached.App != c.cfg.App {
+		log.Warnf("Invalid snapshot [%s]: %v", c.snapshotPath(), err)
+		return nil
+	}
+	return &cached
+}
+
+// saveSnapshot writes the current config to the cache directory atomically.
+func (c *Client) saveSnapshot() {
+	if len(c.cfg.CacheDir) == 0 {
+		return
+	}
+	c.mu.RLock()
+	cached := &cachedSnapshot{
+		App:          c.cfg.App,
+		Key:          c.cfg.Key,
+		Endpoints:    c.cfg.Endpoints,
+		Revision:     c.revision,
+		Content:      c.main,
+		Gray:         c.gray,
+		GrayRevision: c.grayRevision,
+		Time:         time.Now(),
+	}
+	c.mu.RUnlock()
+	b, err := json.Marshal(cached)
+	if err != nil {
+		log.Errorf("Marshal snapshot of app [name=%s] error: %s", c.cfg.App, err)
+		return
+	}
+	if err = common.WriteFileAtomic(c.snapshotPath(), b, 0644); err != nil {
+		log.Errorf("Save snapshot [%s] error: %s", c.snapshotPath(), err)
+	}
+}
+
+// restore replaces the config with the saved snapshot and marks it stale, the saved gray release
+// is only taken if it still matches the instance.
+func (c *Client) restore(cached *cachedSnapshot) {
+	c.mu.Lock()
+	c.main = cached.Content
+	c.revision = cached.Revision
+	c.gray, c.grayRevision = nil, cached.GrayRevision
+	effective := cached.Content
+	if cached.Gray != nil && cached.Gray.Rule.Match(c.cfg.InstanceId, c.cfg.IP) {
+		c.gray = cached.Gray
+		effective = cached.Gray.Content
+	}
+	c.data = parseSnapshot(effective)
+	c.stale = true
+	c.mu.Unlock()
+}
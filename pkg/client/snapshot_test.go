@@ -0,0 +1,94 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClient_Snapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{App: "demo", Key: "/cflion/demo", Endpoints: []string{"127.0.0.1:2379"}, CacheDir: dir}
+	c := newClient(cfg)
+	if c.readSnapshot() != nil {
+		t.Fatal("expect no snapshot")
+	}
+	c.apply("[db]\nhost=127.0.0.1\n", 7)
+
+	restored := newClient(&Config{App: "demo", CacheDir: dir})
+	cached := restored.readSnapshot()
+	if cached == nil {
+		t.Fatal("expect a snapshot")
+	}
+	if cached.Key != cfg.Key || cached.Revision != 7 || len(cached.Endpoints) != 1 {
+		t.Errorf("unexpected snapshot %+v", cached)
+	}
+	restored.restore(cached)
+	if host, _ := restored.Get("db", "host"); host != "127.0.0.1" || !restored.Stale() || restored.Revision() != 7 {
+		t.Errorf("unexpected restored config [host=%s] [stale=%t] [revision=%d]", host, restored.Stale(), restored.Revision())
+	}
+	if other := newClient(&Config{App: "other", CacheDir: dir}); other.readSnapshot() != nil {
+		t.Errorf("expect no snapshot of another app")
+	}
+}
+
+func TestClient_SnapshotGray(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cflion-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newClient(&Config{App: "demo", InstanceId: "i-1", CacheDir: dir})
+	c.apply("[db]\nhost=127.0.0.1\n", 7)
+	c.applyGray(`{"content":"[db]\nhost=10.0.0.1\n","rule":{"instances":["i-1"]}}`, 8)
+	if host, _ := c.Get("db", "host"); host != "10.0.0.1" || !c.Gray() {
+		t.Fatalf("expect the gray config, got [host=%s]", host)
+	}
+
+	// the main config changes under the gray release
+	c.apply("[db]\nhost=127.0.0.2\n", 9)
+	cached := newClient(&Config{App: "demo", CacheDir: dir}).readSnapshot()
+	if cached == nil || cached.Content != "[db]\nhost=127.0.0.2\n" || cached.Revision != 9 || cached.Gray == nil || cached.GrayRevision != 8 {
+		t.Fatalf("expect the main config apart from the gray release, got %+v", cached)
+	}
+	// the gray release is only restored on the instances matching its rule
+	gray := newClient(&Config{App: "demo", InstanceId: "i-1", CacheDir: dir})
+	gray.restore(cached)
+	if host, _ := gray.Get("db", "host"); host != "10.0.0.1" || !gray.Gray() {
+		t.Errorf("expect the gray config restored, got [host=%s]", host)
+	}
+	other := newClient(&Config{App: "demo", InstanceId: "i-2", CacheDir: dir})
+	other.restore(cached)
+	if host, _ := other.Get("db", "host"); host != "127.0.0.2" || other.Gray() || other.Revision() != 9 {
+		t.Errorf("expect the main config restored, got [host=%s] [gray=%t]", host, other.Gray())
+	}
+
+	// the main config is saved after the gray release is abandoned
+	c.applyGray("", 10)
+	cached = c.readSnapshot()
+	if cached == nil || cached.Gray != nil {
+		t.Fatalf("expect no gray release in snapshot, got %+v", cached)
+	}
+	gray.restore(cached)
+	if host, _ := gray.Get("db", "host"); host != "127.0.0.2" || gray.Gray() {
+		t.Errorf("expect the main config restored, got [host=%s]", host)
+	}
+}
@@ -0,0 +1,209 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"github.com/cflion/cflion/pkg/log"
+	"github.com/spf13/cast"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tagName is the struct tag used by Unmarshal, e.g. `cflion:"host"`.
+const tagName = "cflion"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// GetString returns the value of the key in the file, or def if the key doesn't exist.
+func (c *Client) GetString(file, key string, def string) string {
+	value, ok := c.Get(file, key)
+	if !ok {
+		return def
+	}
+	return value
+}
+
+// GetInt returns the value of the key in the file as int, or def if the key doesn't exist or isn't an int.
+func (c *Client) GetInt(file, key string, def int) int {
+	value, ok := c.Get(file, key)
+	if !ok {
+		return def
+	}
+	// the value is decimal, a leading zero doesn't make it octal
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		log.Warnf("Convert [file=%s] [key=%s] [value=%s] to int error: %s", file, key, value, err)
+		return def
+	}
+	return i
+}
+
+// GetBool returns the value of the key in the file as bool, or def if the key doesn't exist or isn't a bool.
+func (c *Client) GetBool(file, key string, def bool) bool {
+	value, ok := c.Get(file, key)
+	if !ok {
+		return def
+	}
+	b, err := cast.ToBoolE(strings.TrimSpace(value))
+	if err != nil {
+		log.Warnf("Convert [file=%s] [key=%s] [value=%s] to bool error: %s", file, key, value, err)
+		return def
+	}
+	return b
+}
+
+// GetDuration returns the value of the key in the file as duration, e.g. 300ms or 5s,
+// or def if the key doesn't exist or isn't a duration.
+func (c *Client) GetDuration(file, key string, def time.Duration) time.Duration {
+	value, ok := c.Get(file, key)
+	if !ok {
+		return def
+	}
+	d, err := cast.ToDurationE(strings.TrimSpace(value))
+	if err != nil {
+		log.Warnf("Convert [file=%s] [key=%s] [value=%s] to duration error: %s", file, key, value, err)
+		return def
+	}
+	return d
+}
+
+// GetStringSlice returns the comma separated value of the key in the file, or def if the key doesn't exist.
+func (c *Client) GetStringSlice(file, key string, def []string) []string {
+	value, ok := c.Get(file, key)
+	if !ok {
+		return def
+	}
+	return splitValue(value)
+}
+
+// Unmarshal binds the key/value pairs in the file to the struct pointed by v.
+//
+// The key of a field is the name in the `cflion` tag, or the lower case field name without tag,
+// `cflion:"-"` skips the field. A nested struct field binds the keys prefixed with its key and a dot,
+// e.g. the field Port of the field Server binds server.port. The fields without keys are left unchanged.
+func (c *Client) Unmarshal(file string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("client: unmarshal [file=%s] requires a non-nil struct pointer, got %T", file, v)
+	}
+	values := c.File(file)
+	if values == nil {
+		return fmt.Errorf("client: file [%s] doesn't exist", file)
+	}
+	return unmarshal(values, "", rv.Elem())
+}
+
+func unmarshal(values map[string]string, prefix string, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if len(field.PkgPath) > 0 {
+			// unexported
+			continue
+		}
+		name := field.Tag.Get(tagName)
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		key := prefix + name
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := unmarshal(values, key+".", fv); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			return fmt.Errorf("client: bind [key=%s] [value=%s] to field %s error: %s", key, value, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := cast.ToDurationE(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := cast.ToBoolE(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, fv.Type())
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, fv.Type())
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := cast.ToFloat64E(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		fv.Set(reflect.ValueOf(splitValue(value)).Convert(fv.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// splitValue splits the comma separated value and drops the blank elements.
+func splitValue(value string) []string {
+	parts := strings.Split(value, ",")
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if len(part) > 0 {
+			result = append(result, part)
+		}
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestClient(content string) *Client {
+	c := newClient(&Config{App: "demo"})
+	c.apply(content, 1)
+	return c
+}
+
+func TestClient_GetTyped(t *testing.T) {
+	c := newTestClient("[app]\nport=8080\ndebug=true\ntimeout=3s\nhosts=a, b,,c\nbad=x\nmode=010\nday=09\n")
+	if v := c.GetString("app", "none", "def"); v != "def" {
+		t.Errorf("expect def, got %s", v)
+	}
+	if v := c.GetInt("app", "port", 0); v != 8080 {
+		t.Errorf("expect 8080, got %d", v)
+	}
+	if v := c.GetInt("app", "bad", 1); v != 1 {
+		t.Errorf("expect default 1 for invalid int, got %d", v)
+	}
+	// the values with leading zeros are decimal
+	if v := c.GetInt("app", "mode", 0); v != 10 {
+		t.Errorf("expect 10, got %d", v)
+	}
+	if v := c.GetInt("app", "day", 0); v != 9 {
+		t.Errorf("expect 9, got %d", v)
+	}
+	if v := c.GetBool("app", "debug", false); !v {
+		t.Errorf("expect true, got %t", v)
+	}
+	if v := c.GetDuration("app", "timeout", 0); v != 3*time.Second {
+		t.Errorf("expect 3s, got %s", v)
+	}
+	if v := c.GetStringSlice("app", "hosts", nil); !reflect.DeepEqual(v, []string{"a", "b", "c"}) {
+		t.Errorf("expect [a b c], got %s", v)
+	}
+}
+
+func TestClient_Unmarshal(t *testing.T) {
+	c := newTestClient("[db]\nhost=127.0.0.1\nport=03306\nmaxIdle=020\ntimeout=500ms\ntags=a,b\nserver.name=demo\nretries=08\n")
+	var cfg struct {
+		Host    string
+		Port    uint16
+		MaxIdle int           `cflion:"maxIdle"`
+		Timeout time.Duration `cflion:"timeout"`
+		Tags    []string
+		Ignored string `cflion:"-"`
+		Missing string
+		Retries int64
+		Server  struct {
+			Name string
+		}
+	}
+	cfg.Missing = "default"
+	if err := c.Unmarshal("db", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "127.0.0.1" || cfg.Port != 3306 || cfg.MaxIdle != 20 || cfg.Retries != 8 || cfg.Timeout != 500*time.Millisecond {
+		t.Errorf("unexpected result %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Tags, []string{"a", "b"}) || cfg.Missing != "default" || cfg.Server.Name != "demo" {
+		t.Errorf("unexpected result %+v", cfg)
+	}
+}
+
+func TestClient_UnmarshalError(t *testing.T) {
+	c := newTestClient("[db]\nport=x\n")
+	var cfg struct {
+		Port int
+	}
+	if err := c.Unmarshal("db", &cfg); err == nil {
+		t.Errorf("expect error for invalid int")
+	}
+	if err := c.Unmarshal("none", &cfg); err == nil {
+		t.Errorf("expect error for unknown file")
+	}
+	if err := c.Unmarshal("db", cfg); err == nil {
+		t.Errorf("expect error for non-pointer")
+	}
+}
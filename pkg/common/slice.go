@@ -16,28 +16,24 @@ package common
 
 func DistinctIntSlice(a []int) []int {
 	m := make(map[int]struct{}, len(a))
+	r := make([]int, 0, len(a))
 	for _, key := range a {
-		m[key] = struct{}{}
-	}
-	r := make([]int, 0, len(m))
-	i := 0
-	for k := range m {
-		r[i] = k
-		i++
+		if _, ok := m[key]; !ok {
+			m[key] = struct{}{}
+			r = append(r, key)
+		}
 	}
 	return r
 }
 
 func DistinctInt64Slice(a []int64) []int64 {
 	m := make(map[int64]struct{}, len(a))
+	r := make([]int64, 0, len(a))
 	for _, key := range a {
-		m[key] = struct{}{}
-	}
-	r := make([]int64, 0, len(m))
-	i := 0
-	for k := range m {
-		r[i] = k
-		i++
+		if _, ok := m[key]; !ok {
+			m[key] = struct{}{}
+			r = append(r, key)
+		}
 	}
 	return r
 }
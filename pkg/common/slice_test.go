@@ -0,0 +1,30 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDistinctInt64Slice(t *testing.T) {
+	r := DistinctInt64Slice([]int64{3, 1, 3, 2, 1})
+	if !reflect.DeepEqual(r, []int64{3, 1, 2}) {
+		t.Errorf("expect [3 1 2], got %v", r)
+	}
+	if r = DistinctInt64Slice(nil); len(r) != 0 {
+		t.Errorf("expect empty slice, got %v", r)
+	}
+}
@@ -0,0 +1,95 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status of publish requests, a pending request is approved or rejected by another user,
+// and an approved one is published once. The pending and approved requests expire at ExpireTime.
+const (
+	PublishPending   = 0
+	PublishApproved  = 1
+	PublishRejected  = 2
+	PublishPublished = 3
+	PublishExpired   = 4
+)
+
+var publishStatusNames = map[byte]string{
+	PublishPending:   "pending",
+	PublishApproved:  "approved",
+	PublishRejected:  "rejected",
+	PublishPublished: "published",
+	PublishExpired:   "expired",
+}
+
+// PublishRequest asks to publish the app with the comment, and with the json gray rule if Gray isn't empty.
+type PublishRequest struct {
+	Id      int64
+	AppId   int64
+	Comment string
+	Gray    string
+	// Checksum of the config of the app in the manager when the request is created, only that config is published
+	Checksum   string
+	Requester  string
+	Reviewer   string
+	Status     byte
+	ExpireTime time.Time
+	Ctime      time.Time
+}
+
+// StatusError is returned when a publish request isn't in the status required by an operation.
+type StatusError struct {
+	Id     int64
+	Status byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("publish request [id=%d] is %s", e.Id, PublishStatusName(e.Status))
+}
+
+// PublishStatusName returns the name of the status, e.g. pending.
+func PublishStatusName(status byte) string {
+	if name, ok := publishStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", status)
+}
+
+func (request *PublishRequest) String() string {
+	return fmt.Sprintf("PublishRequest {Id=%d | AppId=%d | Requester=%s | Reviewer=%s | Status=%s | ExpireTime=%s}", request.Id, request.AppId, request.Requester, request.Reviewer, PublishStatusName(request.Status), request.ExpireTime)
+}
+
+// Expired reports whether the pending or approved request has expired at the time.
+func (request *PublishRequest) Expired(now time.Time) bool {
+	return (request.Status == PublishPending || request.Status == PublishApproved) && !now.Before(request.ExpireTime)
+}
+
+func (request *PublishRequest) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          request.Id,
+		"app_id":      request.AppId,
+		"comment":     request.Comment,
+		"gray":        request.Gray,
+		"checksum":    request.Checksum,
+		"requester":   request.Requester,
+		"reviewer":    request.Reviewer,
+		"status":      PublishStatusName(request.Status),
+		"expire_time": request.ExpireTime,
+		"ctime":       request.Ctime,
+	}
+}
@@ -14,7 +14,10 @@
 
 package api
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type Service interface {
 	ListApps() ([]map[string]interface{}, error)
@@ -22,6 +25,25 @@ type Service interface {
 	GetAppByName(name string) (*App, error)
 	ExistsAppByNameAndEnv(name, env string) bool
 	CreateApp(name, env string) (int64, error)
+	DeleteApp(id int64) error
+	Authenticate(token string) (*User, error)
+	ListUsers() ([]map[string]interface{}, error)
+	GetUserById(id int64) (*User, error)
+	ExistsUserByName(name string) bool
+	CreateUser(name string, admin bool) (int64, error)
+	CreateToken(userId int64, name string) (int64, string, error)
+	DeleteToken(userId, tokenId int64) error
+	Bootstrap(name, token string) error
+	GetRole(user *User, appId int64) Role
+	ListRoles(appId int64) ([]map[string]interface{}, error)
+	GrantRole(userId, appId int64, role Role) error
+	CreatePublishRequest(appId int64, comment, gray, checksum, requester string, ttl time.Duration) (int64, error)
+	// GetPublishRequest marks the request expired if it has expired.
+	GetPublishRequest(id int64) (*PublishRequest, error)
+	ListPublishRequests(appId int64) ([]map[string]interface{}, error)
+	// UpdatePublishRequestStatus fails with *StatusError if the status of the request isn't from,
+	// the reviewer is kept if it's empty.
+	UpdatePublishRequestStatus(id int64, from, to byte, reviewer string) error
 }
 
 type App struct {
@@ -0,0 +1,87 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Role is what a user can do on an app, a role includes all the lower roles.
+type Role byte
+
+const (
+	RoleNone      Role = 0
+	RoleViewer    Role = 1 // view the app and its config files
+	RoleEditor    Role = 2 // edit the config files and the association of the app
+	RolePublisher Role = 3 // publish, promote and abandon releases, delete the app
+)
+
+var roleNames = map[Role]string{
+	RoleNone:      "none",
+	RoleViewer:    "viewer",
+	RoleEditor:    "editor",
+	RolePublisher: "publisher",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", byte(r))
+}
+
+// ParseRole returns the role of the name, e.g. editor.
+func ParseRole(name string) (Role, bool) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return RoleNone, false
+}
+
+// User is a console user, an admin has every role on every app and manages the users.
+type User struct {
+	Id    int64
+	Name  string
+	Admin bool
+}
+
+// Token is an API token of a user, only the hash of the token is stored.
+type Token struct {
+	Id     int64
+	UserId int64
+	Name   string
+	Hash   string
+}
+
+// RoleBinding grants the role on the app to the user.
+type RoleBinding struct {
+	UserId int64
+	AppId  int64
+	Role   Role
+}
+
+func (user *User) String() string {
+	return fmt.Sprintf("User {Id=%d | Name=%s | Admin=%t}", user.Id, user.Name, user.Admin)
+}
+
+// HashToken returns the hex sha256 of the token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,108 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Actions of the audit events.
+const (
+	ActionCreateApp              = "create_app"
+	ActionUpdateAppAssociation   = "update_app_association"
+	ActionDeleteAppAssociation   = "delete_app_association"
+	ActionDeleteApp              = "delete_app"
+	ActionPublishApp             = "publish_app"
+	ActionPublishAppGray         = "publish_app_gray"
+	ActionPromoteGrayRelease     = "promote_gray_release"
+	ActionAbandonGrayRelease     = "abandon_gray_release"
+	ActionRollbackRelease        = "rollback_release"
+	ActionCreateConfigFile       = "create_config_file"
+	ActionUpdateConfigFile       = "update_config_file"
+	ActionDeleteConfigFile       = "delete_config_file"
+	ActionUpdateConfigFilePublic = "update_config_file_public"
+	ActionRollbackConfigFile     = "rollback_config_file"
+	ActionCreateConfigItem       = "create_config_item"
+	ActionUpdateConfigItem       = "update_config_item"
+	ActionDeleteConfigItem       = "delete_config_item"
+	ActionCreateWebhook          = "create_webhook"
+	ActionDeleteWebhook          = "delete_webhook"
+)
+
+// Operator is the user who performs an operation and the id of the request, they are recorded in the audit log.
+type Operator struct {
+	Name      string
+	RequestId string
+}
+
+// AuditEvent records who did what to which target, Before and After summarize the target around the operation.
+// AppId is the app of the target, which is the namespace app of a config file.
+type AuditEvent struct {
+	Id        int64
+	Actor     string
+	Action    string
+	AppId     int64
+	Target    string
+	Before    string
+	After     string
+	RequestId string
+	Ctime     time.Time
+}
+
+// AuditFilter selects the audit events created in [Since, Until), the zero fields match all,
+// and Limit 0 means no limit. The events are returned from the newest.
+type AuditFilter struct {
+	AppId int64
+	Actor string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+func (event *AuditEvent) String() string {
+	return fmt.Sprintf("AuditEvent {Id=%d | Actor=%s | Action=%s | AppId=%d | Target=%s | Before=%s | After=%s | RequestId=%s | Ctime=%s}", event.Id, event.Actor, event.Action, event.AppId, event.Target, event.Before, event.After, event.RequestId, event.Ctime)
+}
+
+func (event *AuditEvent) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         event.Id,
+		"actor":      event.Actor,
+		"action":     event.Action,
+		"app_id":     event.AppId,
+		"target":     event.Target,
+		"before":     event.Before,
+		"after":      event.After,
+		"request_id": event.RequestId,
+		"ctime":      event.Ctime,
+	}
+}
+
+// Match reports whether the event is selected by the filter regardless of Limit.
+func (filter *AuditFilter) Match(event *AuditEvent) bool {
+	if filter.AppId > 0 && event.AppId != filter.AppId {
+		return false
+	}
+	if len(filter.Actor) > 0 && event.Actor != filter.Actor {
+		return false
+	}
+	if !filter.Since.IsZero() && event.Ctime.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !event.Ctime.Before(filter.Until) {
+		return false
+	}
+	return true
+}
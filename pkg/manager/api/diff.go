@@ -0,0 +1,139 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Diff types.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// ConfigItemDiff describes a changed item, Old is nil for an added item and New is nil for a removed one.
+type ConfigItemDiff struct {
+	Name string
+	Type string
+	Old  *ConfigItem
+	New  *ConfigItem
+}
+
+// ConfigFileDiff describes a changed file and its changed items.
+type ConfigFileDiff struct {
+	Name  string
+	Type  string
+	Items []*ConfigItemDiff
+}
+
+// DiffConfigItems compares the items by name, the diffs are sorted by name.
+// The last one of the new items with the same name wins.
+func DiffConfigItems(old, new []*ConfigItem) []*ConfigItemDiff {
+	oldItems := make(map[string]*ConfigItem, len(old))
+	for _, item := range old {
+		oldItems[item.Name] = item
+	}
+	newItems := make(map[string]*ConfigItem, len(new))
+	for _, item := range new {
+		newItems[item.Name] = item
+	}
+	diffs := make([]*ConfigItemDiff, 0, 8)
+	for _, item := range new {
+		if newItems[item.Name] != item {
+			continue
+		}
+		oldItem, ok := oldItems[item.Name]
+		if !ok {
+			diffs = append(diffs, &ConfigItemDiff{Name: item.Name, Type: DiffAdded, New: item})
+		} else if oldItem.Value != item.Value || oldItem.Type != item.Type || oldItem.Comment != item.Comment {
+			diffs = append(diffs, &ConfigItemDiff{Name: item.Name, Type: DiffModified, Old: oldItem, New: item})
+		}
+	}
+	for _, item := range old {
+		if _, ok := newItems[item.Name]; !ok {
+			diffs = append(diffs, &ConfigItemDiff{Name: item.Name, Type: DiffRemoved, Old: item})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs
+}
+
+// DiffConfigFiles compares the files by name, only the changed files are returned in the order of new and then old.
+func DiffConfigFiles(old, new []*ConfigFile) []*ConfigFileDiff {
+	oldFiles := make(map[string]*ConfigFile, len(old))
+	for _, cf := range old {
+		oldFiles[cf.Name] = cf
+	}
+	newFiles := make(map[string]*ConfigFile, len(new))
+	diffs := make([]*ConfigFileDiff, 0, len(new))
+	for _, cf := range new {
+		newFiles[cf.Name] = cf
+		oldFile, ok := oldFiles[cf.Name]
+		if !ok {
+			diffs = append(diffs, &ConfigFileDiff{Name: cf.Name, Type: DiffAdded, Items: DiffConfigItems(nil, cf.Items)})
+			continue
+		}
+		if items := DiffConfigItems(oldFile.Items, cf.Items); len(items) > 0 {
+			diffs = append(diffs, &ConfigFileDiff{Name: cf.Name, Type: DiffModified, Items: items})
+		}
+	}
+	for _, cf := range old {
+		if _, ok := newFiles[cf.Name]; !ok {
+			diffs = append(diffs, &ConfigFileDiff{Name: cf.Name, Type: DiffRemoved, Items: DiffConfigItems(cf.Items, nil)})
+		}
+	}
+	return diffs
+}
+
+func (diff *ConfigItemDiff) String() string {
+	return fmt.Sprintf("ConfigItemDiff {Name=%s | Type=%s | Old=%s | New=%s}", diff.Name, diff.Type, diff.Old, diff.New)
+}
+
+func (diff *ConfigItemDiff) Detail() map[string]interface{} {
+	detail := map[string]interface{}{
+		"name": diff.Name,
+		"type": diff.Type,
+	}
+	if diff.Old != nil {
+		detail["old_value"] = diff.Old.Value
+		detail["old_comment"] = diff.Old.Comment
+	}
+	if diff.New != nil {
+		detail["new_value"] = diff.New.Value
+		detail["new_comment"] = diff.New.Comment
+	}
+	return detail
+}
+
+func (diff *ConfigFileDiff) String() string {
+	return fmt.Sprintf("ConfigFileDiff {Name=%s | Type=%s | Items=%s}", diff.Name, diff.Type, diff.Items)
+}
+
+func (diff *ConfigFileDiff) Detail() map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(diff.Items))
+	for _, item := range diff.Items {
+		items = append(items, item.Detail())
+	}
+	return map[string]interface{}{
+		"name":  diff.Name,
+		"type":  diff.Type,
+		"items": items,
+	}
+}
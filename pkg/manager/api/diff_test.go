@@ -0,0 +1,59 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import "testing"
+
+func TestDiffConfigFiles(t *testing.T) {
+	old := ParseConfigFmt("[db]\n# host\nhost=127.0.0.1\nport=3306\nuser=root\n\n[redis]\naddr=127.0.0.1:6379\n")
+	new := ParseConfigFmt("[db]\n# db host\nhost=127.0.0.1\nport=3307\npassword=secret\n\n[mq]\naddr=127.0.0.1:5672\n")
+	diffs := DiffConfigFiles(old, new)
+	expected := []struct {
+		name  string
+		typ   string
+		items map[string]string
+	}{
+		{"db", DiffModified, map[string]string{"host": DiffModified, "port": DiffModified, "password": DiffAdded, "user": DiffRemoved}},
+		{"mq", DiffAdded, map[string]string{"addr": DiffAdded}},
+		{"redis", DiffRemoved, map[string]string{"addr": DiffRemoved}},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("expect %d diffs, got %s", len(expected), diffs)
+	}
+	for i, e := range expected {
+		diff := diffs[i]
+		if diff.Name != e.name || diff.Type != e.typ || len(diff.Items) != len(e.items) {
+			t.Errorf("expect %s %s, got %s", e.name, e.typ, diff)
+			continue
+		}
+		for _, item := range diff.Items {
+			if e.items[item.Name] != item.Type {
+				t.Errorf("expect %s.%s %s, got %s", e.name, item.Name, e.items[item.Name], item)
+			}
+		}
+	}
+	if diffs := DiffConfigFiles(old, old); len(diffs) != 0 {
+		t.Errorf("expect no diff, got %s", diffs)
+	}
+}
+
+func TestDiffConfigItemsDuplicateName(t *testing.T) {
+	old := []*ConfigItem{{Name: "host", Value: "127.0.0.1"}}
+	new := []*ConfigItem{{Name: "host", Value: "10.0.0.1"}, {Name: "port", Value: "3306"}, {Name: "host", Value: "10.0.0.2"}}
+	diffs := DiffConfigItems(old, new)
+	if len(diffs) != 2 || diffs[0].Type != DiffModified || diffs[0].New.Value != "10.0.0.2" || diffs[1].Type != DiffAdded {
+		t.Errorf("expect the last host wins, got %s", diffs)
+	}
+}
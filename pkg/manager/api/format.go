@@ -0,0 +1,617 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of config files, the items of a yaml, json or toml file are the leaves of the document
+// with the nested keys flattened to dotted names, e.g. server.port, and the array elements indexed
+// like hosts.0. The dots and backslashes in a key are escaped by a backslash, e.g. the key a.b is the
+// item a\.b, as well as a key of digits which isn't an array index, e.g. the key 0 of a map is \0.
+const (
+	FormatProperties = "properties"
+	FormatYAML       = "yaml"
+	FormatJSON       = "json"
+	FormatTOML       = "toml"
+)
+
+// Types of the items of a yaml, json or toml file, so that the value is rendered back as the type parsed
+// from the content. The value of a number is its decimal text, the empty map and array are items
+// without value. The items of a properties file, and the ones saved before the types, have no type, and
+// their values are rendered as bools or numbers if they look like ones.
+const (
+	ItemString   = "string"
+	ItemNumber   = "number"
+	ItemBool     = "bool"
+	ItemNull     = "null"
+	ItemDatetime = "datetime"
+	ItemMap      = "map"
+	ItemArray    = "array"
+)
+
+// FormatError is returned when a content can't be parsed, or items can't be rendered, in a format.
+type FormatError struct {
+	Format string
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("invalid %s config: %s", e.Format, e.Err)
+}
+
+// ValidItemType reports whether the type is a type of items, the empty type means untyped.
+func ValidItemType(t string) bool {
+	switch t {
+	case "", ItemString, ItemNumber, ItemBool, ItemNull, ItemDatetime, ItemMap, ItemArray:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidFormat reports whether the format is supported, the empty format means properties.
+func ValidFormat(format string) bool {
+	switch format {
+	case "", FormatProperties, FormatYAML, FormatJSON, FormatTOML:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseContent parses the content of a config file in the format into items, which must be rendered back in
+// the format. The error of a properties content wraps a *ParseError, which is returned with the items of the valid lines.
+func ParseContent(format string, content string) ([]*ConfigItem, error) {
+	var doc interface{}
+	var err error
+	switch format {
+	case "", FormatProperties:
+		items, err := ParseConfigItems(content)
+		if err != nil {
+			return items, &FormatError{Format: FormatProperties, Err: err}
+		}
+		return items, nil
+	case FormatYAML:
+		var m yaml.MapSlice
+		err = yaml.Unmarshal([]byte(content), &m)
+		doc = m
+	case FormatJSON:
+		if len(strings.TrimSpace(content)) == 0 {
+			return []*ConfigItem{}, nil
+		}
+		decoder := json.NewDecoder(strings.NewReader(content))
+		decoder.UseNumber()
+		var m map[string]interface{}
+		if err = decoder.Decode(&m); err == nil {
+			if _, err = decoder.Token(); err == io.EOF {
+				err = nil
+			} else {
+				err = fmt.Errorf("unexpected data after the document")
+			}
+		}
+		doc = m
+	case FormatTOML:
+		var tree *toml.Tree
+		if tree, err = toml.Load(content); err == nil {
+			doc = tree.ToMap()
+		}
+	default:
+		return nil, &FormatError{Format: format, Err: fmt.Errorf("unsupported format")}
+	}
+	if err != nil {
+		return nil, &FormatError{Format: format, Err: err}
+	}
+	items := make([]*ConfigItem, 0, 16)
+	if err = flatten("", doc, &items); err != nil {
+		return nil, &FormatError{Format: format, Err: err}
+	}
+	// the items are published rendered, e.g. a toml key which needs quotes can't be
+	if _, err = RenderItems(format, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RenderItems renders the items as the content of a config file in the format.
+func RenderItems(format string, items []*ConfigItem) (string, error) {
+	if format == "" || format == FormatProperties {
+		arr := make([]string, 0, len(items))
+		for _, item := range items {
+			arr = append(arr, item.ConfigFmt())
+		}
+		return strings.Join(arr, "\n"), nil
+	}
+	if !ValidFormat(format) {
+		return "", &FormatError{Format: format, Err: fmt.Errorf("unsupported format")}
+	}
+	root, err := unflatten(items)
+	if err != nil {
+		return "", &FormatError{Format: format, Err: err}
+	}
+	var out []byte
+	var doc interface{}
+	switch format {
+	case FormatYAML:
+		if len(root.keys) == 0 {
+			return "", nil
+		}
+		if doc, err = root.yaml(); err == nil {
+			out, err = yaml.Marshal(doc)
+		}
+	case FormatJSON:
+		if doc, err = root.json(); err == nil {
+			out, err = json.MarshalIndent(doc, "", "  ")
+		}
+	case FormatTOML:
+		var m map[string]interface{}
+		var tree *toml.Tree
+		if m, err = root.toml(); err == nil {
+			if tree, err = toml.TreeFromMap(m); err == nil {
+				var s string
+				s, err = tree.ToTomlString()
+				out = []byte(s)
+			}
+		}
+	}
+	if err != nil {
+		return "", &FormatError{Format: format, Err: err}
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// flatten appends the leaves of the document to the items, an empty map or array is a leaf as well.
+func flatten(name string, v interface{}, items *[]*ConfigItem) error {
+	join := func(key string) string {
+		if len(name) == 0 {
+			return key
+		}
+		return name + "." + key
+	}
+	switch node := v.(type) {
+	case yaml.MapSlice:
+		if len(node) == 0 {
+			return flattenEmpty(name, ItemMap, items)
+		}
+		for _, item := range node {
+			if err := flatten(join(escapeMapKey(fmt.Sprint(item.Key))), item.Value, items); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		if len(node) == 0 {
+			return flattenEmpty(name, ItemMap, items)
+		}
+		keys := make([]string, 0, len(node))
+		values := make(map[string]interface{}, len(node))
+		for k, value := range node {
+			keys = append(keys, fmt.Sprint(k))
+			values[fmt.Sprint(k)] = value
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := flatten(join(escapeMapKey(key)), values[key], items); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if len(node) == 0 {
+			return flattenEmpty(name, ItemMap, items)
+		}
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := flatten(join(escapeMapKey(key)), node[key], items); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(node) == 0 {
+			return flattenEmpty(name, ItemArray, items)
+		}
+		for i, value := range node {
+			if err := flatten(join(strconv.Itoa(i)), value, items); err != nil {
+				return err
+			}
+		}
+	default:
+		if len(name) == 0 {
+			if v == nil {
+				return nil
+			}
+			return fmt.Errorf("the document must be a map, got %T", v)
+		}
+		*items = append(*items, &ConfigItem{Name: name, Value: scalarString(v), Type: scalarType(v)})
+	}
+	return nil
+}
+
+// flattenEmpty appends the empty map or array as an item, the empty document has no item.
+func flattenEmpty(name string, t string, items *[]*ConfigItem) error {
+	if len(name) > 0 {
+		*items = append(*items, &ConfigItem{Name: name, Type: t})
+	}
+	return nil
+}
+
+// escapeMapKey escapes the dots and backslashes in a key of a map, and a key of digits which would be an array index.
+func escapeMapKey(key string) string {
+	key = strings.Replace(key, `\`, `\\`, -1)
+	key = strings.Replace(key, ".", `\.`, -1)
+	if isIndex(key) {
+		key = `\` + key
+	}
+	return key
+}
+
+// splitName splits the item name at the dots which aren't escaped, a part is literal if it has an escape,
+// i.e. it's a key of a map even if it's digits.
+func splitName(name string) ([]string, []bool) {
+	parts := make([]string, 0, 4)
+	literals := make([]bool, 0, 4)
+	var part []byte
+	literal := false
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '\\' && i+1 < len(name):
+			i++
+			part = append(part, name[i])
+			literal = true
+		case c == '.':
+			parts, literals = append(parts, string(part)), append(literals, literal)
+			part, literal = nil, false
+		default:
+			part = append(part, c)
+		}
+	}
+	return append(parts, string(part)), append(literals, literal)
+}
+
+func isIndex(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, c := range key {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// scalarType is the type of an item parsed from a yaml, json or toml document.
+func scalarType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ItemNull
+	case string:
+		return ItemString
+	case bool:
+		return ItemBool
+	case time.Time:
+		return ItemDatetime
+	case json.Number, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return ItemNumber
+	default:
+		return ItemString
+	}
+}
+
+func scalarString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// scalarValue guesses the type of an untyped item value, the values which are not bool or number are strings.
+func scalarValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(i, 10) == s {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == s {
+		return f
+	}
+	return s
+}
+
+// typedValue converts the value of the item to its type in the format.
+func typedValue(format string, item *ConfigItem) (interface{}, error) {
+	switch item.Type {
+	case "":
+		return scalarValue(item.Value), nil
+	case ItemString:
+		return item.Value, nil
+	case ItemNumber:
+		if format == FormatJSON {
+			if _, err := strconv.ParseFloat(item.Value, 64); err != nil {
+				return nil, fmt.Errorf("item [%s] isn't a number: %s", item.Name, item.Value)
+			}
+			return json.Number(item.Value), nil
+		}
+		if i, err := strconv.ParseInt(item.Value, 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(item.Value, 10, 64); err == nil {
+			return u, nil
+		}
+		f, err := strconv.ParseFloat(item.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("item [%s] isn't a number: %s", item.Name, item.Value)
+		}
+		return f, nil
+	case ItemBool:
+		switch item.Value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("item [%s] isn't a bool: %s", item.Name, item.Value)
+	case ItemNull:
+		if format == FormatTOML {
+			return nil, fmt.Errorf("item [%s] can't be null in toml", item.Name)
+		}
+		return nil, nil
+	case ItemDatetime:
+		t, err := time.Parse(time.RFC3339Nano, item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("item [%s] isn't a RFC 3339 datetime: %s", item.Name, item.Value)
+		}
+		if format == FormatJSON {
+			return item.Value, nil
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("item [%s] has unknown type [%s]", item.Name, item.Type)
+	}
+}
+
+// node is a map of the document which keeps the order of keys, a leaf is an item.
+type node struct {
+	keys   []string
+	values map[string]interface{}
+	// literal is set when a key is escaped, the node is a map even if its keys are the indexes
+	literal bool
+	// empty is ItemMap or ItemArray if the node is the empty map or array of an item
+	empty string
+}
+
+func newNode() *node {
+	return &node{values: make(map[string]interface{})}
+}
+
+// unflatten builds the document from the dotted item names.
+func unflatten(items []*ConfigItem) (*node, error) {
+	root := newNode()
+	for _, item := range items {
+		parts, literals := splitName(item.Name)
+		current := root
+		for i, part := range parts {
+			if len(part) == 0 {
+				return nil, fmt.Errorf("item [%s] has an empty key", item.Name)
+			}
+			current.literal = current.literal || literals[i]
+			value, ok := current.values[part]
+			if i == len(parts)-1 {
+				if ok {
+					return nil, fmt.Errorf("item [%s] conflicts with another item", item.Name)
+				}
+				current.keys = append(current.keys, part)
+				if item.Type == ItemMap || item.Type == ItemArray {
+					current.values[part] = &node{values: map[string]interface{}{}, empty: item.Type}
+				} else {
+					current.values[part] = item
+				}
+				break
+			}
+			if !ok {
+				child := newNode()
+				current.keys = append(current.keys, part)
+				current.values[part] = child
+				current = child
+				continue
+			}
+			child, ok := value.(*node)
+			if !ok || len(child.empty) > 0 {
+				return nil, fmt.Errorf("item [%s] conflicts with item [%s]", item.Name, strings.Join(parts[:i+1], "."))
+			}
+			current = child
+		}
+	}
+	return root, nil
+}
+
+// isArray reports whether the node is an empty array, or its keys are the indexes 0 to n-1.
+func (n *node) isArray() bool {
+	if n.empty == ItemArray {
+		return true
+	}
+	if len(n.keys) == 0 || n.literal {
+		return false
+	}
+	for i := range n.keys {
+		if _, ok := n.values[strconv.Itoa(i)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// convert converts the node into the map or array of a format, the leaves are converted by typedValue.
+// The root is always a map.
+func (n *node) convert(format string, newMap func(keys []string, values []interface{}) interface{}, root bool) (interface{}, error) {
+	convertValue := func(value interface{}) (interface{}, error) {
+		if child, ok := value.(*node); ok {
+			return child.convert(format, newMap, false)
+		}
+		return typedValue(format, value.(*ConfigItem))
+	}
+	if !root && n.isArray() {
+		arr := make([]interface{}, 0, len(n.keys))
+		for i := range n.keys {
+			v, err := convertValue(n.values[strconv.Itoa(i)])
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	values := make([]interface{}, 0, len(n.keys))
+	for _, key := range n.keys {
+		v, err := convertValue(n.values[key])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return newMap(n.keys, values), nil
+}
+
+func (n *node) yaml() (interface{}, error) {
+	return n.convert(FormatYAML, func(keys []string, values []interface{}) interface{} {
+		m := make(yaml.MapSlice, 0, len(keys))
+		for i, key := range keys {
+			m = append(m, yaml.MapItem{Key: key, Value: values[i]})
+		}
+		return m
+	}, true)
+}
+
+func (n *node) json() (interface{}, error) {
+	return n.convert(FormatJSON, func(keys []string, values []interface{}) interface{} {
+		return &jsonObject{keys: keys, values: values}
+	}, true)
+}
+
+func (n *node) toml() (map[string]interface{}, error) {
+	if err := n.checkTomlKeys(); err != nil {
+		return nil, err
+	}
+	doc, err := n.convert(FormatTOML, func(keys []string, values []interface{}) interface{} {
+		m := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			m[key] = values[i]
+		}
+		return m
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return tomlArrays(doc).(map[string]interface{}), nil
+}
+
+// checkTomlKeys rejects the keys which need quotes in toml, the toml writer doesn't quote them.
+func (n *node) checkTomlKeys() error {
+	for _, key := range n.keys {
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				return fmt.Errorf("key [%s] can't be written in toml without quotes", key)
+			}
+		}
+		if child, ok := n.values[key].(*node); ok {
+			if err := child.checkTomlKeys(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tomlArrays converts the arrays of mixed types to string arrays, the values of a toml array
+// must be the same type.
+func tomlArrays(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = tomlArrays(child)
+		}
+	case []interface{}:
+		mixed := false
+		for i, child := range value {
+			value[i] = tomlArrays(child)
+			mixed = mixed || fmt.Sprintf("%T", value[i]) != fmt.Sprintf("%T", value[0])
+		}
+		if mixed {
+			return stringValues(value)
+		}
+	}
+	return v
+}
+
+func stringValues(arr []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(arr))
+	for _, v := range arr {
+		result = append(result, scalarString(v))
+	}
+	return result
+}
+
+// jsonObject is a json object which keeps the order of keys.
+type jsonObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseContent(t *testing.T) {
+	cases := []struct {
+		format  string
+		content string
+	}{
+		{FormatYAML, "server:\n  port: 8080\n  hosts:\n  - a\n  - b\nname: demo\ndebug: true\n"},
+		{FormatJSON, `{"server": {"port": 8080, "hosts": ["a", "b"]}, "name": "demo", "debug": true}`},
+		{FormatTOML, "name = \"demo\"\ndebug = true\n\n[server]\nport = 8080\nhosts = [\"a\", \"b\"]\n"},
+	}
+	expected := map[string]string{"server.port": "8080", "server.hosts.0": "a", "server.hosts.1": "b", "name": "demo", "debug": "true"}
+	for _, c := range cases {
+		items, err := ParseContent(c.format, c.content)
+		if err != nil {
+			t.Errorf("parse %s error: %s", c.format, err)
+			continue
+		}
+		if len(items) != len(expected) {
+			t.Errorf("expect %d %s items, got %s", len(expected), c.format, items)
+		}
+		for _, item := range items {
+			if expected[item.Name] != item.Value {
+				t.Errorf("expect %s %s=%s, got %s", c.format, item.Name, expected[item.Name], item.Value)
+			}
+		}
+		// rendered items are parsed back to the same items
+		content, err := RenderItems(c.format, items)
+		if err != nil {
+			t.Errorf("render %s error: %s", c.format, err)
+			continue
+		}
+		again, err := ParseContent(c.format, content)
+		if err != nil || fmt.Sprint(DiffConfigItems(items, again)) != "[]" {
+			t.Errorf("expect %s round trip, got %s: %v", c.format, content, err)
+		}
+	}
+}
+
+func TestParseContent_Invalid(t *testing.T) {
+	for format, content := range map[string]string{
+		FormatYAML: "a: [1, 2",
+		FormatJSON: `["a", "b"]`,
+		FormatTOML: "a = ",
+		"xml":      "<a>1</a>",
+	} {
+		if _, err := ParseContent(format, content); err == nil {
+			t.Errorf("expect %s error for %q", format, content)
+		} else if _, ok := err.(*FormatError); !ok {
+			t.Errorf("expect FormatError, got %T", err)
+		}
+	}
+	// the data after the json document isn't ignored
+	for _, content := range []string{`{}garbage`, `{"a": 1} {"b": 2}`} {
+		if _, err := ParseContent(FormatJSON, content); err == nil {
+			t.Errorf("expect json error for %q", content)
+		}
+	}
+}
+
+func TestParseContent_Typed(t *testing.T) {
+	cases := []struct {
+		format  string
+		content string
+	}{
+		{FormatYAML, "port: \"8080\"\nenabled: \"true\"\nratio: 1.50\nnone: null\na.b: 1\nlabels: {}\nhosts: []\ncodes:\n  \"0\": x\n"},
+		{FormatJSON, `{"port": "8080", "enabled": "true", "ratio": 1.50, "none": null, "a.b": 1, "labels": {}, "hosts": [], "codes": {"0": "x"}}`},
+		{FormatTOML, "port = \"8080\"\nenabled = \"true\"\nratio = 1.5\nhosts = []\n\n[codes]\n\"0\" = \"x\"\n"},
+	}
+	for _, c := range cases {
+		items, err := ParseContent(c.format, c.content)
+		if err != nil {
+			t.Errorf("parse %s error: %s", c.format, err)
+			continue
+		}
+		types := make(map[string]string, len(items))
+		for _, item := range items {
+			types[item.Name] = item.Type + ":" + item.Value
+		}
+		want := map[string]string{"port": "string:8080", "enabled": "string:true", `a\.b`: "number:1", "hosts": "array:", `codes.\0`: "string:x"}
+		if c.format == FormatTOML {
+			delete(want, `a\.b`)
+		}
+		for name, expected := range want {
+			if types[name] != expected {
+				t.Errorf("expect %s item [%s] %s, got %s", c.format, name, expected, types[name])
+			}
+		}
+		// the strings which look like numbers or bools, the dotted keys and the empty containers are kept
+		content, err := RenderItems(c.format, items)
+		if err != nil {
+			t.Errorf("render %s error: %s", c.format, err)
+			continue
+		}
+		again, err := ParseContent(c.format, content)
+		if err != nil || fmt.Sprint(DiffConfigItems(items, again)) != "[]" {
+			t.Errorf("expect %s round trip, got %s: %v", c.format, content, err)
+		}
+	}
+	// the toml writer can't quote the dotted key
+	if _, err := ParseContent(FormatTOML, "\"a.b\" = 1\n"); err == nil {
+		t.Error("expect toml error for the key which needs quotes")
+	}
+	if content, _ := RenderItems(FormatJSON, []*ConfigItem{{Name: "ratio", Value: "1.50", Type: ItemNumber}}); content != "{\n  \"ratio\": 1.50\n}" {
+		t.Errorf("expect the number rendered as parsed, got %s", content)
+	}
+	for _, item := range []*ConfigItem{{Name: "port", Value: "x", Type: ItemNumber}, {Name: "on", Value: "yes", Type: ItemBool}, {Name: "at", Value: "today", Type: ItemDatetime}} {
+		if _, err := RenderItems(FormatYAML, []*ConfigItem{item}); err == nil {
+			t.Errorf("expect error for the value of %s", item)
+		}
+	}
+}
+
+func TestRenderItems(t *testing.T) {
+	items := []*ConfigItem{{Name: "name", Value: "demo"}, {Name: "server.port", Value: "8080"}, {Name: "server.ratio", Value: "0.5"}, {Name: "zip", Value: "007"}}
+	cases := map[string]string{
+		FormatProperties: "name=demo\nserver.port=8080\nserver.ratio=0.5\nzip=007",
+		FormatYAML:       "name: demo\nserver:\n  port: 8080\n  ratio: 0.5\nzip: \"007\"",
+		FormatJSON:       "{\n  \"name\": \"demo\",\n  \"server\": {\n    \"port\": 8080,\n    \"ratio\": 0.5\n  },\n  \"zip\": \"007\"\n}",
+	}
+	for format, expected := range cases {
+		if content, err := RenderItems(format, items); err != nil || content != expected {
+			t.Errorf("expect %s:\n%s\ngot:\n%s: %v", format, expected, content, err)
+		}
+	}
+	conflicts := []*ConfigItem{{Name: "server", Value: "a"}, {Name: "server.port", Value: "8080"}}
+	if _, err := RenderItems(FormatYAML, conflicts); err == nil {
+		t.Error("expect error for the conflicting items")
+	}
+}
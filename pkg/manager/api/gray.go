@@ -0,0 +1,72 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"path"
+)
+
+// GrayRule selects the instances receiving a gray release, an instance matches the rule
+// if its id is in Instances or its ip matches any of the IPs patterns, e.g. 10.0.1.*.
+type GrayRule struct {
+	Instances []string `json:"instances,omitempty"`
+	IPs       []string `json:"ips,omitempty"`
+}
+
+// GrayRelease is the value of App.GrayKey() in etcd.
+type GrayRelease struct {
+	Content string    `json:"content"`
+	Rule    *GrayRule `json:"rule"`
+}
+
+func (rule *GrayRule) String() string {
+	return fmt.Sprintf("GrayRule {Instances=%s | IPs=%s}", rule.Instances, rule.IPs)
+}
+
+// Validate checks the rule selects some instances and the ip patterns are valid.
+func (rule *GrayRule) Validate() error {
+	if len(rule.Instances) == 0 && len(rule.IPs) == 0 {
+		return fmt.Errorf("gray rule must have instances or ips")
+	}
+	for _, pattern := range rule.IPs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ip pattern [%s]: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the instance matches the rule.
+func (rule *GrayRule) Match(instanceId string, ip string) bool {
+	if rule == nil {
+		return false
+	}
+	if len(instanceId) > 0 {
+		for _, id := range rule.Instances {
+			if id == instanceId {
+				return true
+			}
+		}
+	}
+	if len(ip) > 0 {
+		for _, pattern := range rule.IPs {
+			if ok, _ := path.Match(pattern, ip); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import "testing"
+
+func TestGrayRule_Match(t *testing.T) {
+	rule := &GrayRule{Instances: []string{"web-1"}, IPs: []string{"10.0.1.*", "192.168.0.8"}}
+	cases := []struct {
+		instanceId string
+		ip         string
+		match      bool
+	}{
+		{"web-1", "", true},
+		{"web-2", "10.0.1.15", true},
+		{"", "192.168.0.8", true},
+		{"web-2", "10.0.2.15", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		if rule.Match(c.instanceId, c.ip) != c.match {
+			t.Errorf("expect [instance=%s] [ip=%s] match %t", c.instanceId, c.ip, c.match)
+		}
+	}
+	var nilRule *GrayRule
+	if nilRule.Match("web-1", "10.0.1.1") {
+		t.Errorf("expect nil rule matches nothing")
+	}
+}
+
+func TestGrayRule_Validate(t *testing.T) {
+	if err := (&GrayRule{}).Validate(); err == nil {
+		t.Errorf("expect error for empty rule")
+	}
+	if err := (&GrayRule{IPs: []string{"10.0.[1"}}).Validate(); err == nil {
+		t.Errorf("expect error for invalid pattern")
+	}
+	if err := (&GrayRule{Instances: []string{"web-1"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
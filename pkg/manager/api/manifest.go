@@ -0,0 +1,96 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Publish layouts, the blob layout puts the whole config of an app in App.Key(), the files layout puts
+// every file in App.FileKey(name) and the Manifest in App.ManifestKey().
+const (
+	LayoutBlob  = "blob"
+	LayoutFiles = "files"
+)
+
+// Manifest is the value of App.ManifestKey() in etcd, which lists the files of a release in publishing order.
+type Manifest struct {
+	App       string          `json:"app"`
+	ReleaseId int64           `json:"release_id"`
+	Checksum  string          `json:"checksum"`
+	Timestamp time.Time       `json:"timestamp"`
+	Files     []*ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of the manifest, Checksum is the checksum of the value of Key.
+type ManifestFile struct {
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+}
+
+// ValidLayout reports whether the layout is supported, the empty layout means blob.
+func ValidLayout(layout string) bool {
+	return layout == "" || layout == LayoutBlob || layout == LayoutFiles
+}
+
+// Checksum returns the hex encoded sha256 of the content.
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ConfigFmt joins the contents of the files, which are indexed by key, in the same way as App.ConfigFmt
+// with [name:format] headers.
+func (manifest *Manifest) ConfigFmt(contents map[string]string) string {
+	arr := make([]string, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		arr = append(arr, fmt.Sprintf("[%s:%s]\n%s\n", file.Name, file.Format, contents[file.Key]))
+	}
+	return strings.Join(arr, "\n")
+}
+
+// NewManifest builds the manifest of a release of the app from the content generated by App.ConfigFmt,
+// and returns the contents of the files indexed by key.
+func NewManifest(app *App, releaseId int64, content string) (*Manifest, map[string]string) {
+	cfs := ParseConfigFmt(content)
+	manifest := &Manifest{
+		App:       app.Name,
+		ReleaseId: releaseId,
+		Checksum:  Checksum(content),
+		Timestamp: time.Now(),
+		Files:     make([]*ManifestFile, 0, len(cfs)),
+	}
+	contents := make(map[string]string, len(cfs))
+	indexes := make(map[string]int, len(cfs))
+	for _, cf := range cfs {
+		key := app.FileKey(cf.Name)
+		contents[key] = cf.ConfigFmt()
+		file := &ManifestFile{Name: cf.Name, Format: cf.format(), Key: key, Checksum: Checksum(contents[key])}
+		// the last one of the files with the same name wins, as the clients do
+		if i, ok := indexes[key]; ok {
+			manifest.Files[i] = file
+			continue
+		}
+		indexes[key] = len(manifest.Files)
+		manifest.Files = append(manifest.Files, file)
+	}
+	return manifest, contents
+}
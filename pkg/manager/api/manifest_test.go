@@ -0,0 +1,54 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import "testing"
+
+func TestNewManifest(t *testing.T) {
+	app := &App{Name: "demo", Files: []*ConfigFile{
+		{Name: "db", Format: FormatProperties, Items: []*ConfigItem{{Name: "host", Value: "127.0.0.1"}}},
+		{Name: "server", Format: FormatYAML, Items: []*ConfigItem{{Name: "server.port", Value: "8080", Type: ItemNumber}}},
+	}}
+	content := app.ConfigFmt()
+	manifest, contents := NewManifest(app, 3, content)
+	if manifest.App != "demo" || manifest.ReleaseId != 3 || manifest.Checksum != Checksum(content) || len(manifest.Files) != 2 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	db := manifest.Files[0]
+	if db.Name != "db" || db.Format != FormatProperties || db.Key != "/cflion/demo/db" || contents[db.Key] != "host=127.0.0.1" || db.Checksum != Checksum("host=127.0.0.1") {
+		t.Errorf("unexpected file %+v with content %q", db, contents[db.Key])
+	}
+	if server := manifest.Files[1]; server.Format != FormatYAML || contents[server.Key] != "server:\n  port: 8080" {
+		t.Errorf("unexpected file %+v with content %q", server, contents[server.Key])
+	}
+	cfs := ParseConfigFmt(manifest.ConfigFmt(contents))
+	if len(DiffConfigFiles(app.Files, cfs)) != 0 {
+		t.Errorf("expect the joined files to be the same as the app, got %s", cfs)
+	}
+}
+
+func TestAppNameOfKey(t *testing.T) {
+	app := &App{Name: "demo"}
+	for _, key := range []string{app.Key(), app.GrayKey(), app.ManifestKey(), app.FileKey("db")} {
+		if name, ok := AppNameOfKey(key); !ok || name != "demo" {
+			t.Errorf("expect app demo of key [%s], got [%s] %t", key, name, ok)
+		}
+	}
+	for _, key := range []string{"/cflion", "/cflion/", "/other/demo", "/cflion-x/demo"} {
+		if name, ok := AppNameOfKey(key); ok {
+			t.Errorf("expect no app of key [%s], got [%s]", key, name)
+		}
+	}
+}
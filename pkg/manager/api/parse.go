@@ -0,0 +1,286 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"github.com/cflion/cflion/pkg/log"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ParseError reports the lines of a properties content which can't be parsed.
+type ParseError struct {
+	Lines []*LineError
+}
+
+// LineError is an invalid line, the line number starts from 1.
+type LineError struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Msg  string `json:"msg"`
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, 0, len(e.Lines))
+	for _, line := range e.Lines {
+		msgs = append(msgs, line.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseConfigItems parses the key/value pairs of a properties content, which are separated by the first
+// unescaped '=' or ':'. A line ending with an odd number of backslashes continues on the next line,
+// the escapes like \n, \t, \uXXXX and \= are replaced in keys and values. The lines beginning with
+// '#' or '!' are the comment of the next item. The items of the valid lines are returned with
+// a *ParseError if any line is invalid.
+func ParseConfigItems(content string) ([]*ConfigItem, error) {
+	lines := strings.Split(content, "\n")
+	items := make([]*ConfigItem, 0, len(lines))
+	comments := make([]string, 0, 4)
+	var errs []*LineError
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimLeft(strings.TrimSuffix(lines[i], "\r"), " \t\f")
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' || line[0] == '!' {
+			comments = append(comments, strings.TrimSpace(line[1:]))
+			continue
+		}
+		text := line
+		for continued(line) && i+1 < len(lines) {
+			i++
+			next := strings.TrimLeft(strings.TrimSuffix(lines[i], "\r"), " \t\f")
+			line = line[:len(line)-1] + next
+			text += "\n" + lines[i]
+		}
+		if continued(line) {
+			line = line[:len(line)-1]
+		}
+		item, err := parseProperty(line)
+		if err != nil {
+			errs = append(errs, &LineError{Line: number, Text: text, Msg: err.Error()})
+			comments = comments[:0]
+			continue
+		}
+		item.Comment = strings.Join(comments, "\n")
+		comments = comments[:0]
+		items = append(items, item)
+	}
+	if len(errs) > 0 {
+		return items, &ParseError{Lines: errs}
+	}
+	return items, nil
+}
+
+// continued reports whether the line ends with an odd number of backslashes.
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// parseProperty splits the logical line by the first unescaped '=' or ':'.
+func parseProperty(line string) (*ConfigItem, error) {
+	sep := -1
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		} else if line[i] == '=' || line[i] == ':' {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return nil, fmt.Errorf("missing '=' or ':' between key and value")
+	}
+	name, err := unescape(strings.TrimRight(line[:sep], " \t\f"))
+	if err != nil {
+		return nil, err
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	value, err := unescape(strings.TrimLeft(line[sep+1:], " \t\f"))
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigItem{Name: name, Value: value}, nil
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			r, n, err := unescapeUnicode(s[i+1:])
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+			i += n
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// unescapeUnicode decodes the 4 hex digits after \u, and the low surrogate after a high one,
+// it returns the rune and the number of bytes consumed.
+func unescapeUnicode(s string) (rune, int, error) {
+	if len(s) < 4 {
+		return 0, 0, fmt.Errorf("invalid unicode escape \\u%s", s)
+	}
+	code, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid unicode escape \\u%s", s[:4])
+	}
+	r := rune(code)
+	if utf16.IsSurrogate(r) && len(s) >= 10 && s[4:6] == "\\u" {
+		if low, err := strconv.ParseUint(s[6:10], 16, 16); err == nil {
+			if pair := utf16.DecodeRune(r, rune(low)); pair != utf8.RuneError {
+				return pair, 10, nil
+			}
+		}
+	}
+	return r, 4, nil
+}
+
+// escapeKey escapes a key to be parsed back by ParseConfigItems.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '=' || r == ':' || (i == 0 && (r == '#' || r == '!')):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			writeEscaped(&b, r, i == 0)
+		}
+	}
+	return b.String()
+}
+
+// escapeValue escapes a value to be parsed back by ParseConfigItems.
+func escapeValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		writeEscaped(&b, r, i == 0)
+	}
+	return b.String()
+}
+
+func writeEscaped(b *strings.Builder, r rune, first bool) {
+	switch r {
+	case '\\':
+		b.WriteString("\\\\")
+	case '\n':
+		b.WriteString("\\n")
+	case '\r':
+		b.WriteString("\\r")
+	case '\f':
+		b.WriteString("\\f")
+	case '\t':
+		if first {
+			b.WriteString("\\t")
+		} else {
+			b.WriteRune(r)
+		}
+	case ' ':
+		if first {
+			b.WriteString("\\ ")
+		} else {
+			b.WriteRune(r)
+		}
+	default:
+		b.WriteRune(r)
+	}
+}
+
+// ParseConfigFmt parses the content generated by App.ConfigFmt back into config files.
+func ParseConfigFmt(content string) []*ConfigFile {
+	lines := strings.Split(content, "\n")
+	cfs := make([]*ConfigFile, 0, 8)
+	var current *ConfigFile
+	body := make([]string, 0, len(lines))
+	flush := func() {
+		if current != nil {
+			items, err := ParseContent(current.Format, strings.Join(body, "\n"))
+			if err != nil {
+				log.Errorf("Parse config file [%s] error: %s", current.Name, err)
+			}
+			current.Items = items
+			cfs = append(cfs, current)
+		}
+		body = body[:0]
+	}
+	for _, line := range lines {
+		// a [name] line is a header only in properties files, a [name:format] line is always a header
+		if name, format, ok := sectionHeader(line); ok && (len(format) > 0 || current == nil || current.Format == FormatProperties) {
+			flush()
+			if len(format) == 0 {
+				format = FormatProperties
+			}
+			current = &ConfigFile{Name: name, Format: format}
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	return cfs
+}
+
+// sectionHeader returns the file name and format if the line is a section header like [name] or [name:format],
+// the format is empty for [name].
+func sectionHeader(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' || strings.Contains(line, "=") {
+		return "", "", false
+	}
+	name := strings.TrimSpace(line[1 : len(line)-1])
+	if i := strings.LastIndex(name, ":"); i > 0 {
+		if format := name[i+1:]; ValidFormat(format) && len(format) > 0 {
+			return name[:i], format, true
+		}
+	}
+	return name, "", true
+}
@@ -0,0 +1,151 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import "testing"
+
+func TestParseConfigFmt(t *testing.T) {
+	app := &App{Name: "demo", Files: []*ConfigFile{
+		{Name: "db", Items: []*ConfigItem{
+			{Name: "host", Value: "127.0.0.1", Comment: "db host"},
+			{Name: "port", Value: "3306"},
+		}},
+		{Name: "redis", Items: []*ConfigItem{
+			{Name: "addr", Value: "127.0.0.1:6379"},
+		}},
+	}}
+	cfs := ParseConfigFmt(app.ConfigFmt())
+	if len(cfs) != 2 {
+		t.Fatalf("expect 2 files, got %d", len(cfs))
+	}
+	for i, cf := range cfs {
+		expected := app.Files[i]
+		if cf.Name != expected.Name || cf.ConfigFmt() != expected.ConfigFmt() {
+			t.Errorf("expect %s, got %s", expected, cf)
+		}
+	}
+}
+
+func TestParseConfigFmt_Empty(t *testing.T) {
+	if cfs := ParseConfigFmt(""); len(cfs) != 0 {
+		t.Errorf("expect no file, got %s", cfs)
+	}
+}
+
+func TestParseConfigFmt_Formats(t *testing.T) {
+	app := &App{Name: "demo", Files: []*ConfigFile{
+		{Name: "server.toml", Format: FormatTOML, Items: []*ConfigItem{
+			{Name: "name", Value: "demo"},
+			{Name: "server.port", Value: "8080"},
+		}},
+		{Name: "db", Items: []*ConfigItem{
+			{Name: "host", Value: "127.0.0.1"},
+		}},
+		{Name: "app.yml", Format: FormatYAML, Items: []*ConfigItem{
+			{Name: "hosts.0", Value: "10.0.0.1"},
+			{Name: "hosts.1", Value: "10.0.0.2"},
+		}},
+	}}
+	cfs := ParseConfigFmt(app.ConfigFmt())
+	if len(cfs) != 3 {
+		t.Fatalf("expect 3 files, got %s", cfs)
+	}
+	formats := []string{FormatTOML, FormatProperties, FormatYAML}
+	for i, cf := range cfs {
+		expected := app.Files[i]
+		if cf.Name != expected.Name || cf.Format != formats[i] || cf.ConfigFmt() != expected.ConfigFmt() {
+			t.Errorf("expect %s, got %s", expected, cf)
+		}
+	}
+}
+
+func TestApp_ConfigFmt_Headers(t *testing.T) {
+	db := &ConfigFile{Name: "db", Items: []*ConfigItem{{Name: "host", Value: "127.0.0.1"}}}
+	redis := &ConfigFile{Name: "redis", Format: FormatProperties, Items: []*ConfigItem{{Name: "addr", Value: "127.0.0.1:6379"}}}
+	server := &ConfigFile{Name: "server", Format: FormatTOML, Items: []*ConfigItem{{Name: "port", Value: "8080", Type: ItemNumber}}}
+	if content := (&App{Files: []*ConfigFile{db, redis}}).ConfigFmt(); content != "[db]\nhost=127.0.0.1\n\n[redis]\naddr=127.0.0.1:6379\n" {
+		t.Errorf("expect the headers of properties files without format, got %q", content)
+	}
+	content := (&App{Files: []*ConfigFile{db, server, redis}}).ConfigFmt()
+	if expected := "[db]\nhost=127.0.0.1\n\n[server:toml]\nport = 8080\n\n[redis:properties]\naddr=127.0.0.1:6379\n"; content != expected {
+		t.Errorf("expect %q, got %q", expected, content)
+	}
+	cfs := ParseConfigFmt(content)
+	if len(cfs) != 3 || cfs[0].Format != FormatProperties || cfs[1].Format != FormatTOML || cfs[2].Name != "redis" || cfs[2].Format != FormatProperties {
+		t.Errorf("expect db, server and redis parsed back, got %s", cfs)
+	}
+}
+
+func TestParseConfigItems(t *testing.T) {
+	content := "# database\n" +
+		"# primary only\n" +
+		"dsn=root:secret@tcp(127.0.0.1:3306)/db?charset=utf8&parseTime=true\n" +
+		"token : YWJj==\n" +
+		"! bang comment\n" +
+		"hosts = 10.0.0.1,\\\n" +
+		"        10.0.0.2\n" +
+		"greeting=\\u4f60\\u597d\\uD83D\\uDE00\n" +
+		"key\\=with\\:separators=multi\\nline\\tvalue\n" +
+		"empty=\n" +
+		"path=C:\\\\temp\\\\\n"
+	items, err := ParseConfigItems(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*ConfigItem{
+		{Name: "dsn", Value: "root:secret@tcp(127.0.0.1:3306)/db?charset=utf8&parseTime=true", Comment: "database\nprimary only"},
+		{Name: "token", Value: "YWJj=="},
+		{Name: "hosts", Value: "10.0.0.1,10.0.0.2", Comment: "bang comment"},
+		{Name: "greeting", Value: "你好😀"},
+		{Name: "key=with:separators", Value: "multi\nline\tvalue"},
+		{Name: "empty", Value: ""},
+		{Name: "path", Value: "C:\\temp\\"},
+	}
+	if len(items) != len(expected) {
+		t.Fatalf("expect %d items, got %s", len(expected), items)
+	}
+	for i, item := range items {
+		e := expected[i]
+		if item.Name != e.Name || item.Value != e.Value || item.Comment != e.Comment {
+			t.Errorf("expect %s, got %s", e, item)
+		}
+	}
+	// the items are written back to the same items
+	cf := &ConfigFile{Items: items}
+	again, err := ParseConfigItems(cf.ConfigFmt())
+	if err != nil || len(DiffConfigItems(items, again)) != 0 {
+		t.Errorf("expect round trip of\n%s\ngot %s: %v", cf.ConfigFmt(), again, err)
+	}
+}
+
+func TestParseConfigItems_Error(t *testing.T) {
+	items, err := ParseConfigItems("host=127.0.0.1\nno separator\n=value\nchar=\\uZZZZ\nport=3306")
+	if len(items) != 2 || items[0].Name != "host" || items[1].Name != "port" {
+		t.Errorf("expect the valid items, got %s", items)
+	}
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expect ParseError, got %v", err)
+	}
+	lines := []int{2, 3, 4}
+	if len(parseErr.Lines) != len(lines) {
+		t.Fatalf("expect lines %v, got %s", lines, parseErr)
+	}
+	for i, line := range parseErr.Lines {
+		if line.Line != lines[i] {
+			t.Errorf("expect line %d, got %s", lines[i], line)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import "fmt"
+
+// AppConfig is the published config of an app for an instance, which is the gray release if Gray is true.
+// Revision is the revision of the last change of the app, the config is newer than the one of a smaller revision.
+type AppConfig struct {
+	App      string
+	Revision int64
+	Content  string
+	Gray     bool
+}
+
+func (config *AppConfig) String() string {
+	return fmt.Sprintf("AppConfig {App=%s | Revision=%d | Gray=%t}", config.App, config.Revision, config.Gray)
+}
+
+func (config *AppConfig) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"app":      config.App,
+		"revision": config.Revision,
+		"content":  config.Content,
+		"gray":     config.Gray,
+	}
+}
@@ -15,8 +15,13 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/cflion/cflion/pkg/log"
 	"strings"
+	"time"
 )
 
 type Service interface {
@@ -24,17 +29,79 @@ type Service interface {
 	ExistsAppById(id int64) bool
 	ExistsAppByName(name string) bool
 	GetAppByName(name string) (*App, error)
-	CreateApp(name string) (int64, error)
+	CreateApp(name string, operator *Operator) (int64, error)
 	ViewApp(id int64) (map[string]interface{}, error)
-	UpdateAppAssociation(id int64, fileIds []int64) error
-	PublishApp(id int64) error
+	// UpdateAppAssociation fails with *AssociationError if a new file doesn't exist,
+	// or is a private file in the namespace of another app.
+	UpdateAppAssociation(id int64, fileIds []int64, operator *Operator) error
+	ExistsAppAssociation(appId int64, fileId int64) bool
+	DeleteAppAssociation(appId int64, fileId int64, operator *Operator) error
+	DeleteApp(id int64, operator *Operator) error
+	// ListAppConsumers returns the other apps associated with the config files in the namespace of the app.
+	ListAppConsumers(id int64) ([]*App, error)
+	// PublishApp fails with *ChecksumConflictError if the checksum isn't the one of the current config,
+	// an empty checksum matches any.
+	PublishApp(id int64, checksum string, operator *Operator, comment string) error
+	// PublishAppGray fails with *ChecksumConflictError as PublishApp.
+	PublishAppGray(id int64, rule *GrayRule, checksum string, operator *Operator, comment string) error
+	ExistsGrayRelease(appId int64) bool
+	// PromoteGrayRelease fails with *ChecksumConflictError if the checksum isn't the one of the gray release,
+	// an empty checksum matches any.
+	PromoteGrayRelease(appId int64, checksum string, operator *Operator) error
+	AbandonGrayRelease(appId int64, operator *Operator) error
+
+	ListReleases(appId int64) ([]map[string]interface{}, error)
+	ExistsRelease(appId int64, id int64) bool
+	RollbackRelease(appId int64, id int64, operator *Operator) error
+	// DiffApp returns the changes of the current config since the latest release and the checksum of the current config.
+	DiffApp(id int64) (map[string]interface{}, error)
+	// PollAppConfig returns the published config of the app for the instance if it's newer than the revision,
+	// otherwise it waits for a change of the app up to wait, and returns nil if nothing changes.
+	PollAppConfig(ctx context.Context, name string, revision int64, wait time.Duration, instanceId string, ip string) (*AppConfig, error)
 
 	ListConfigFiles() ([]map[string]interface{}, error)
 	ExistsConfigFileByNameAndNamespaceId(filename string, namespaceId int64) bool
 	ExistsConfigFileById(id int64) bool
-	CreateConfigFile(name string, namespaceId int64, content string) (int64, error)
+	CreateConfigFile(name string, namespaceId int64, format string, public bool, content string, operator *Operator) (int64, error)
 	ViewConfigFile(id int64) (map[string]interface{}, error)
-	UpdateConfigFile(id int64, content string) error
+	// UpdateConfigFile fails with *VersionConflictError if the version isn't the current one, version 0 matches any.
+	UpdateConfigFile(id int64, version int64, content string, operator *Operator) (map[string]interface{}, error)
+	DeleteConfigFile(id int64, operator *Operator) error
+	// ListConfigFileConsumers returns the apps associated with the config file except its namespace app.
+	ListConfigFileConsumers(id int64) ([]*App, error)
+	// ListConfigFileApps returns all the apps associated with the config file, including its namespace app.
+	ListConfigFileApps(id int64) ([]map[string]interface{}, error)
+	// UpdateConfigFilePublic marks the config file public, which can be associated with the apps of other namespaces.
+	UpdateConfigFilePublic(id int64, public bool, operator *Operator) error
+
+	ListConfigItems(fileId int64) ([]map[string]interface{}, error)
+	ExistsConfigItem(fileId int64, id int64) bool
+	ExistsConfigItemByName(fileId int64, name string) bool
+	GetConfigItem(id int64) (*ConfigItem, error)
+	ViewConfigItem(id int64) (map[string]interface{}, error)
+	// CreateConfigItem, UpdateConfigItem and DeleteConfigItem return the version of the file after changing,
+	// they fail with *VersionConflictError as UpdateConfigFile.
+	CreateConfigItem(item *ConfigItem, version int64, operator *Operator) (int64, int64, error)
+	UpdateConfigItem(item *ConfigItem, version int64, operator *Operator) (int64, error)
+	DeleteConfigItem(fileId int64, id int64, version int64, operator *Operator) (int64, error)
+
+	ListConfigFileRevisions(fileId int64) ([]map[string]interface{}, error)
+	ExistsConfigFileRevision(fileId int64, revision int64) bool
+	ViewConfigFileRevision(fileId int64, revision int64) (map[string]interface{}, error)
+	// RollbackConfigFile returns the version of the file after rolling back, it fails with *VersionConflictError
+	// as UpdateConfigFile.
+	RollbackConfigFile(fileId int64, revision int64, version int64, operator *Operator) (int64, error)
+
+	// ListAuditEvents returns the events of the operations changing apps, config files and webhooks selected by the filter.
+	ListAuditEvents(filter *AuditFilter) ([]map[string]interface{}, error)
+
+	// ListWebhooks returns the webhooks without their secrets.
+	ListWebhooks() ([]map[string]interface{}, error)
+	ExistsWebhook(id int64) bool
+	CreateWebhook(hook *Webhook, operator *Operator) (int64, error)
+	DeleteWebhook(id int64, operator *Operator) error
+	// ListWebhookDeliveries returns the latest deliveries of the webhook from the newest.
+	ListWebhookDeliveries(webhookId int64, limit int) ([]map[string]interface{}, error)
 }
 
 type App struct {
@@ -50,11 +117,44 @@ type ConfigFile struct {
 	Id          int64
 	Name        string
 	NamespaceId int64
+	Format      string
+	// Version is the latest revision of the file, which changes whenever the items change
+	Version int64
+	// Public files can be associated with the apps of other namespaces
+	Public bool
 
 	App   *App
 	Items []*ConfigItem
 }
 
+// AssociationError is returned when a file can't be associated with an app.
+type AssociationError struct {
+	FileId int64
+	Reason string
+}
+
+func (e *AssociationError) Error() string {
+	return fmt.Sprintf("config file [id=%d] %s", e.FileId, e.Reason)
+}
+
+// VersionConflictError is returned when a config file is updated on a version other than its current one.
+type VersionConflictError struct {
+	Version int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("config file has been changed, the current version is %d", e.Version)
+}
+
+// ChecksumConflictError is returned when an app is published with the checksum of a config other than its current one.
+type ChecksumConflictError struct {
+	Checksum string
+}
+
+func (e *ChecksumConflictError) Error() string {
+	return fmt.Sprintf("config of app has been changed, the current checksum is %s", e.Checksum)
+}
+
 // ConfigItem defines the related structure of the config_item table in db.
 type ConfigItem struct {
 	Id      int64
@@ -62,6 +162,45 @@ type ConfigItem struct {
 	Name    string
 	Value   string
 	Comment string
+	// Type is the type of the item of a yaml, json or toml file, e.g. ItemString
+	Type string
+}
+
+// Release status.
+const (
+	ReleaseNormal    = 0 // a full release
+	ReleaseGray      = 1 // an active gray release
+	ReleasePromoted  = 2 // a gray release promoted to a full one
+	ReleaseAbandoned = 3 // an abandoned gray release
+	ReleaseFailed    = 4 // a full release which failed to be published
+)
+
+// Release defines the related structure of the release table in db,
+// which records the content of an app published to etcd.
+type Release struct {
+	Id        int64
+	AppId     int64
+	Content   string
+	Revision  int64
+	Publisher string
+	Comment   string
+	Status    byte
+	Rule      *GrayRule
+	Ctime     time.Time
+}
+
+// ConfigFileRevision defines the related structure of the config_file_revision table in db,
+// which is an immutable snapshot of all the items of a config file.
+type ConfigFileRevision struct {
+	Id       int64
+	FileId   int64
+	Revision int64
+	Author   string
+	Ctime    time.Time
+	// Format is the format of the file, which isn't saved in the revision
+	Format string
+
+	Items []*ConfigItem
 }
 
 func (app *App) String() string {
@@ -72,6 +211,37 @@ func (app *App) Key() string {
 	return fmt.Sprintf("/%s/%s", "cflion", app.Name)
 }
 
+// GrayKey is the key of the gray release of the app in etcd.
+func (app *App) GrayKey() string {
+	return fmt.Sprintf("/%s/%s", "cflion-gray", app.Name)
+}
+
+// FileKey is the key of a config file of the app in etcd in the files layout.
+func (app *App) FileKey(name string) string {
+	return fmt.Sprintf("/%s/%s/%s", "cflion", app.Name, name)
+}
+
+// ManifestKey is the key of the Manifest of the app in etcd in the files layout.
+func (app *App) ManifestKey() string {
+	return fmt.Sprintf("/%s/%s", "cflion-manifest", app.Name)
+}
+
+// KeyPrefix is the common prefix of all the keys of the apps in etcd.
+const KeyPrefix = "/cflion"
+
+// AppNameOfKey returns the name of the app which the key in etcd belongs to.
+func AppNameOfKey(key string) (string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(key, "/"), "/", 3)
+	if len(parts) < 2 || len(parts[1]) == 0 {
+		return "", false
+	}
+	switch parts[0] {
+	case "cflion", "cflion-gray", "cflion-manifest":
+		return parts[1], true
+	}
+	return "", false
+}
+
 func (app *App) Brief() map[string]interface{} {
 	configFiles := make([]map[string]interface{}, 0, len(app.Files))
 	for _, file := range app.Files {
@@ -85,15 +255,31 @@ func (app *App) Brief() map[string]interface{} {
 	}
 }
 
+// ConfigFmt joins the files of the app in sections beginning with a [name] header, the headers of the files
+// not in properties format are [name:format], so the config of an app of properties files only is unchanged.
+// A properties file after one of another format has the [name:properties] header, as a line like [name]
+// belongs to the toml, yaml or json section before it.
 func (app *App) ConfigFmt() string {
+	explicit := false
 	arr := make([]string, 0, len(app.Files))
 	for _, cf := range app.Files {
-		s := fmt.Sprintf("[%s]\n%s\n", cf.Name, cf.ConfigFmt())
+		header := cf.Name
+		if format := cf.format(); format != FormatProperties || explicit {
+			header = fmt.Sprintf("%s:%s", cf.Name, format)
+			explicit = explicit || format != FormatProperties
+		}
+		s := fmt.Sprintf("[%s]\n%s\n", header, cf.ConfigFmt())
 		arr = append(arr, s)
 	}
 	return strings.Join(arr, "\n")
 }
 
+// Checksum returns the hex sha256 of the config of the app, which identifies the content to publish.
+func (app *App) Checksum() string {
+	sum := sha256.Sum256([]byte(app.ConfigFmt()))
+	return hex.EncodeToString(sum[:])
+}
+
 func (configFile *ConfigFile) String() string {
 	return fmt.Sprintf("ConfigFile {Id=%d | Name=%s | NamespaceId=%d | App=%s | Items=%s}", configFile.Id, configFile.Name, configFile.NamespaceId, configFile.App, configFile.Items)
 }
@@ -106,38 +292,129 @@ func (configFile *ConfigFile) FullName() string {
 	return fmt.Sprintf("%s/%s", configFile.Namespace(), configFile.Name)
 }
 
+// ConfigFmt renders the items in the format of the file, or in properties format if the items can't be rendered.
 func (configFile *ConfigFile) ConfigFmt() string {
-	arr := make([]string, 0, len(configFile.Items))
-	for _, item := range configFile.Items {
-		arr = append(arr, item.ConfigFmt())
+	content, err := RenderItems(configFile.format(), configFile.Items)
+	if err != nil {
+		log.Errorf("Render config file [id=%d] [name=%s] error: %s", configFile.Id, configFile.Name, err)
+		content, _ = RenderItems(FormatProperties, configFile.Items)
 	}
-	return strings.Join(arr, "\n")
+	return content
+}
+
+func (configFile *ConfigFile) format() string {
+	if len(configFile.Format) == 0 {
+		return FormatProperties
+	}
+	return configFile.Format
 }
 
 func (configFile *ConfigFile) Brief() map[string]interface{} {
 	return map[string]interface{}{
-		"id":        configFile.Id,
-		"name":      configFile.Name,
+		"id":           configFile.Id,
+		"name":         configFile.Name,
 		"namespace_id": configFile.NamespaceId,
-		"namespace": configFile.Namespace(),
-		"full_name": configFile.FullName(),
+		"namespace":    configFile.Namespace(),
+		"full_name":    configFile.FullName(),
+		"format":       configFile.format(),
+		"public":       configFile.Public,
 	}
 }
 
 func (configFile *ConfigFile) Detail() map[string]interface{} {
 	detail := configFile.Brief()
 	detail["config"] = configFile.ConfigFmt()
+	detail["version"] = configFile.Version
 	return detail
 }
 
 func (configItem *ConfigItem) String() string {
-	return fmt.Sprintf("ConfigItem {Id=%d | FileId=%d | Name=%s | Value=%s | Comment=%s}", configItem.Id, configItem.FileId, configItem.Name, configItem.Value, configItem.Comment)
+	return fmt.Sprintf("ConfigItem {Id=%d | FileId=%d | Name=%s | Value=%s | Comment=%s | Type=%s}", configItem.Id, configItem.FileId, configItem.Name, configItem.Value, configItem.Comment, configItem.Type)
+}
+
+func (configItem *ConfigItem) Detail() map[string]interface{} {
+	return map[string]interface{}{
+		"id":      configItem.Id,
+		"file_id": configItem.FileId,
+		"name":    configItem.Name,
+		"value":   configItem.Value,
+		"comment": configItem.Comment,
+		"type":    configItem.Type,
+	}
 }
 
+// Validate checks the name of the item is non-empty without leading or trailing spaces and line breaks,
+// and the type is a type of items.
+func (configItem *ConfigItem) Validate() error {
+	name := strings.TrimSpace(configItem.Name)
+	if len(name) == 0 || name != configItem.Name || strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("invalid item name [%s]: must be non-empty without leading or trailing spaces and line breaks", configItem.Name)
+	}
+	if !ValidItemType(configItem.Type) {
+		return fmt.Errorf("invalid item type [%s]", configItem.Type)
+	}
+	return nil
+}
+
+// ConfigFmt writes the item as a key=value line with the escapes of properties, the comment lines
+// begin with '#'.
 func (configItem *ConfigItem) ConfigFmt() string {
 	var prefix string
 	if len(configItem.Comment) > 0 {
-		prefix = fmt.Sprintf("# %s\n", configItem.Comment)
+		for _, line := range strings.Split(configItem.Comment, "\n") {
+			prefix += fmt.Sprintf("# %s\n", strings.TrimSpace(line))
+		}
 	}
-	return fmt.Sprintf("%s%s=%s", prefix, configItem.Name, configItem.Value)
+	return fmt.Sprintf("%s%s=%s", prefix, escapeKey(configItem.Name), escapeValue(configItem.Value))
+}
+
+func (revision *ConfigFileRevision) String() string {
+	return fmt.Sprintf("ConfigFileRevision {Id=%d | FileId=%d | Revision=%d | Author=%s | Ctime=%s | Items=%s}", revision.Id, revision.FileId, revision.Revision, revision.Author, revision.Ctime, revision.Items)
+}
+
+func (revision *ConfigFileRevision) ConfigFmt() string {
+	cf := &ConfigFile{Id: revision.FileId, Format: revision.Format, Items: revision.Items}
+	return cf.ConfigFmt()
+}
+
+func (revision *ConfigFileRevision) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       revision.Id,
+		"file_id":  revision.FileId,
+		"revision": revision.Revision,
+		"author":   revision.Author,
+		"ctime":    revision.Ctime,
+	}
+}
+
+func (revision *ConfigFileRevision) Detail() map[string]interface{} {
+	detail := revision.Brief()
+	detail["config"] = revision.ConfigFmt()
+	return detail
+}
+
+func (release *Release) String() string {
+	return fmt.Sprintf("Release {Id=%d | AppId=%d | Revision=%d | Publisher=%s | Comment=%s | Status=%d | Rule=%s | Ctime=%s}", release.Id, release.AppId, release.Revision, release.Publisher, release.Comment, release.Status, release.Rule, release.Ctime)
+}
+
+func (release *Release) Brief() map[string]interface{} {
+	brief := map[string]interface{}{
+		"id":        release.Id,
+		"app_id":    release.AppId,
+		"revision":  release.Revision,
+		"publisher": release.Publisher,
+		"comment":   release.Comment,
+		"status":    release.Status,
+		"ctime":     release.Ctime,
+	}
+	if release.Rule != nil {
+		brief["rule"] = release.Rule
+	}
+	return brief
+}
+
+func (release *Release) Detail() map[string]interface{} {
+	detail := release.Brief()
+	detail["config"] = release.Content
+	return detail
 }
@@ -0,0 +1,122 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Events notified to the webhooks, an app is published whenever the config of its instances changes, including
+// the gray releases and rollbacks, and it's outdated after its files or association change.
+const (
+	EventAppPublished = "app.published"
+	EventAppOutdated  = "app.outdated"
+)
+
+// Webhook subscribes the events of the app, or of all the apps if AppId is 0, and empty Events subscribes
+// all the events. The payloads posted to Url are signed by Secret, which is never encoded into json.
+type Webhook struct {
+	Id     int64
+	AppId  int64
+	Url    string
+	Secret string `json:"-"`
+	Events []string
+	Ctime  time.Time
+}
+
+// WebhookEvent is the json payload posted to the webhooks, Action is the audit action causing the event.
+type WebhookEvent struct {
+	Event     string                 `json:"event"`
+	AppId     int64                  `json:"app_id"`
+	App       string                 `json:"app"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestId string                 `json:"request_id"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Time      time.Time              `json:"time"`
+}
+
+// WebhookDelivery is an attempt to post an event to a webhook, StatusCode is 0 if the webhook didn't respond,
+// and Error tells why.
+type WebhookDelivery struct {
+	Id         int64
+	WebhookId  int64
+	Event      string
+	Payload    string
+	Attempt    int
+	StatusCode int
+	Response   string
+	Error      string
+	Duration   time.Duration
+	Ctime      time.Time
+}
+
+// ValidEvent reports whether the event can be subscribed.
+func ValidEvent(event string) bool {
+	return event == EventAppPublished || event == EventAppOutdated
+}
+
+func (hook *Webhook) String() string {
+	return fmt.Sprintf("Webhook {Id=%d | AppId=%d | Url=%s | Events=%v}", hook.Id, hook.AppId, hook.Url, hook.Events)
+}
+
+// Brief returns the webhook without the secret.
+func (hook *Webhook) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"id":     hook.Id,
+		"app_id": hook.AppId,
+		"url":    hook.Url,
+		"events": hook.Events,
+		"ctime":  hook.Ctime,
+	}
+}
+
+// Subscribes reports whether the webhook subscribes the event.
+func (hook *Webhook) Subscribes(event *WebhookEvent) bool {
+	if hook.AppId > 0 && hook.AppId != event.AppId {
+		return false
+	}
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// Succeeded reports whether the webhook responded 2xx.
+func (delivery *WebhookDelivery) Succeeded() bool {
+	return delivery.StatusCode >= 200 && delivery.StatusCode < 300
+}
+
+func (delivery *WebhookDelivery) Brief() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          delivery.Id,
+		"webhook_id":  delivery.WebhookId,
+		"event":       delivery.Event,
+		"payload":     delivery.Payload,
+		"attempt":     delivery.Attempt,
+		"status_code": delivery.StatusCode,
+		"response":    delivery.Response,
+		"error":       delivery.Error,
+		"succeeded":   delivery.Succeeded(),
+		"duration_ms": int64(delivery.Duration / time.Millisecond),
+		"ctime":       delivery.Ctime,
+	}
+}
@@ -16,6 +16,8 @@ package restful
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/cflion/cflion/pkg/log"
 	"github.com/gin-gonic/gin"
 	"io"
@@ -25,6 +27,12 @@ import (
 	"time"
 )
 
+// writerKey keeps the response writer of the http server in the request context, which gin doesn't expose.
+type writerKey struct{}
+
+// RequestIdHeader carries the id of a request, which is kept across the servers for tracing and auditing.
+const RequestIdHeader = "X-Request-Id"
+
 type ResponseRet struct {
 	Msg  string      `json:"msg,omitempty"`
 	Data interface{} `json:"data,omitempty"`
@@ -59,10 +67,13 @@ func NewServer(cfg *ServerConfig, register func(router *gin.Engine)) *Server {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
+	router.Use(RequestId())
 	register(router)
 	srv := &http.Server{
-		Addr:    cfg.ListenAddr,
-		Handler: router,
+		Addr: cfg.ListenAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			router.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), writerKey{}, w)))
+		}),
 	}
 	if cfg.ReadTimeout > 0 {
 		srv.ReadTimeout = cfg.ReadTimeout
@@ -86,6 +97,11 @@ func (server *Server) Start() {
 	}()
 }
 
+// OnShutdown registers the function called when the server starts to shut down, e.g. to end the long running requests.
+func (server *Server) OnShutdown(f func()) {
+	server.srv.RegisterOnShutdown(f)
+}
+
 // Stop server.
 func (server *Server) Stop() <-chan struct{} {
 	ch := make(chan struct{})
@@ -104,3 +120,26 @@ func (server *Server) Stop() <-chan struct{} {
 	}(ch)
 	return ch
 }
+
+// SetWriteDeadline replaces the WriteTimeout of the server for the current request only, e.g. to respond a long polling request.
+func SetWriteDeadline(ctx *gin.Context, deadline time.Time) error {
+	w, ok := ctx.Request.Context().Value(writerKey{}).(http.ResponseWriter)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}
+
+// RequestId sets a random id to the requests without one, the id is returned in the response header.
+func RequestId() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIdHeader)
+		if len(id) == 0 {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			ctx.Request.Header.Set(RequestIdHeader, id)
+		}
+		ctx.Header(RequestIdHeader, id)
+	}
+}
@@ -0,0 +1,58 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package restful
+
+import (
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetWriteDeadline(t *testing.T) {
+	server := NewServer(&ServerConfig{}, func(router *gin.Engine) {
+		router.GET("/slow", func(ctx *gin.Context) {
+			time.Sleep(300 * time.Millisecond)
+			ctx.String(http.StatusOK, "slow")
+		})
+		router.GET("/poll", func(ctx *gin.Context) {
+			if err := SetWriteDeadline(ctx, time.Now().Add(time.Second)); err != nil {
+				t.Errorf("set write deadline error: %s", err)
+			}
+			time.Sleep(300 * time.Millisecond)
+			ctx.String(http.StatusOK, "poll")
+		})
+	})
+	ts := httptest.NewUnstartedServer(server.srv.Handler)
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	// the write timeout of the server still applies to the other routes
+	if resp, err := http.Get(ts.URL + "/slow"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expect the slow response is cut by the write timeout, got %d", resp.StatusCode)
+	}
+	resp, err := http.Get(ts.URL + "/poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "poll" {
+		t.Errorf("unexpected poll response %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,210 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package signature signs the requests between the cflion servers and clients with a shared secret,
+// and the payloads posted to the webhooks with their secrets.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the scheme of the Authorization header of a signed request, the credentials are
+// <unix timestamp>:<nonce>:<hex signature>.
+const Scheme = "CFLION-HMAC-SHA256"
+
+// OperatorHeader carries the name of the user who performs the request, it is covered by the signature.
+const OperatorHeader = "X-Cflion-Operator"
+
+// MaxBodySize is the max size of the body of a request VerifyRequest reads, a larger one is rejected with ErrBodyTooLarge.
+const MaxBodySize = 8 << 20
+
+// ErrBodyTooLarge is returned by VerifyRequest when the body is larger than MaxBodySize.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Headers of a signed payload, the signature is sha256=<hex signature of the timestamp and body>.
+const (
+	PayloadTimestampHeader = "X-Cflion-Timestamp"
+	PayloadSignatureHeader = "X-Cflion-Signature"
+)
+
+// Sign computes the hmac-sha256 of the method, request uri, timestamp, nonce, operator and the sha256
+// of the body with the secret.
+func Sign(secret, method, uri string, timestamp int64, nonce, operator string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, uri, strconv.FormatInt(timestamp, 10), nonce, operator, hex.EncodeToString(digest[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the operator and the Authorization header of the request signed by the secret, the body
+// of the request is read and kept for sending.
+func SignRequest(req *http.Request, secret, operator string) error {
+	if len(operator) > 0 {
+		req.Header.Set(OperatorHeader, operator)
+	}
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	signature := Sign(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, req.Header.Get(OperatorHeader), body)
+	req.Header.Set("Authorization", Scheme+" "+strconv.FormatInt(timestamp, 10)+":"+nonce+":"+signature)
+	return nil
+}
+
+// VerifyRequest checks the signature of the request, the timestamp must be within skew from now. The nonce
+// is rejected if it's already used by nonces, nonces nil doesn't check the nonce.
+func VerifyRequest(req *http.Request, secret string, skew time.Duration, nonces *Nonces) error {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, Scheme+" ") {
+		return errors.New("missing signature")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(auth, Scheme+" "), ":", 3)
+	if len(parts) != 3 || len(parts[1]) == 0 {
+		return errors.New("malformed signature")
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	if expired(timestamp, skew) {
+		return errors.New("signature expired")
+	}
+	// the body is read before the signature is checked, so it's limited
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = http.MaxBytesReader(nil, req.Body, MaxBodySize)
+	}
+	body, err := readBody(req)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return ErrBodyTooLarge
+	} else if err != nil {
+		return err
+	}
+	expected := Sign(secret, req.Method, req.URL.RequestURI(), timestamp, parts[1], req.Header.Get(OperatorHeader), body)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	// the nonce is kept until the timestamp expires, after which the signature is rejected anyway
+	if nonces != nil && !nonces.use(parts[1], time.Unix(timestamp, 0).Add(skew)) {
+		return errors.New("signature replayed")
+	}
+	return nil
+}
+
+// Nonces remembers the nonces of the verified requests until their timestamps expire, so that a signed
+// request can't be replayed to the same server.
+type Nonces struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewNonces returns the nonces of a server.
+func NewNonces() *Nonces {
+	return &Nonces{seen: make(map[string]time.Time), pruned: time.Now()}
+}
+
+// use records the nonce until expire, it returns false if the nonce is already recorded.
+func (nonces *Nonces) use(nonce string, expire time.Time) bool {
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	now := time.Now()
+	if now.Sub(nonces.pruned) > time.Minute {
+		for n, e := range nonces.seen {
+			if now.After(e) {
+				delete(nonces.seen, n)
+			}
+		}
+		nonces.pruned = now
+	}
+	if _, ok := nonces.seen[nonce]; ok {
+		return false
+	}
+	nonces.seen[nonce] = expire
+	return true
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// readBody reads the body of the request and puts it back to be read again.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// SignPayload computes the hmac-sha256 of the timestamp and the body with the secret.
+func SignPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPayloadRequest sets the timestamp and signature headers of the request which posts the body.
+func SignPayloadRequest(req *http.Request, secret string, body []byte) {
+	timestamp := time.Now().Unix()
+	req.Header.Set(PayloadTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(PayloadSignatureHeader, "sha256="+SignPayload(secret, timestamp, body))
+}
+
+// VerifyPayload checks the signature of the body posted by the request, the timestamp must be within skew from now.
+func VerifyPayload(req *http.Request, body []byte, secret string, skew time.Duration) error {
+	timestamp, err := strconv.ParseInt(req.Header.Get(PayloadTimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("missing signature timestamp")
+	}
+	if expired(timestamp, skew) {
+		return errors.New("signature expired")
+	}
+	expected := "sha256=" + SignPayload(secret, timestamp, body)
+	if !hmac.Equal([]byte(req.Header.Get(PayloadSignatureHeader)), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func expired(timestamp int64, skew time.Duration) bool {
+	d := time.Since(time.Unix(timestamp, 0))
+	return d > skew || d < -skew
+}
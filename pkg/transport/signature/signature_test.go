@@ -0,0 +1,119 @@
+//  Copyright (c) 2018 The cflion Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package signature
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/v1/apps/demo?force=true", strings.NewReader(`{"config_files":[1]}`))
+	if err := SignRequest(req, "secret", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get(OperatorHeader) != "alice" {
+		t.Fatalf("expect operator alice, got %s", req.Header.Get(OperatorHeader))
+	}
+	if err := VerifyRequest(req, "secret", time.Minute, nil); err != nil {
+		t.Fatalf("expect valid signature: %s", err)
+	}
+	if err := VerifyRequest(req, "other", time.Minute, nil); err == nil {
+		t.Error("expect error with another secret")
+	}
+	// the body is kept for the handler
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != `{"config_files":[1]}` {
+		t.Errorf("expect the body kept, got %s", body)
+	}
+
+	// the operator, method, uri and body are covered by the signature
+	tampered := []func(req *http.Request){
+		func(req *http.Request) { req.Header.Set(OperatorHeader, "bob") },
+		func(req *http.Request) { req.Method = "GET" },
+		func(req *http.Request) { req.URL.RawQuery = "force=false" },
+		func(req *http.Request) { req.Body = ioutil.NopCloser(strings.NewReader(`{"config_files":[2]}`)) },
+	}
+	for i, tamper := range tampered {
+		req := httptest.NewRequest("PUT", "/v1/apps/demo?force=true", strings.NewReader(`{"config_files":[1]}`))
+		SignRequest(req, "secret", "alice")
+		tamper(req)
+		if err := VerifyRequest(req, "secret", time.Minute, nil); err == nil {
+			t.Errorf("case %d: expect error for the tampered request", i)
+		}
+	}
+
+	// the signed request is accepted only once
+	nonces := NewNonces()
+	signed := httptest.NewRequest("DELETE", "/v1/apps/demo", nil)
+	SignRequest(signed, "secret", "alice")
+	if err := VerifyRequest(signed, "secret", time.Minute, nonces); err != nil {
+		t.Fatalf("expect valid signature: %s", err)
+	}
+	if err := VerifyRequest(signed, "secret", time.Minute, nonces); err == nil {
+		t.Error("expect error for the replayed request")
+	}
+	another := httptest.NewRequest("DELETE", "/v1/apps/demo", nil)
+	SignRequest(another, "secret", "alice")
+	if err := VerifyRequest(another, "secret", time.Minute, nonces); err != nil {
+		t.Errorf("expect the request signed again accepted: %s", err)
+	}
+
+	// the body larger than MaxBodySize isn't read in full
+	large := httptest.NewRequest("PUT", "/v1/config-files/1", strings.NewReader(strings.Repeat("a", MaxBodySize+1)))
+	SignRequest(large, "secret", "alice")
+	if err := VerifyRequest(large, "secret", time.Minute, nil); err != ErrBodyTooLarge {
+		t.Errorf("expect ErrBodyTooLarge, got %v", err)
+	}
+
+	expired := httptest.NewRequest("GET", "/v1/watchers?app=demo", nil)
+	timestamp := time.Now().Add(-time.Hour).Unix()
+	expired.Header.Set("Authorization", fmt.Sprintf("%s %d:n1:%s", Scheme, timestamp, Sign("secret", "GET", "/v1/watchers?app=demo", timestamp, "n1", "", nil)))
+	if err := VerifyRequest(expired, "secret", time.Minute, nil); err == nil {
+		t.Error("expect error for the expired signature")
+	}
+	for _, auth := range []string{"", "Bearer token", Scheme + " nonsense", Scheme + " abc:def", Scheme + " 1:def"} {
+		req := httptest.NewRequest("GET", "/v1/watchers", nil)
+		req.Header.Set("Authorization", auth)
+		if err := VerifyRequest(req, "secret", time.Minute, nil); err == nil {
+			t.Errorf("expect error for Authorization [%s]", auth)
+		}
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	body := []byte(`{"event":"app.published"}`)
+	req := httptest.NewRequest("POST", "/hook", nil)
+	SignPayloadRequest(req, "secret", body)
+	if err := VerifyPayload(req, body, "secret", time.Minute); err != nil {
+		t.Fatalf("expect valid signature: %s", err)
+	}
+	if err := VerifyPayload(req, body, "other", time.Minute); err == nil {
+		t.Error("expect error with another secret")
+	}
+	if err := VerifyPayload(req, []byte(`{"event":"app.outdated"}`), "secret", time.Minute); err == nil {
+		t.Error("expect error with a tampered body")
+	}
+	old := time.Now().Add(-time.Hour).Unix()
+	req.Header.Set(PayloadTimestampHeader, fmt.Sprintf("%d", old))
+	req.Header.Set(PayloadSignatureHeader, "sha256="+SignPayload("secret", old, body))
+	if err := VerifyPayload(req, body, "secret", time.Minute); err == nil {
+		t.Error("expect error with an expired timestamp")
+	}
+}
@@ -11,6 +11,57 @@ create table app (
   utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   primary key (id)
 )  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table app add unique index name_env_UNIQUE (name, env);
+
+create table user (
+  id bigint(20) not null auto_increment,
+  name varchar(45) not null comment 'user name',
+  admin tinyint(1) not null default 0 comment 'whether the user manages users and all apps, 1=yes, 0=no',
+  ctime datetime DEFAULT NULL,
+  utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  primary key (id)
+)  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table user add unique index name_UNIQUE (name);
+
+create table user_token (
+  id bigint(20) not null auto_increment,
+  user_id bigint(20) not null,
+  name varchar(45) not null comment 'what the token is used for',
+  hash char(64) not null comment 'hex sha256 of the token',
+  ctime datetime DEFAULT NULL,
+  utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  primary key (id)
+)  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table user_token add unique index hash_UNIQUE (hash);
+alter table user_token add index user_id_INDEX (user_id);
+
+create table app_role (
+  id bigint(20) not null auto_increment,
+  user_id bigint(20) not null,
+  app_id bigint(20) not null,
+  role tinyint(2) not null comment '1=viewer, 2=editor, 3=publisher',
+  ctime datetime DEFAULT NULL,
+  utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  primary key (id)
+)  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table app_role add unique index user_app_UNIQUE (user_id, app_id);
+alter table app_role add index app_id_INDEX (app_id);
+
+create table publish_request (
+  id bigint(20) not null auto_increment,
+  app_id bigint(20) not null,
+  comment varchar(256) not null default '',
+  gray varchar(1024) not null default '' comment 'json of the gray rule, empty for a full release',
+  checksum varchar(64) not null default '' comment 'sha256 of the app config to publish',
+  requester varchar(45) not null,
+  reviewer varchar(45) not null default '',
+  status tinyint(2) not null default 0 comment '0=pending, 1=approved, 2=rejected, 3=published, 4=expired',
+  expire_time datetime not null,
+  ctime datetime DEFAULT NULL,
+  utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  primary key (id)
+)  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table publish_request add index app_id_INDEX (app_id);
 
 # create table config (
 #   id bigint(20) not null auto_increment,
@@ -10,11 +10,15 @@ create table app (
   utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   primary key (id)
 )  ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table app add unique index name_UNIQUE (name);
 
 create table config_file (
   id bigint(20) not null auto_increment,
   name varchar(45) not null comment 'file name',
   namespace_id bigint(20) not null comment 'related the app id',
+  format varchar(16) not null default 'properties' comment 'properties, yaml, json or toml',
+  version bigint(20) not null default 0 comment 'the latest revision',
+  public tinyint(1) not null default 0 comment 'whether the apps of other namespaces can use the file',
   ctime datetime DEFAULT NULL,
   utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   primary key (id)
@@ -30,6 +34,7 @@ create table association (
   primary key (id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 alter table association add index appId_INDEX (app_id);
+alter table association add unique index appId_fileId_UNIQUE (app_id, file_id);
 
 create table config_item (
   id bigint(20) not null auto_increment,
@@ -37,12 +42,78 @@ create table config_item (
   name varchar(256) not null,
   value text not null,
   comment varchar(256) default null,
+  type varchar(16) not null default '' comment 'string, number, bool, null, datetime, map or array of a yaml, json or toml file, empty if untyped',
   ctime datetime DEFAULT NULL,
   utime timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   primary key (id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 alter table config_item add index fileId_INDEX (file_id);
 
+create table config_file_revision (
+  id bigint(20) not null auto_increment,
+  file_id bigint(20) not null,
+  revision bigint(20) not null comment 'revision of the file, starts from 1',
+  author varchar(45) not null comment 'who made the revision',
+  items mediumtext not null comment 'json of all the items of the file',
+  ctime datetime DEFAULT NULL,
+  primary key (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table config_file_revision add unique index fileId_revision_UNIQUE (file_id, revision);
+
+create table `release` (
+  id bigint(20) not null auto_increment,
+  app_id bigint(20) not null,
+  content mediumtext not null comment 'content published to etcd',
+  revision bigint(20) not null comment 'revision of etcd after publishing',
+  publisher varchar(45) not null,
+  comment varchar(256) not null default '',
+  status tinyint(2) not null default 0 comment '0=normal, 1=gray, 2=promoted gray, 3=abandoned gray',
+  rule varchar(1024) not null default '' comment 'json of the gray rule',
+  ctime datetime DEFAULT NULL,
+  primary key (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table `release` add index appId_INDEX (app_id);
+
+create table audit_event (
+  id bigint(20) not null auto_increment,
+  actor varchar(45) not null,
+  action varchar(45) not null comment 'one of the Action constants in pkg/manager/api/audit.go, e.g. publish_app',
+  app_id bigint(20) not null comment 'app of the target, the namespace app of a config file',
+  target varchar(256) not null,
+  before_summary varchar(1024) not null default '',
+  after_summary varchar(1024) not null default '',
+  request_id varchar(64) not null default '',
+  ctime datetime DEFAULT NULL,
+  primary key (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table audit_event add index appId_ctime_INDEX (app_id, ctime);
+alter table audit_event add index actor_ctime_INDEX (actor, ctime);
+
+create table webhook (
+  id bigint(20) not null auto_increment,
+  app_id bigint(20) not null default 0 comment 'app of the subscribed events, 0=all apps',
+  url varchar(1024) not null,
+  secret varchar(256) not null default '' comment 'signs the payloads, empty for unsigned',
+  events varchar(256) not null default '' comment 'comma separated subscribed events, empty for all',
+  ctime datetime DEFAULT NULL,
+  primary key (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+create table webhook_delivery (
+  id bigint(20) not null auto_increment,
+  webhook_id bigint(20) not null,
+  event varchar(45) not null,
+  payload text,
+  attempt int(11) not null,
+  status_code int(11) not null default 0 comment '0 if the webhook did not respond',
+  response varchar(1024) not null default '' comment 'the head of the response body',
+  error varchar(1024) not null default '',
+  duration_ms bigint(20) not null default 0,
+  ctime datetime DEFAULT NULL,
+  primary key (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+alter table webhook_delivery add index webhookId_INDEX (webhook_id);
+
 --
 -- create table config_group (
 --   id bigint(20) not null auto_increment,